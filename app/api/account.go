@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/mailer"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// minPasswordLength is the shortest password accepted at registration and reset
+	minPasswordLength = 8
+
+	// verifyEmailTTL is how long an email verification link stays valid
+	verifyEmailTTL = 48 * time.Hour
+
+	// resetPasswordTTL is how long a password reset link stays valid
+	resetPasswordTTL = time.Hour
+)
+
+// RegisterRequest represents the registration request body
+type RegisterRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// TokenRequest represents a request carrying a single-use token
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailRequest represents a request carrying only an email address
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents the password reset request body
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// errInvalidToken is returned for unknown, expired or already used tokens
+var errInvalidToken = errors.New("invalid or expired token")
+
+// RegisterHandler handles new customer registrations
+func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	// Parse request body
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "A valid email address is required")
+		return
+	}
+
+	if len(req.Password) < minPasswordLength {
+		lib.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	hashedPassword, err := lib.HashPassword(req.Password)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	user := db.User{
+		Email:    email,
+		Password: &hashedPassword,
+		Role:     db.RoleUser,
+	}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		user.Name = &name
+	}
+
+	// The unique email index rejects duplicate accounts, including concurrent sign-ups
+	if err := db.DB.Create(&user).Error; err != nil {
+		respondWithAPIError(w, conflictOnDuplicate(err, "An account with this email already exists"), "Failed to create account")
+		return
+	}
+
+	// Send the verification email; the account exists even if this fails
+	if err := sendVerificationEmail(r, user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	lib.RespondWithSuccess(w, http.StatusCreated, user)
+}
+
+// VerifyEmailHandler marks a user's email as verified using the emailed token
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		lib.RespondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, req.Token, db.TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&db.User{}).Where("_id = ?", token.UserID).
+			Update("email_verified", time.Now()).Error
+	})
+	if err != nil {
+		respondWithTokenError(w, err)
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Email verified"})
+}
+
+// ResendVerificationHandler sends a fresh verification email
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Always answer the same way so addresses cannot be probed
+	if email, err := normalizeEmail(req.Email); err == nil {
+		var user db.User
+		if err := db.DB.Where("email = ?", email).First(&user).Error; err == nil && user.EmailVerified == nil {
+			if err := sendVerificationEmail(r, user); err != nil {
+				log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+			}
+		}
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "If the account exists and is unverified, a verification email has been sent"})
+}
+
+// ForgotPasswordHandler emails a password reset link
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Always answer the same way so addresses cannot be probed
+	if email, err := normalizeEmail(req.Email); err == nil {
+		var user db.User
+		if err := db.DB.Where("email = ?", email).First(&user).Error; err == nil {
+			if err := sendPasswordResetEmail(r, user); err != nil {
+				log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+			}
+		}
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "If the account exists, a password reset email has been sent"})
+}
+
+// ResetPasswordHandler sets a new password using the emailed token
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		lib.RespondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	if len(req.Password) < minPasswordLength {
+		lib.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	hashedPassword, err := lib.HashPassword(req.Password)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, req.Token, db.TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&db.User{}).Where("_id = ?", token.UserID).
+			Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+
+		// Any other outstanding reset links die with this one
+		if err := tx.Model(&db.UserToken{}).
+			Where("\"userId\" = ? AND purpose = ? AND used_at IS NULL", token.UserID, db.TokenPurposeResetPassword).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Sign out every device that used the old password
+		return revokeSessions(tx.Where("\"userId\" = ?", token.UserID))
+	})
+	if err != nil {
+		respondWithTokenError(w, err)
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
+
+// allowPost sets CORS headers, answers preflight requests and rejects non-POST methods
+func allowPost(w http.ResponseWriter, r *http.Request) bool {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	// Only allow POST requests
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return false
+	}
+
+	return true
+}
+
+// normalizeEmail validates an email address and lowercases it
+func normalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// createUserToken stores a new single-use token and returns its plain value
+func createUserToken(userID uuid.UUID, purpose db.TokenPurpose, ttl time.Duration) (string, error) {
+	plain, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	token := db.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: lib.HashToken(plain),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.DB.Create(&token).Error; err != nil {
+		return "", err
+	}
+
+	return plain, nil
+}
+
+// consumeUserToken looks up an unused, unexpired token and marks it used
+func consumeUserToken(tx *gorm.DB, plain string, purpose db.TokenPurpose) (*db.UserToken, error) {
+	var token db.UserToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(&db.UserToken{TokenHash: lib.HashToken(plain), Purpose: purpose}).
+		First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidToken
+		}
+		return nil, err
+	}
+
+	if token.UsedAt != nil || time.Now().After(token.ExpiresAt) {
+		return nil, errInvalidToken
+	}
+
+	now := time.Now()
+	if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	token.UsedAt = &now
+
+	return &token, nil
+}
+
+// respondWithTokenError maps token errors to HTTP responses
+func respondWithTokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidToken) {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+	lib.RespondWithError(w, http.StatusInternalServerError, "Failed to process token")
+}
+
+// sendVerificationEmail emails a link for confirming the user's address
+func sendVerificationEmail(r *http.Request, user db.User) error {
+	token, err := createUserToken(user.ID, db.TokenPurposeVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/verify-email?token=" + token
+	return mailer.Default().Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Beauty Shop email address",
+		Body: "Welcome to Beauty Shop!\n\n" +
+			"Please confirm your email address by opening the link below:\n\n" +
+			link + "\n\n" +
+			"This link expires in 48 hours.",
+	})
+}
+
+// sendPasswordResetEmail emails a link for choosing a new password
+func sendPasswordResetEmail(r *http.Request, user db.User) error {
+	token, err := createUserToken(user.ID, db.TokenPurposeResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL() + "/reset-password?token=" + token
+	return mailer.Default().Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Beauty Shop password",
+		Body: "We received a request to reset your password.\n\n" +
+			"Choose a new password by opening the link below:\n\n" +
+			link + "\n\n" +
+			"This link expires in 1 hour. If you did not ask for a reset, you can ignore this email.",
+	})
+}
+
+// appURL returns the storefront base URL used in emailed links
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:3000"
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/mailer"
+	"beauty-shop/lib"
+)
+
+// useFileMailer sends the test's email to a file and returns its path
+func useFileMailer(t *testing.T) string {
+	t.Helper()
+
+	previous := mailer.Default()
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer.SetDefault(&mailer.FileMailer{Path: path, From: "Beauty Shop <no-reply@beautyshop.com>"})
+	t.Cleanup(func() { mailer.SetDefault(previous) })
+	return path
+}
+
+var mailedToken = regexp.MustCompile(`/(verify-email|reset-password)\?token=([0-9a-f]+)`)
+
+// lastMailedToken returns the token in the most recent link of kind mailed to path
+func lastMailedToken(t *testing.T, path, kind string) string {
+	t.Helper()
+
+	mail, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mail: %v", err)
+	}
+
+	token := ""
+	for _, match := range mailedToken.FindAllStringSubmatch(string(mail), -1) {
+		if match[1] == kind {
+			token = match[2]
+		}
+	}
+	if token == "" {
+		t.Fatalf("no %s link was mailed", kind)
+	}
+	return token
+}
+
+// postJSON calls handler with body encoded as a JSON POST
+func postJSON(t *testing.T, handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload)))
+	return rec
+}
+
+func TestAccountEmailFlow(t *testing.T) {
+	openTestDB(t)
+	mail := useFileMailer(t)
+
+	expectStatus := func(step string, rec *httptest.ResponseRecorder, want int) {
+		t.Helper()
+		if rec.Code != want {
+			t.Fatalf("%s: status code = %d, want %d: %s", step, rec.Code, want, rec.Body)
+		}
+	}
+
+	register := RegisterRequest{Name: "Achieng Otieno", Email: " Achieng@Example.com ", Password: "first-password"}
+	expectStatus("register", postJSON(t, RegisterHandler, register), http.StatusCreated)
+	expectStatus("register again", postJSON(t, RegisterHandler, register), http.StatusConflict)
+
+	var user db.User
+	if err := db.DB.Where("email = ?", "achieng@example.com").First(&user).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+
+	// Verification links work once
+	verifyToken := lastMailedToken(t, mail, "verify-email")
+	expectStatus("verify", postJSON(t, VerifyEmailHandler, TokenRequest{Token: verifyToken}), http.StatusOK)
+	expectStatus("verify again", postJSON(t, VerifyEmailHandler, TokenRequest{Token: verifyToken}), http.StatusBadRequest)
+
+	if err := db.DB.First(&user, "_id = ?", user.ID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if user.EmailVerified == nil {
+		t.Error("email is not marked verified")
+	}
+
+	// A verification token cannot reset the password
+	reset := ResetPasswordRequest{Token: verifyToken, Password: "second-password"}
+	expectStatus("reset with a verification token", postJSON(t, ResetPasswordHandler, reset), http.StatusBadRequest)
+
+	// Expired reset links are refused
+	expectStatus("forgot", postJSON(t, ForgotPasswordHandler, EmailRequest{Email: "achieng@example.com"}), http.StatusOK)
+	reset.Token = lastMailedToken(t, mail, "reset-password")
+	if err := db.DB.Model(&db.UserToken{}).Where("token_hash = ?", lib.HashToken(reset.Token)).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("expire token: %v", err)
+	}
+	expectStatus("reset with an expired token", postJSON(t, ResetPasswordHandler, reset), http.StatusBadRequest)
+
+	// A fresh link resets the password once
+	expectStatus("forgot again", postJSON(t, ForgotPasswordHandler, EmailRequest{Email: "achieng@example.com"}), http.StatusOK)
+	reset.Token = lastMailedToken(t, mail, "reset-password")
+	expectStatus("reset", postJSON(t, ResetPasswordHandler, reset), http.StatusOK)
+	expectStatus("reset again", postJSON(t, ResetPasswordHandler, reset), http.StatusBadRequest)
+
+	if err := db.DB.First(&user, "_id = ?", user.ID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if user.Password == nil || !lib.CheckPasswordHash("second-password", *user.Password) {
+		t.Error("password was not changed")
+	}
+
+	// Unknown addresses get the same answer and no email
+	before, _ := os.ReadFile(mail)
+	expectStatus("forgot for an unknown address", postJSON(t, ForgotPasswordHandler, EmailRequest{Email: "nobody@example.com"}), http.StatusOK)
+	after, _ := os.ReadFile(mail)
+	if len(after) != len(before) {
+		t.Error("an email was sent for an unknown address")
+	}
+}
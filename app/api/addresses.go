@@ -0,0 +1,368 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddressRequest represents an address to save or to ship an order to.
+// County may also be given as state; Type and IsDefault only apply to saved
+// addresses.
+type AddressRequest struct {
+	Name      string         `json:"name"`
+	Street    string         `json:"street"`
+	City      string         `json:"city"`
+	County    string         `json:"county"`
+	State     string         `json:"state"`
+	Zip       string         `json:"zip"`
+	Country   string         `json:"country"`
+	Phone     string         `json:"phone"`
+	Type      db.AddressType `json:"type"`
+	IsDefault bool           `json:"isDefault"`
+}
+
+// AddressesHandler lists the caller's saved addresses (GET) or adds one (POST)
+func AddressesHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		addresses := []db.Address{}
+		if err := db.DB.Where("\"userId\" = ?", userID).
+			Order("is_default DESC, created_at DESC").Find(&addresses).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch addresses")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, addresses)
+
+	case "POST":
+		var req AddressRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		address, err := req.toAddress()
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save address")
+			return
+		}
+		address.UserID = userID
+
+		if err := saveAddress(userID, &address, req.IsDefault); err != nil {
+			respondWithAPIError(w, err, "Failed to save address")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusCreated, address)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// AddressHandler reads, replaces or deletes one of the caller's saved addresses
+func AddressHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	addressID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid address ID")
+		return
+	}
+
+	var address db.Address
+	if err := findAddress(db.DB, userID, addressID, &address); err != nil {
+		respondWithAPIError(w, err, "Failed to save address")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		lib.RespondWithSuccess(w, http.StatusOK, address)
+
+	case "PUT":
+		var req AddressRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		updated, err := req.toAddress()
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save address")
+			return
+		}
+		updated.Base = address.Base
+		updated.UserID = userID
+
+		if err := saveAddress(userID, &updated, req.IsDefault); err != nil {
+			respondWithAPIError(w, err, "Failed to save address")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, updated)
+
+	case "DELETE":
+		// Orders keep their own copy of the address
+		if err := db.DB.Delete(&address).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to delete address")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Address deleted"})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// toAddress validates the request and returns it as a normalized address in
+// one of the delivery countries. Kenyan addresses need a known county and a
+// Kenyan mobile number, stored in +254 form; elsewhere the state or region is
+// free text and the phone may be any number given with its country code.
+func (req AddressRequest) toAddress() (db.Address, error) {
+	address := db.Address{
+		Name:   strings.TrimSpace(req.Name),
+		Street: strings.TrimSpace(req.Street),
+		City:   strings.TrimSpace(req.City),
+		Zip:    strings.TrimSpace(req.Zip),
+		Type:   req.Type,
+	}
+
+	switch {
+	case address.Name == "":
+		return address, &apiError{http.StatusBadRequest, "Name is required"}
+	case address.Street == "":
+		return address, &apiError{http.StatusBadRequest, "Street is required"}
+	case address.City == "":
+		return address, &apiError{http.StatusBadRequest, "City is required"}
+	}
+
+	code, country, ok := normalizeCountry(req.Country)
+	if !ok {
+		return address, &apiError{http.StatusBadRequest, "We only deliver to Kenya, Uganda, Tanzania, Rwanda, Burundi and South Sudan"}
+	}
+	address.Country = country
+
+	county := req.County
+	if county == "" {
+		county = req.State
+	}
+	county = strings.TrimSpace(county)
+
+	if code == "KE" {
+		if county == "" {
+			return address, &apiError{http.StatusBadRequest, "County is required"}
+		}
+		if address.State, ok = normalizeCounty(county); !ok {
+			return address, &apiError{http.StatusBadRequest, "Unknown county: " + county}
+		}
+		if address.Zip != "" && (len(address.Zip) != 5 || strings.Trim(address.Zip, "0123456789") != "") {
+			return address, &apiError{http.StatusBadRequest, "Postal code must be 5 digits"}
+		}
+		if address.Phone, ok = normalizeKenyanPhone(req.Phone); !ok {
+			return address, &apiError{http.StatusBadRequest, "Phone must be a Kenyan mobile number such as 0712345678"}
+		}
+	} else {
+		if county == "" {
+			return address, &apiError{http.StatusBadRequest, "State or region is required"}
+		}
+		address.State = county
+		if len(address.Zip) > 10 {
+			return address, &apiError{http.StatusBadRequest, "Postal code is too long"}
+		}
+		// Local forms like 07... mean different numbers in each country
+		if address.Phone, ok = normalizeInternationalPhone(req.Phone); !ok {
+			return address, &apiError{http.StatusBadRequest, "Phone must include the country code, such as +256712345678"}
+		}
+	}
+
+	if address.Type == "" {
+		address.Type = db.AddressTypeShipping
+	}
+	if address.Type != db.AddressTypeShipping && address.Type != db.AddressTypeBilling && address.Type != db.AddressTypeBoth {
+		return address, &apiError{http.StatusBadRequest, "Type must be SHIPPING, BILLING or BOTH"}
+	}
+
+	return address, nil
+}
+
+// saveAddress creates or replaces an address. It becomes the default for its
+// type when asked to or when the user has no default for that type yet; any
+// other default it overlaps with is cleared, so each type has one default.
+func saveAddress(userID uuid.UUID, address *db.Address, makeDefault bool) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		// Serialize address changes per user
+		var user db.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("_id").First(&user, "_id = ?", userID).Error; err != nil {
+			return err
+		}
+
+		// Defaults of this address's type held by the user's other addresses
+		otherDefaults := func() *gorm.DB {
+			query := tx.Model(&db.Address{}).Where("\"userId\" = ? AND type IN ? AND is_default = ?",
+				userID, overlappingAddressTypes(address.Type), true)
+			if address.ID != uuid.Nil {
+				query = query.Where("_id <> ?", address.ID)
+			}
+			return query
+		}
+
+		if !makeDefault {
+			var defaults int64
+			if err := otherDefaults().Count(&defaults).Error; err != nil {
+				return err
+			}
+			makeDefault = defaults == 0
+		}
+
+		if makeDefault {
+			if err := otherDefaults().Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		address.IsDefault = makeDefault
+
+		if address.ID == uuid.Nil {
+			return tx.Create(address).Error
+		}
+		return tx.Model(address).
+			Select("name", "street", "city", "state", "zip", "country", "phone", "type", "is_default", "updated_at").
+			Updates(address).Error
+	})
+}
+
+// overlappingAddressTypes returns the types whose default an address of type t would replace
+func overlappingAddressTypes(t db.AddressType) []db.AddressType {
+	switch t {
+	case db.AddressTypeBoth:
+		return []db.AddressType{db.AddressTypeShipping, db.AddressTypeBilling, db.AddressTypeBoth}
+	default:
+		return []db.AddressType{t, db.AddressTypeBoth}
+	}
+}
+
+// findAddress loads one of the user's saved addresses
+func findAddress(tx *gorm.DB, userID, addressID uuid.UUID, address *db.Address) error {
+	if err := tx.Where("_id = ? AND \"userId\" = ?", addressID, userID).First(address).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &apiError{http.StatusNotFound, "Address not found"}
+		}
+		return err
+	}
+	return nil
+}
+
+// resolveOrderAddresses validates the shipping address, which is required,
+// and the optional billing address of an order request
+func resolveOrderAddresses(userID uuid.UUID, orderReq CreateOrderRequest) (orderAddresses, error) {
+	var addresses orderAddresses
+
+	shipping, err := resolveAddress(userID, orderReq.AddressID, orderReq.ShippingAddress, "shipping")
+	if err != nil {
+		return addresses, err
+	}
+	if shipping == nil {
+		return addresses, &apiError{http.StatusBadRequest, "Shipping address is required"}
+	}
+	addresses.Shipping = shipping
+
+	billing, err := resolveAddress(userID, orderReq.BillingAddressID, orderReq.BillingAddress, "billing")
+	if err != nil {
+		return addresses, err
+	}
+	if billing != nil {
+		addresses.Billing = &billing
+	}
+
+	return addresses, nil
+}
+
+// resolveAddress returns the snapshot to store on an order for a saved
+// address ID or an inline address; label names the address in errors
+func resolveAddress(userID uuid.UUID, addressID string, inline *AddressRequest, label string) (db.JSON, error) {
+	if addressID != "" {
+		id, err := uuid.FromString(addressID)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Invalid " + label + " address ID"}
+		}
+		var address db.Address
+		if err := findAddress(db.DB, userID, id, &address); err != nil {
+			return nil, err
+		}
+		return addressSnapshot(address), nil
+	}
+
+	if inline == nil {
+		return nil, nil
+	}
+	address, err := inline.toAddress()
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			apiErr.Message = "Invalid " + label + " address: " + apiErr.Message
+		}
+		return nil, err
+	}
+	return addressSnapshot(address), nil
+}
+
+// addressSnapshot copies an address into an order's JSON column
+func addressSnapshot(address db.Address) db.JSON {
+	snapshot := db.JSON{
+		"name":    address.Name,
+		"street":  address.Street,
+		"city":    address.City,
+		"county":  address.State,
+		"state":   address.State,
+		"country": address.Country,
+		"phone":   address.Phone,
+	}
+	if address.Zip != "" {
+		snapshot["zip"] = address.Zip
+	}
+	if address.ID != uuid.Nil {
+		snapshot["addressId"] = address.ID.String()
+	}
+	return snapshot
+}
@@ -0,0 +1,37 @@
+package handler
+
+import "testing"
+
+func TestAddressPhone(t *testing.T) {
+	tests := []struct {
+		country string
+		phone   string
+		want    string
+	}{
+		{"Kenya", "0712 345 678", "+254712345678"},
+		{"Kenya", "+254712345678", "+254712345678"},
+		{"Kenya", "+256712345678", ""},
+		{"Uganda", "+256 712 345 678", "+256712345678"},
+		{"Uganda", "00256712345678", "+256712345678"},
+		{"Uganda", "+254712345678", "+254712345678"},
+		{"Uganda", "0712345678", ""},
+		{"Tanzania", "712345678", ""},
+	}
+
+	for _, tt := range tests {
+		req := AddressRequest{Name: "Amani Njoroge", Street: "Station Road", City: "Capital", State: "Central", Country: tt.country, Phone: tt.phone}
+		if tt.country == "Kenya" {
+			req.County = "Nairobi"
+		}
+		address, err := req.toAddress()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s phone %q = %q, want an error", tt.country, tt.phone, address.Phone)
+			}
+			continue
+		}
+		if err != nil || address.Phone != tt.want {
+			t.Errorf("%s phone %q = %q, %v, want %q", tt.country, tt.phone, address.Phone, err, tt.want)
+		}
+	}
+}
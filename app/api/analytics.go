@@ -0,0 +1,475 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// storeTimeZone is where the store trades; analytics days and weeks start at
+// midnight there
+const storeTimeZone = "Africa/Nairobi"
+
+// storeLocation is storeTimeZone, or plain EAT when the zone database is missing
+var storeLocation = func() *time.Location {
+	loc, err := time.LoadLocation(storeTimeZone)
+	if err != nil {
+		return time.FixedZone("EAT", 3*60*60)
+	}
+	return loc
+}()
+
+const (
+	analyticsDateLayout  = "2006-01-02"
+	defaultAnalyticsDays = 30
+	maxAnalyticsDays     = 366
+	defaultAnalyticsTop  = 5
+	maxAnalyticsTop      = 20
+)
+
+// AnalyticsInterval is the bucket size of an analytics time series
+type AnalyticsInterval string
+
+const (
+	AnalyticsDaily  AnalyticsInterval = "day"
+	AnalyticsWeekly AnalyticsInterval = "week"
+)
+
+// analyticsPeriod is a range of whole days in the store's time zone; End is
+// the midnight after the last day
+type analyticsPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// AnalyticsPeriod is a period as shown to clients, with inclusive dates
+type AnalyticsPeriod struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// AnalyticsSummary totals a period's sales. Revenue is net of refunds.
+type AnalyticsSummary struct {
+	Revenue           int   `json:"revenue"`
+	Orders            int64 `json:"orders"`
+	AverageOrderValue int   `json:"averageOrderValue"`
+	NewCustomers      int64 `json:"newCustomers"`
+}
+
+// AnalyticsChange is the percent change of each summary figure from the
+// previous period; nil when the previous figure was zero
+type AnalyticsChange struct {
+	Revenue           *float64 `json:"revenue"`
+	Orders            *float64 `json:"orders"`
+	AverageOrderValue *float64 `json:"averageOrderValue"`
+	NewCustomers      *float64 `json:"newCustomers"`
+}
+
+// AnalyticsPoint is one bucket of the time series, keyed by the day or the
+// Monday the bucket starts on
+type AnalyticsPoint struct {
+	Date         string `json:"date"`
+	Revenue      int    `json:"revenue"`
+	Orders       int64  `json:"orders"`
+	NewCustomers int64  `json:"newCustomers"`
+}
+
+// AnalyticsProduct is a best-selling product
+type AnalyticsProduct struct {
+	ProductID uuid.UUID `json:"productId"`
+	Name      string    `json:"name"`
+	Quantity  int64     `json:"quantity"`
+	Revenue   int       `json:"revenue"`
+}
+
+// AnalyticsCategory is a best-selling category
+type AnalyticsCategory struct {
+	CategoryID uuid.UUID `json:"categoryId"`
+	Name       string    `json:"name"`
+	Quantity   int64     `json:"quantity"`
+	Revenue    int       `json:"revenue"`
+}
+
+// Analytics is the sales report for a period compared with the one before
+type Analytics struct {
+	TimeZone       string              `json:"timeZone"`
+	Interval       AnalyticsInterval   `json:"interval"`
+	Period         AnalyticsPeriod     `json:"period"`
+	PreviousPeriod AnalyticsPeriod     `json:"previousPeriod"`
+	Summary        AnalyticsSummary    `json:"summary"`
+	Previous       AnalyticsSummary    `json:"previous"`
+	Change         AnalyticsChange     `json:"change"`
+	Series         []AnalyticsPoint    `json:"series"`
+	TopProducts    []AnalyticsProduct  `json:"topProducts"`
+	TopCategories  []AnalyticsCategory `json:"topCategories"`
+}
+
+// AnalyticsHandler reports sales between from and to (inclusive dates in the
+// store's time zone, the last 30 days by default) against the period of the
+// same length just before, with a daily or weekly time series and the top
+// products and categories
+func AnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only allow GET requests
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	period, err := parseAnalyticsPeriod(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	interval := AnalyticsInterval(query.Get("interval"))
+	switch interval {
+	case AnalyticsDaily, AnalyticsWeekly:
+	case "":
+		interval = AnalyticsDaily
+		if period.days() > 92 {
+			interval = AnalyticsWeekly
+		}
+	default:
+		lib.RespondWithError(w, http.StatusBadRequest, "interval must be day or week")
+		return
+	}
+
+	limit := defaultAnalyticsTop
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAnalyticsTop {
+			lib.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAnalyticsTop))
+			return
+		}
+	}
+
+	analytics, err := buildAnalytics(db.DB, period, interval, limit)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to compute analytics")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, analytics)
+}
+
+// parseAnalyticsPeriod reads inclusive from and to dates. Missing dates give
+// the 30 days up to today, or 30 days from or up to the date that was given.
+func parseAnalyticsPeriod(from, to string, now time.Time) (analyticsPeriod, error) {
+	parse := func(name, raw string) (time.Time, error) {
+		day, err := time.ParseInLocation(analyticsDateLayout, raw, storeLocation)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s must be a date like 2024-01-31", name)
+		}
+		return day, nil
+	}
+
+	var period analyticsPeriod
+	switch {
+	case from != "" && to != "":
+		start, err := parse("from", from)
+		if err != nil {
+			return period, err
+		}
+		last, err := parse("to", to)
+		if err != nil {
+			return period, err
+		}
+		period = analyticsPeriod{Start: start, End: last.AddDate(0, 0, 1)}
+	case from != "":
+		start, err := parse("from", from)
+		if err != nil {
+			return period, err
+		}
+		period = analyticsPeriod{Start: start, End: start.AddDate(0, 0, defaultAnalyticsDays)}
+	default:
+		end := startOfDay(now).AddDate(0, 0, 1)
+		if to != "" {
+			last, err := parse("to", to)
+			if err != nil {
+				return period, err
+			}
+			end = last.AddDate(0, 0, 1)
+		}
+		period = analyticsPeriod{Start: end.AddDate(0, 0, -defaultAnalyticsDays), End: end}
+	}
+
+	if !period.End.After(period.Start) {
+		return period, fmt.Errorf("from must not be after to")
+	}
+	if period.days() > maxAnalyticsDays {
+		return period, fmt.Errorf("the range cannot be longer than %d days", maxAnalyticsDays)
+	}
+	return period, nil
+}
+
+// startOfDay is the midnight starting t's day in the store's time zone
+func startOfDay(t time.Time) time.Time {
+	t = t.In(storeLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, storeLocation)
+}
+
+// startOfMonth is the midnight starting t's month in the store's time zone
+func startOfMonth(t time.Time) time.Time {
+	t = t.In(storeLocation)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, storeLocation)
+}
+
+// monthToDate is the current month up to the end of today in store time, and
+// the same number of days from the start of last month, cut short at the end
+// of last month when it was shorter
+func monthToDate(now time.Time) (current, previous analyticsPeriod) {
+	current = analyticsPeriod{Start: startOfMonth(now), End: startOfDay(now).AddDate(0, 0, 1)}
+
+	previous = analyticsPeriod{Start: current.Start.AddDate(0, -1, 0)}
+	previous.End = previous.Start.AddDate(0, 0, current.days())
+	if previous.End.After(current.Start) {
+		previous.End = current.Start
+	}
+	return current, previous
+}
+
+// days is the number of calendar days in the period
+func (p analyticsPeriod) days() int {
+	// Go by dates rather than hours so a DST change would not matter
+	start := time.Date(p.Start.Year(), p.Start.Month(), p.Start.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(p.End.Year(), p.End.Month(), p.End.Day(), 0, 0, 0, 0, time.UTC)
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// previous is the period of the same length ending where p starts
+func (p analyticsPeriod) previous() analyticsPeriod {
+	return analyticsPeriod{Start: p.Start.AddDate(0, 0, -p.days()), End: p.Start}
+}
+
+// public returns the period with inclusive dates
+func (p analyticsPeriod) public() AnalyticsPeriod {
+	return AnalyticsPeriod{
+		From: p.Start.Format(analyticsDateLayout),
+		To:   p.End.AddDate(0, 0, -1).Format(analyticsDateLayout),
+	}
+}
+
+// salesScope limits orders to sales: orders that were paid, or shipped for
+// cash on delivery, and not cancelled
+func salesScope(tx *gorm.DB) *gorm.DB {
+	return tx.Where("orders.status <> ?", db.OrderStatusCancelled).
+		Where("(orders.payment_status IN ? OR orders.status IN ?)",
+			[]db.PaymentStatus{db.PaymentStatusPaid, db.PaymentStatusRefunded},
+			[]db.OrderStatus{db.OrderStatusShipped, db.OrderStatusDelivered})
+}
+
+// lineRevenueSQL is what an order line brought in after refunds. Orders
+// placed before line pricing only have the unit price.
+const lineRevenueSQL = `CASE WHEN oi.total > 0 THEN oi.total ELSE oi.price * oi.quantity END
+	- COALESCE((SELECT SUM(ri.amount) FROM refund_items ri WHERE ri."orderItemId" = oi._id), 0)`
+
+// buildAnalytics computes the report for a period
+func buildAnalytics(tx *gorm.DB, period analyticsPeriod, interval AnalyticsInterval, limit int) (Analytics, error) {
+	previous := period.previous()
+	analytics := Analytics{
+		TimeZone:       storeTimeZone,
+		Interval:       interval,
+		Period:         period.public(),
+		PreviousPeriod: previous.public(),
+	}
+
+	var err error
+	if analytics.Summary, err = summarizeSales(tx, period); err != nil {
+		return analytics, err
+	}
+	if analytics.Previous, err = summarizeSales(tx, previous); err != nil {
+		return analytics, err
+	}
+	analytics.Change = AnalyticsChange{
+		Revenue:           percentChange(int64(analytics.Previous.Revenue), int64(analytics.Summary.Revenue)),
+		Orders:            percentChange(analytics.Previous.Orders, analytics.Summary.Orders),
+		AverageOrderValue: percentChange(int64(analytics.Previous.AverageOrderValue), int64(analytics.Summary.AverageOrderValue)),
+		NewCustomers:      percentChange(analytics.Previous.NewCustomers, analytics.Summary.NewCustomers),
+	}
+
+	if analytics.Series, err = salesSeries(tx, period, interval); err != nil {
+		return analytics, err
+	}
+	if analytics.TopProducts, err = topProducts(tx, period, limit); err != nil {
+		return analytics, err
+	}
+	if analytics.TopCategories, err = topCategories(tx, period, limit); err != nil {
+		return analytics, err
+	}
+	return analytics, nil
+}
+
+// summarizeSales totals the sales and new customers of a period
+func summarizeSales(tx *gorm.DB, period analyticsPeriod) (AnalyticsSummary, error) {
+	var summary AnalyticsSummary
+
+	var totals struct {
+		Orders  int64
+		Revenue int64
+	}
+	if err := salesScope(tx.Model(&db.Order{})).
+		Select("COUNT(*) AS orders, COALESCE(SUM(orders.total - orders.refunded_amount), 0) AS revenue").
+		Where("orders.created_at >= ? AND orders.created_at < ?", period.Start, period.End).
+		Scan(&totals).Error; err != nil {
+		return summary, err
+	}
+	summary.Orders = totals.Orders
+	summary.Revenue = int(totals.Revenue)
+	if totals.Orders > 0 {
+		summary.AverageOrderValue = int(math.Round(float64(totals.Revenue) / float64(totals.Orders)))
+	}
+
+	if err := tx.Model(&db.User{}).
+		Where("role = ? AND created_at >= ? AND created_at < ?", db.RoleUser, period.Start, period.End).
+		Count(&summary.NewCustomers).Error; err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// salesSeries buckets the period's sales and new customers by day or week.
+// Postgres does the bucketing on local Nairobi time; buckets without sales
+// are filled in so charts get a point for every day or week.
+func salesSeries(tx *gorm.DB, period analyticsPeriod, interval AnalyticsInterval) ([]AnalyticsPoint, error) {
+	bucket := fmt.Sprintf("to_char(date_trunc('%s', %%s AT TIME ZONE '%s'), 'YYYY-MM-DD')", interval, storeTimeZone)
+
+	var sales []struct {
+		Bucket  string
+		Orders  int64
+		Revenue int64
+	}
+	if err := salesScope(tx.Model(&db.Order{})).
+		Select(fmt.Sprintf(bucket, "orders.created_at")+" AS bucket, COUNT(*) AS orders, COALESCE(SUM(orders.total - orders.refunded_amount), 0) AS revenue").
+		Where("orders.created_at >= ? AND orders.created_at < ?", period.Start, period.End).
+		Group("bucket").Scan(&sales).Error; err != nil {
+		return nil, err
+	}
+
+	var customers []struct {
+		Bucket string
+		Count  int64
+	}
+	if err := tx.Model(&db.User{}).
+		Select(fmt.Sprintf(bucket, "created_at")+" AS bucket, COUNT(*) AS count").
+		Where("role = ? AND created_at >= ? AND created_at < ?", db.RoleUser, period.Start, period.End).
+		Group("bucket").Scan(&customers).Error; err != nil {
+		return nil, err
+	}
+
+	var series []AnalyticsPoint
+	index := map[string]int{}
+	for day := period.Start; day.Before(period.End); day = day.AddDate(0, 0, 1) {
+		key := analyticsBucket(day, interval).Format(analyticsDateLayout)
+		if _, ok := index[key]; !ok {
+			index[key] = len(series)
+			series = append(series, AnalyticsPoint{Date: key})
+		}
+	}
+	for _, row := range sales {
+		if i, ok := index[row.Bucket]; ok {
+			series[i].Orders = row.Orders
+			series[i].Revenue = int(row.Revenue)
+		}
+	}
+	for _, row := range customers {
+		if i, ok := index[row.Bucket]; ok {
+			series[i].NewCustomers = row.Count
+		}
+	}
+	return series, nil
+}
+
+// analyticsBucket is the day, or the Monday of the week, that day falls in,
+// matching Postgres' date_trunc
+func analyticsBucket(day time.Time, interval AnalyticsInterval) time.Time {
+	if interval != AnalyticsWeekly {
+		return day
+	}
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// topProducts ranks the period's products by revenue
+func topProducts(tx *gorm.DB, period analyticsPeriod, limit int) ([]AnalyticsProduct, error) {
+	var rows []struct {
+		ProductID uuid.UUID
+		Name      string
+		Quantity  int64
+		Revenue   int64
+	}
+	if err := salesScope(tx.Table("order_items oi")).
+		Joins(`JOIN orders ON orders._id = oi."orderId"`).
+		Joins(`LEFT JOIN products p ON p._id = oi."productId"`).
+		Select(`oi."productId" AS product_id, COALESCE(MAX(p.name), MAX(oi.name)) AS name,
+			SUM(oi.quantity) AS quantity, SUM(`+lineRevenueSQL+`) AS revenue`).
+		Where("orders.created_at >= ? AND orders.created_at < ?", period.Start, period.End).
+		Group(`oi."productId"`).
+		Order("revenue DESC, quantity DESC").Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	products := make([]AnalyticsProduct, len(rows))
+	for i, row := range rows {
+		products[i] = AnalyticsProduct{ProductID: row.ProductID, Name: row.Name, Quantity: row.Quantity, Revenue: int(row.Revenue)}
+	}
+	return products, nil
+}
+
+// topCategories ranks the period's categories by revenue, counting each
+// product under its own category
+func topCategories(tx *gorm.DB, period analyticsPeriod, limit int) ([]AnalyticsCategory, error) {
+	var rows []struct {
+		CategoryID uuid.UUID
+		Name       string
+		Quantity   int64
+		Revenue    int64
+	}
+	if err := salesScope(tx.Table("order_items oi")).
+		Joins(`JOIN orders ON orders._id = oi."orderId"`).
+		Joins(`JOIN products p ON p._id = oi."productId"`).
+		Joins(`JOIN categories c ON c._id = p."categoryId"`).
+		Select(`c._id AS category_id, c.name AS name,
+			SUM(oi.quantity) AS quantity, SUM(`+lineRevenueSQL+`) AS revenue`).
+		Where("orders.created_at >= ? AND orders.created_at < ?", period.Start, period.End).
+		Group("c._id, c.name").
+		Order("revenue DESC, quantity DESC").Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	categories := make([]AnalyticsCategory, len(rows))
+	for i, row := range rows {
+		categories[i] = AnalyticsCategory{CategoryID: row.CategoryID, Name: row.Name, Quantity: row.Quantity, Revenue: int(row.Revenue)}
+	}
+	return categories, nil
+}
+
+// percentChange is the change from previous to current in percent, rounded
+// to one decimal, or nil when there is nothing to compare with
+func percentChange(previous, current int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	change := math.Round(float64(current-previous)/float64(previous)*1000) / 10
+	return &change
+}
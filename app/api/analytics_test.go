@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonthToDate(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, storeLocation)
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		current  analyticsPeriod
+		previous analyticsPeriod
+	}{
+		{"mid month", day(2026, time.October, 17).Add(15 * time.Hour), analyticsPeriod{day(2026, time.October, 1), day(2026, time.October, 18)}, analyticsPeriod{day(2026, time.September, 1), day(2026, time.September, 18)}},
+		{"first day", day(2026, time.October, 1).Add(time.Minute), analyticsPeriod{day(2026, time.October, 1), day(2026, time.October, 2)}, analyticsPeriod{day(2026, time.September, 1), day(2026, time.September, 2)}},
+		{"last month was shorter", day(2026, time.March, 31).Add(20 * time.Hour), analyticsPeriod{day(2026, time.March, 1), day(2026, time.April, 1)}, analyticsPeriod{day(2026, time.February, 1), day(2026, time.March, 1)}},
+		{"across the new year", day(2027, time.January, 5), analyticsPeriod{day(2027, time.January, 1), day(2027, time.January, 6)}, analyticsPeriod{day(2026, time.December, 1), day(2026, time.December, 6)}},
+		{"late UTC evening is tomorrow in store time", time.Date(2026, time.October, 16, 22, 0, 0, 0, time.UTC), analyticsPeriod{day(2026, time.October, 1), day(2026, time.October, 18)}, analyticsPeriod{day(2026, time.September, 1), day(2026, time.September, 18)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, previous := monthToDate(tt.now)
+			if !current.Start.Equal(tt.current.Start) || !current.End.Equal(tt.current.End) {
+				t.Errorf("current = %v to %v, want %v to %v", current.Start, current.End, tt.current.Start, tt.current.End)
+			}
+			if !previous.Start.Equal(tt.previous.Start) || !previous.End.Equal(tt.previous.End) {
+				t.Errorf("previous = %v to %v, want %v to %v", previous.Start, previous.End, tt.previous.Start, tt.previous.End)
+			}
+		})
+	}
+}
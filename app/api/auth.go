@@ -2,11 +2,11 @@ package handler
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
-	"time"
+	"strings"
 
-	"beauty-shop/api/db"
-	"github.com/golang-jwt/jwt/v4"
+	"beauty-shop/app/api/db"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,30 +14,18 @@ import (
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	CartID   string `json:"cartId,omitempty"`
 }
 
 // LoginResponse represents the login response
 type LoginResponse struct {
-	Token string  `json:"token"`
-	User  db.User `json:"user"`
+	SessionTokens
+	User   db.User `json:"user"`
+	CartID string  `json:"cartId,omitempty"`
 }
 
-// JWT claims struct
-type Claims struct {
-	UserID string `json:"userId"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
-	jwt.RegisteredClaims
-}
-
-// JWT secret key
-var jwtKey = []byte("your-secret-key") // In production, use an environment variable
-
-// Handler handles HTTP requests for authentication
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
-
+// AuthHandler handles HTTP requests for authentication
+func AuthHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -64,7 +52,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Find user by email
 	var user db.User
-	if err := db.DB.Where("email = ?", loginReq.Email).First(&user).Error; err != nil {
+	if err := db.DB.Where("email = ?", strings.ToLower(strings.TrimSpace(loginReq.Email))).First(&user).Error; err != nil {
 		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
 		return
 	}
@@ -80,40 +68,38 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create JWT token
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &Claims{
-		UserID: user.ID.String(),
-		Email:  user.Email,
-		Role:   string(user.Role),
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	// Start a session and issue its tokens
+	sessionID, refreshToken, err := startSession(r, user.ID)
+	if err != nil {
+		log.Printf("Failed to start session for user %s: %v", user.ID, err)
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokens, err := issueSessionTokens(user, sessionID, refreshToken)
 	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
+	// Merge the anonymous session cart into the user's cart
+	cartID, err := mergeGuestCart(loginReq.CartID, user.ID)
+	if err != nil {
+		log.Printf("Failed to merge cart for user %s: %v", user.ID, err)
+		cartID = loginReq.CartID
+	}
+
 	// Create response
 	response := LoginResponse{
-		Token: tokenString,
-		User:  user,
+		SessionTokens: *tokens,
+		User:          user,
+		CartID:        cartID,
 	}
 
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
-	// Return response
-	json.NewEncoder(w).Encode(response)
-}  "application/json")
-
 	// Return response
 	json.NewEncoder(w).Encode(response)
 }
-
-
-
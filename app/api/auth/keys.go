@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is a signing or verification key identified by its kid
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// SignKey is nil for verify-only keys kept around during rotation
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// KeySet holds every key tokens may be verified with and the one new tokens are signed with
+type KeySet struct {
+	keys   map[string]*Key
+	active string
+}
+
+// NewKeySet creates a key set that signs with the key whose ID is active
+func NewKeySet(active string, keys ...*Key) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*Key, len(keys)), active: active}
+	for _, key := range keys {
+		if _, exists := set.keys[key.ID]; exists {
+			return nil, fmt.Errorf("auth: duplicate key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+
+	signing, ok := set.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("auth: active key %q not found", active)
+	}
+	if signing.SignKey == nil {
+		return nil, fmt.Errorf("auth: active key %q has no private key", active)
+	}
+
+	return set, nil
+}
+
+// NewHMACKey creates an HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret}
+}
+
+// NewPrivateKey creates an RS256 or EdDSA key from an RSA or Ed25519 private key
+func NewPrivateKey(id string, private crypto.Signer) (*Key, error) {
+	key := &Key{ID: id, SignKey: private, VerifyKey: private.Public()}
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("auth: unsupported private key type %T", private)
+	}
+	return key, nil
+}
+
+// NewPublicKey creates a verify-only RS256 or EdDSA key
+func NewPublicKey(id string, public crypto.PublicKey) (*Key, error) {
+	key := &Key{ID: id, VerifyKey: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("auth: unsupported public key type %T", public)
+	}
+	return key, nil
+}
+
+// Active returns the key new tokens are signed with
+func (s *KeySet) Active() *Key {
+	return s.keys[s.active]
+}
+
+// Lookup returns the key with the given ID
+func (s *KeySet) Lookup(id string) (*Key, bool) {
+	key, ok := s.keys[id]
+	return key, ok
+}
+
+// LoadKeySet builds the key set from the environment.
+//
+// When JWT_KEYS_DIR is set, every file in it is a key named after its kid:
+// <kid>.pem holds an RSA or Ed25519 private key (PKCS#1 or PKCS#8),
+// <kid>.pub.pem a public key that can only verify, and <kid>.secret an HS256
+// secret. JWT_ACTIVE_KID picks the signing key; retired keys stay in the
+// directory until tokens signed with them have expired.
+//
+// Otherwise JWT_SECRET is used as a single HS256 key.
+func LoadKeySet() (*KeySet, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("auth: JWT_SECRET or JWT_KEYS_DIR must be set")
+		}
+		return NewKeySet("default", NewHMACKey("default", []byte(secret)))
+	}
+
+	keys, err := loadKeysFromDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	active := os.Getenv("JWT_ACTIVE_KID")
+	if active == "" {
+		return nil, errors.New("auth: JWT_ACTIVE_KID must be set when JWT_KEYS_DIR is used")
+	}
+
+	return NewKeySet(active, keys...)
+}
+
+// loadKeysFromDir reads every key file in dir, ordered by name
+func loadKeysFromDir(dir string) ([]*Key, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("auth: read keys dir: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var keys []*Key
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("auth: read key %s: %w", name, err)
+		}
+
+		var key *Key
+		switch {
+		case strings.HasSuffix(name, ".secret"):
+			key = NewHMACKey(strings.TrimSuffix(name, ".secret"), []byte(strings.TrimSpace(string(data))))
+		case strings.HasSuffix(name, ".pub.pem"):
+			key, err = parsePublicKey(strings.TrimSuffix(name, ".pub.pem"), data)
+		case strings.HasSuffix(name, ".pem"):
+			key, err = parsePrivateKey(strings.TrimSuffix(name, ".pem"), data)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("auth: parse key %s: %w", name, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parsePrivateKey parses an RSA or Ed25519 private key in PEM form
+func parsePrivateKey(id string, data []byte) (*Key, error) {
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return NewPrivateKey(id, private)
+	}
+
+	private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.New("not an RSA or Ed25519 private key")
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("not an RSA or Ed25519 private key")
+	}
+	return NewPrivateKey(id, signer)
+}
+
+// parsePublicKey parses an RSA or Ed25519 public key in PEM form
+func parsePublicKey(id string, data []byte) (*Key, error) {
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return NewPublicKey(id, public)
+	}
+
+	public, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.New("not an RSA or Ed25519 public key")
+	}
+	return NewPublicKey(id, public)
+}
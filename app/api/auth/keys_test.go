@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestKeys fills a JWT_KEYS_DIR with one key of each kind and a file
+// that is not a key
+func writeTestKeys(t *testing.T) string {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("marshal Ed25519 key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"2025-01.pem":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"2025-06.pem":     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+		"2024-06.pub.pem": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+		"legacy.secret":   []byte("an old shared secret\n"),
+		"README":          []byte("Keys are named after their kid.\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := writeTestKeys(t)
+
+	tests := []struct {
+		name      string
+		secret    string
+		dir       string
+		activeKID string
+		wantErr   bool
+		active    string
+		alg       string
+	}{
+		{name: "JWT_SECRET", secret: "a shared secret", active: "default", alg: "HS256"},
+		{name: "nothing configured", wantErr: true},
+		{name: "JWT_KEYS_DIR with an Ed25519 active key", secret: "ignored", dir: dir, activeKID: "2025-06", active: "2025-06", alg: "EdDSA"},
+		{name: "JWT_KEYS_DIR with an RSA active key", dir: dir, activeKID: "2025-01", active: "2025-01", alg: "RS256"},
+		{name: "JWT_KEYS_DIR with an HS256 active key", dir: dir, activeKID: "legacy", active: "legacy", alg: "HS256"},
+		{name: "active kid missing from the directory", dir: dir, activeKID: "2026-01", wantErr: true},
+		{name: "active kid is verify-only", dir: dir, activeKID: "2024-06", wantErr: true},
+		{name: "no active kid", dir: dir, wantErr: true},
+		{name: "missing directory", dir: filepath.Join(dir, "missing"), activeKID: "2025-06", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", tt.secret)
+			t.Setenv("JWT_KEYS_DIR", tt.dir)
+			t.Setenv("JWT_ACTIVE_KID", tt.activeKID)
+
+			set, err := LoadKeySet()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("LoadKeySet succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKeySet: %v", err)
+			}
+
+			active := set.Active()
+			if active.ID != tt.active || active.Method.Alg() != tt.alg || active.SignKey == nil {
+				t.Errorf("active key = %s %s, want %s %s with a private key", active.ID, active.Method.Alg(), tt.active, tt.alg)
+			}
+		})
+	}
+}
+
+func TestLoadKeySetKeepsRetiredKeys(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", writeTestKeys(t))
+	t.Setenv("JWT_ACTIVE_KID", "2025-06")
+
+	set, err := LoadKeySet()
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	tests := []struct {
+		kid     string
+		alg     string
+		canSign bool
+	}{
+		{"2025-01", "RS256", true},
+		{"2025-06", "EdDSA", true},
+		{"2024-06", "RS256", false},
+		{"legacy", "HS256", true},
+	}
+	for _, tt := range tests {
+		key, ok := set.Lookup(tt.kid)
+		if !ok {
+			t.Errorf("key %s was not loaded", tt.kid)
+			continue
+		}
+		if key.Method.Alg() != tt.alg || (key.SignKey != nil) != tt.canSign {
+			t.Errorf("key %s = %s, can sign %t; want %s, can sign %t", tt.kid, key.Method.Alg(), key.SignKey != nil, tt.alg, tt.canSign)
+		}
+	}
+
+	if _, ok := set.Lookup("README"); ok {
+		t.Error("a file that is not a key was loaded")
+	}
+	if key, _ := set.Lookup("legacy"); string(key.VerifyKey.([]byte)) != "an old shared secret" {
+		t.Errorf("legacy secret = %q, want it without the trailing newline", key.VerifyKey)
+	}
+}
+
+func TestNewKeySetRejectsDuplicateKIDs(t *testing.T) {
+	if _, err := NewKeySet("a", NewHMACKey("a", []byte("one")), NewHMACKey("a", []byte("two"))); err == nil {
+		t.Error("NewKeySet accepted two keys with the same kid")
+	}
+}
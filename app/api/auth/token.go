@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultIssuer is the iss claim used when JWT_ISSUER is not set
+	DefaultIssuer = "beauty-shop"
+
+	// DefaultAudience is the aud claim used when JWT_AUDIENCE is not set
+	DefaultAudience = "beauty-shop-api"
+
+	// DefaultAccessTokenTTL is how long access tokens are valid; sessions are
+	// kept alive with refresh tokens
+	DefaultAccessTokenTTL = 15 * time.Minute
+)
+
+// ErrInvalidToken is returned for tokens that fail any validation check
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims are the JWT claims carried by access tokens
+type Claims struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+
+	// SessionID identifies the login session so it can be revoked server-side
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Config controls the registered claims of issued tokens
+type Config struct {
+	Issuer         string
+	Audience       string
+	AccessTokenTTL time.Duration
+}
+
+// ConfigFromEnv reads the token configuration from environment variables
+func ConfigFromEnv() Config {
+	config := Config{
+		Issuer:         os.Getenv("JWT_ISSUER"),
+		Audience:       os.Getenv("JWT_AUDIENCE"),
+		AccessTokenTTL: DefaultAccessTokenTTL,
+	}
+	if config.Issuer == "" {
+		config.Issuer = DefaultIssuer
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultAudience
+	}
+	return config
+}
+
+// Authenticator issues and verifies access tokens
+type Authenticator struct {
+	keys   *KeySet
+	config Config
+}
+
+// New creates an authenticator signing with keys according to config
+func New(keys *KeySet, config Config) *Authenticator {
+	return &Authenticator{keys: keys, config: config}
+}
+
+// IssueAccessToken signs a new access token for a user's session with the active key
+func (a *Authenticator) IssueAccessToken(userID, email, role, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(a.config.AccessTokenTTL)
+
+	claims := &Claims{
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.config.Issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{a.config.Audience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	key := a.keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	signed, err := token.SignedString(key.SignKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("auth: sign token: %w", err)
+	}
+
+	return signed, expiresAt, nil
+}
+
+// ParseAccessToken verifies a token's signature, issuer, audience and expiry
+// and returns its claims. The key is chosen by the token's kid header, and
+// the algorithm must match that key's.
+func (a *Authenticator) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := a.keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.VerifyKey, nil
+	},
+		jwt.WithIssuer(a.config.Issuer),
+		jwt.WithAudience(a.config.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+var (
+	defaultAuth    *Authenticator
+	defaultAuthErr error
+	defaultMu      sync.Mutex
+)
+
+// Default returns the process-wide authenticator, loading keys from the environment on first use
+func Default() (*Authenticator, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultAuth == nil && defaultAuthErr == nil {
+		keys, err := LoadKeySet()
+		if err != nil {
+			defaultAuthErr = err
+		} else {
+			defaultAuth = New(keys, ConfigFromEnv())
+		}
+	}
+
+	return defaultAuth, defaultAuthErr
+}
+
+// SetDefault replaces the process-wide authenticator
+func SetDefault(a *Authenticator) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultAuth = a
+	defaultAuthErr = nil
+}
+
+// IssueAccessToken signs an access token with the default authenticator
+func IssueAccessToken(userID, email, role, sessionID string) (string, time.Time, error) {
+	a, err := Default()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return a.IssueAccessToken(userID, email, role, sessionID)
+}
+
+// ParseAccessToken verifies an access token with the default authenticator
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	a, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return a.ParseAccessToken(tokenString)
+}
+
+// TokenFromRequest extracts the bearer token from the Authorization header
+func TokenFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("authorization header is required")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", errors.New("authorization header format must be Bearer {token}")
+	}
+
+	return parts[1], nil
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testConfig is the token configuration the tests issue and verify with
+var testConfig = Config{Issuer: "beauty-shop-test", Audience: "beauty-shop-test-api", AccessTokenTTL: time.Minute}
+
+// testKeys are the keys of a rotation: a retired Ed25519 key kept for
+// verification, an RSA key that now signs, and one the service never had
+type testKeys struct {
+	retired ed25519.PrivateKey
+	active  *rsa.PrivateKey
+	unknown ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	var keys testKeys
+	var err error
+	if _, keys.retired, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	if _, keys.unknown, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatalf("generate Ed25519 key: %v", err)
+	}
+	if keys.active, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return keys
+}
+
+// authenticator signs with keys.active and still verifies keys.retired
+func (keys testKeys) authenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	active, err := NewPrivateKey("2025-06", keys.active)
+	if err != nil {
+		t.Fatalf("active key: %v", err)
+	}
+	retired, err := NewPublicKey("2025-01", keys.retired.Public())
+	if err != nil {
+		t.Fatalf("retired key: %v", err)
+	}
+	set, err := NewKeySet("2025-06", active, retired)
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	return New(set, testConfig)
+}
+
+// signTestToken signs claims with method and key under a kid header
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims *Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+// validClaims are claims the test authenticator accepts, issued at now
+func validClaims(now time.Time) *Claims {
+	return &Claims{
+		UserID:    "user-1",
+		Email:     "wanjiru@example.com",
+		Role:      "USER",
+		SessionID: "session-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testConfig.Issuer,
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{testConfig.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+func TestIssueAndParseAccessToken(t *testing.T) {
+	a := newTestKeys(t).authenticator(t)
+
+	signed, expiresAt, err := a.IssueAccessToken("user-1", "wanjiru@example.com", "ADMIN", "session-1")
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > testConfig.AccessTokenTTL {
+		t.Errorf("token expires in %s, want within %s", until, testConfig.AccessTokenTTL)
+	}
+
+	claims, err := a.ParseAccessToken(signed)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if claims.UserID != "user-1" || claims.Email != "wanjiru@example.com" || claims.Role != "ADMIN" || claims.SessionID != "session-1" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	token, _, err := jwt.NewParser().ParseUnverified(signed, &Claims{})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if token.Header["kid"] != "2025-06" || token.Method.Alg() != "RS256" {
+		t.Errorf("header = %v, want the active RS256 key", token.Header)
+	}
+}
+
+func TestParseAccessToken(t *testing.T) {
+	keys := newTestKeys(t)
+	a := keys.authenticator(t)
+	now := time.Now()
+
+	// The public key an attacker could know, used as an HMAC secret
+	publicDER, err := x509.MarshalPKIXPublicKey(keys.active.Public())
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	with := func(change func(*Claims)) *Claims {
+		claims := validClaims(now)
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"active key", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, validClaims(now)), true},
+		{"retired key", signTestToken(t, jwt.SigningMethodEdDSA, "2025-01", keys.retired, validClaims(now)), true},
+		{"unknown kid", signTestToken(t, jwt.SigningMethodEdDSA, "2024-01", keys.unknown, validClaims(now)), false},
+		{"known kid signed with another key", signTestToken(t, jwt.SigningMethodEdDSA, "2025-01", keys.unknown, validClaims(now)), false},
+		{"no kid", signTestToken(t, jwt.SigningMethodRS256, "", keys.active, validClaims(now)), false},
+		{"HS256 with the public key PEM as secret", signTestToken(t, jwt.SigningMethodHS256, "2025-06", publicPEM, validClaims(now)), false},
+		{"HS256 with the public key DER as secret", signTestToken(t, jwt.SigningMethodHS256, "2025-06", publicDER, validClaims(now)), false},
+		{"algorithm of another key", signTestToken(t, jwt.SigningMethodEdDSA, "2025-06", keys.retired, validClaims(now)), false},
+		{"wrong issuer", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.Issuer = "someone-else"
+		})), false},
+		{"wrong audience", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.Audience = jwt.ClaimStrings{"another-api"}
+		})), false},
+		{"expired", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+		})), false},
+		{"expired within the leeway", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+		})), true},
+		{"no expiry", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.ExpiresAt = nil
+		})), false},
+		{"issued in the future", signTestToken(t, jwt.SigningMethodRS256, "2025-06", keys.active, with(func(c *Claims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(10 * time.Minute))
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(time.Hour))
+		})), false},
+		{"not a token", "not.a.token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.ParseAccessToken(tt.token)
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("parse: %v", err)
+				}
+				if claims.UserID != "user-1" {
+					t.Errorf("userId = %q, want user-1", claims.UserID)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,603 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/pricing"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// AddToCartRequest represents the request to add an item to the cart
+type AddToCartRequest struct {
+	CartID    string `json:"cartId"`
+	ProductID string `json:"productId"`
+	Quantity  int    `json:"quantity"`
+	VariantID string `json:"variantId,omitempty"`
+}
+
+// UpdateCartItemRequest represents the request to change an item's quantity
+type UpdateCartItemRequest struct {
+	Quantity *int `json:"quantity"`
+}
+
+// ApplyCouponRequest represents the request to apply a coupon code to a cart
+type ApplyCouponRequest struct {
+	CartID string `json:"cartId"`
+	Code   string `json:"code"`
+}
+
+// CartResponse represents a cart together with its computed totals
+type CartResponse struct {
+	db.Cart
+	ItemCount int `json:"itemCount"`
+	Subtotal  int `json:"subtotal"`
+	Discount  int `json:"discount"`
+	Tax       int `json:"tax"`
+	Shipping  int `json:"shipping"`
+	Total     int `json:"total"`
+
+	// Lines prices each item, in the same order as Items
+	Lines   []pricing.QuoteLine `json:"lines"`
+	TaxRate float64             `json:"taxRate"`
+	TaxMode pricing.TaxMode     `json:"taxMode"`
+
+	// CouponError explains why the cart's coupon no longer applies
+	CouponError string `json:"couponError,omitempty"`
+}
+
+var (
+	// errInsufficientStock is returned when a cart quantity exceeds available stock
+	errInsufficientStock = errors.New("insufficient stock")
+
+	// errVariantMismatch is returned when a variant belongs to a different product
+	errVariantMismatch = errors.New("variant does not belong to product")
+)
+
+// CartHandler handles HTTP requests for the cart
+func CartHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Get the cart for the session
+		sessionID := r.URL.Query().Get("cartId")
+		if sessionID == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+			return
+		}
+
+		var cart db.Cart
+		err := loadCart(db.DB, &cart, "session_id = ?", sessionID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart")
+			return
+		}
+
+		// An unknown session simply has an empty cart
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cart = db.Cart{SessionID: sessionID, Items: []db.CartItem{}}
+		}
+
+		respondWithCart(w, http.StatusOK, cart)
+
+	case "POST":
+		// Add an item to the cart
+		var req AddToCartRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		// Validate request
+		if req.CartID == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+			return
+		}
+
+		if req.Quantity == 0 {
+			req.Quantity = 1
+		}
+
+		if req.Quantity < 0 {
+			lib.RespondWithError(w, http.StatusBadRequest, "Quantity must be positive")
+			return
+		}
+
+		productID, err := uuid.FromString(req.ProductID)
+		if err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+			return
+		}
+
+		// Find or create the session cart
+		var cart db.Cart
+		if err := db.DB.Where(db.Cart{SessionID: req.CartID}).FirstOrCreate(&cart).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to create cart")
+			return
+		}
+
+		// Merge with an existing line for the same product and variant
+		var item db.CartItem
+		query := db.DB.Where(&db.CartItem{CartID: cart.ID, ProductID: productID})
+		if req.VariantID != "" {
+			query = query.Where("\"variantId\" = ?", req.VariantID)
+		} else {
+			query = query.Where("\"variantId\" IS NULL")
+		}
+		err = query.First(&item).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart item")
+			return
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			item = db.CartItem{CartID: cart.ID, ProductID: productID}
+			if req.VariantID != "" {
+				item.VariantID = &req.VariantID
+			}
+		}
+
+		// Check stock for the combined quantity
+		quantity := item.Quantity + req.Quantity
+		if err := checkCartStock(productID, item.VariantID, quantity); err != nil {
+			respondWithStockError(w, err)
+			return
+		}
+
+		item.Quantity = quantity
+		if err := db.DB.Save(&item).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to add item to cart")
+			return
+		}
+
+		if err := loadCart(db.DB, &cart, "_id = ?", cart.ID); err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart")
+			return
+		}
+
+		respondWithCart(w, http.StatusOK, cart)
+
+	case "DELETE":
+		// Clear all items from the cart
+		sessionID := r.URL.Query().Get("cartId")
+		if sessionID == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+			return
+		}
+
+		var cart db.Cart
+		if err := db.DB.Where("session_id = ?", sessionID).First(&cart).Error; err != nil {
+			lib.RespondWithError(w, http.StatusNotFound, "Cart not found")
+			return
+		}
+
+		if err := db.DB.Where(&db.CartItem{CartID: cart.ID}).Delete(&db.CartItem{}).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to clear cart")
+			return
+		}
+
+		cart.Items = []db.CartItem{}
+		respondWithCart(w, http.StatusOK, cart)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// CartItemHandler handles HTTP requests for a single cart item
+func CartItemHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Items are always addressed within the session cart
+	sessionID := r.URL.Query().Get("cartId")
+	if sessionID == "" {
+		lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+		return
+	}
+
+	itemID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
+
+	var cart db.Cart
+	if err := db.DB.Where("session_id = ?", sessionID).First(&cart).Error; err != nil {
+		lib.RespondWithError(w, http.StatusNotFound, "Cart not found")
+		return
+	}
+
+	var item db.CartItem
+	if err := db.DB.Where(&db.CartItem{CartID: cart.ID}).First(&item, "_id = ?", itemID).Error; err != nil {
+		lib.RespondWithError(w, http.StatusNotFound, "Item not found")
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		// Update the item quantity
+		var req UpdateCartItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if req.Quantity == nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Quantity is required")
+			return
+		}
+
+		if *req.Quantity <= 0 {
+			// Remove the item if quantity is 0 or negative
+			if err := db.DB.Delete(&item).Error; err != nil {
+				lib.RespondWithError(w, http.StatusInternalServerError, "Failed to remove cart item")
+				return
+			}
+		} else {
+			if err := checkCartStock(item.ProductID, item.VariantID, *req.Quantity); err != nil {
+				respondWithStockError(w, err)
+				return
+			}
+
+			item.Quantity = *req.Quantity
+			if err := db.DB.Save(&item).Error; err != nil {
+				lib.RespondWithError(w, http.StatusInternalServerError, "Failed to update cart item")
+				return
+			}
+		}
+
+	case "DELETE":
+		// Remove the item
+		if err := db.DB.Delete(&item).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to remove cart item")
+			return
+		}
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if err := loadCart(db.DB, &cart, "_id = ?", cart.ID); err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart")
+		return
+	}
+
+	respondWithCart(w, http.StatusOK, cart)
+}
+
+// CartCouponHandler applies a coupon code to a cart (POST) or removes it (DELETE).
+// Per-customer limits are checked at checkout, once the customer is known.
+func CartCouponHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		var req ApplyCouponRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.CartID == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+			return
+		}
+		if normalizeCouponCode(req.Code) == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Coupon code is required")
+			return
+		}
+
+		var cart db.Cart
+		if err := loadCart(db.DB, &cart, "session_id = ?", req.CartID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lib.RespondWithError(w, http.StatusNotFound, "Cart not found")
+				return
+			}
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart")
+			return
+		}
+
+		// Only keep codes that apply to the cart as it is now
+		coupon, err := loadCoupon(db.DB, req.Code, false)
+		if err == nil {
+			_, err = applyCoupon(db.DB, coupon, cartDiscountLines(cart), time.Now())
+		}
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to apply coupon")
+			return
+		}
+
+		if err := db.DB.Model(&cart).Update("coupon_code", coupon.Code).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to apply coupon")
+			return
+		}
+		cart.CouponCode = &coupon.Code
+
+		respondWithCart(w, http.StatusOK, cart)
+
+	case "DELETE":
+		sessionID := r.URL.Query().Get("cartId")
+		if sessionID == "" {
+			lib.RespondWithError(w, http.StatusBadRequest, "Cart ID is required")
+			return
+		}
+
+		var cart db.Cart
+		if err := loadCart(db.DB, &cart, "session_id = ?", sessionID); err != nil {
+			lib.RespondWithError(w, http.StatusNotFound, "Cart not found")
+			return
+		}
+
+		if err := db.DB.Model(&cart).Update("coupon_code", nil).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to remove coupon")
+			return
+		}
+		cart.CouponCode = nil
+
+		respondWithCart(w, http.StatusOK, cart)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// loadCart fetches a cart with its items and their products
+func loadCart(tx *gorm.DB, cart *db.Cart, query string, args ...interface{}) error {
+	return tx.Preload("Items", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("created_at ASC")
+	}).Preload("Items.Product").Preload("Items.Product.Images").Preload("Items.Variant").
+		Where(query, args...).First(cart).Error
+}
+
+// checkCartStock verifies that the requested quantity of a product or variant is available
+func checkCartStock(productID uuid.UUID, variantID *string, quantity int) error {
+	product, available, err := cartStock(productID, variantID)
+	if err != nil {
+		return err
+	}
+
+	if !product.InStock {
+		return fmt.Errorf("%w: %s is out of stock", errInsufficientStock, product.Name)
+	}
+
+	if quantity > available {
+		return fmt.Errorf("%w: only %d of %s available", errInsufficientStock, available, product.Name)
+	}
+
+	return nil
+}
+
+// cartStock loads a product and the number of units of it, or of its variant,
+// that can go in a cart
+func cartStock(productID uuid.UUID, variantID *string) (db.Product, int, error) {
+	var product db.Product
+	if err := db.DB.First(&product, "_id = ?", productID).Error; err != nil {
+		return product, 0, err
+	}
+
+	available := product.StockQuantity
+	if variantID != nil {
+		var variant db.ProductVariant
+		if err := db.DB.First(&variant, "_id = ?", *variantID).Error; err != nil {
+			return product, 0, err
+		}
+		if variant.ProductID != productID {
+			return product, 0, errVariantMismatch
+		}
+		available = variant.StockQuantity
+	}
+	if !product.InStock {
+		available = 0
+	}
+
+	return product, available, nil
+}
+
+// respondWithStockError maps stock check errors to HTTP responses
+func respondWithStockError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInsufficientStock):
+		lib.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errVariantMismatch):
+		lib.RespondWithError(w, http.StatusBadRequest, "Variant does not belong to product")
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		lib.RespondWithError(w, http.StatusNotFound, "Product or variant not found")
+	default:
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to check stock")
+	}
+}
+
+// respondWithCart writes the cart priced the way checkout would price it
+func respondWithCart(w http.ResponseWriter, statusCode int, cart db.Cart) {
+	response := CartResponse{Cart: cart}
+	lines := cartDiscountLines(cart)
+	for _, line := range lines {
+		response.ItemCount += line.Quantity
+	}
+
+	// A coupon that stopped applying stays on the cart with an explanation
+	var discount discountResult
+	if cart.CouponCode != nil {
+		coupon, err := loadCoupon(db.DB, *cart.CouponCode, false)
+		if err == nil {
+			discount, err = applyCoupon(db.DB, coupon, lines, time.Now())
+		}
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			response.CouponError = apiErr.Message
+		} else if err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to apply coupon")
+			return
+		}
+	}
+
+	settings, err := loadPricing()
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch store settings")
+		return
+	}
+
+	quote := settings.Quote(pricing.Input{
+		Lines:        quoteLines(lines, discount),
+		FreeShipping: discount.FreeShipping,
+	})
+	response.Subtotal = quote.Subtotal
+	response.Discount = quote.Discount
+	response.Tax = quote.Tax
+	response.Shipping = quote.Shipping
+	response.Total = quote.Total
+	response.Lines = quote.Lines
+	response.TaxRate = quote.TaxRate
+	response.TaxMode = quote.TaxMode
+
+	lib.RespondWithJSON(w, statusCode, response)
+}
+
+// cartDiscountLines prices the cart items, using the variant price and weight where one is chosen
+func cartDiscountLines(cart db.Cart) []discountLine {
+	lines := make([]discountLine, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		price := item.Product.Price
+		if item.Variant != nil {
+			price = item.Variant.Price
+		}
+		lines = append(lines, discountLine{
+			ProductID:  item.ProductID,
+			CategoryID: item.Product.CategoryID,
+			UnitPrice:  price,
+			Quantity:   item.Quantity,
+			Weight:     unitWeight(item.Product, item.Variant),
+		})
+	}
+	return lines
+}
+
+// mergeGuestCart moves the items of an anonymous session cart into the user's cart
+// and returns the session ID of the user's cart
+func mergeGuestCart(sessionID string, userID uuid.UUID) (string, error) {
+	var resultSessionID string
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var userCart db.Cart
+		err := tx.Where(&db.Cart{UserID: &userID}).First(&userCart).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		hasUserCart := err == nil
+
+		var guestCart db.Cart
+		if sessionID != "" {
+			err = tx.Preload("Items").Where("session_id = ? AND \"userId\" IS NULL", sessionID).First(&guestCart).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		hasGuestCart := sessionID != "" && err == nil
+
+		switch {
+		case !hasGuestCart:
+			// Nothing to merge
+			if hasUserCart {
+				resultSessionID = userCart.SessionID
+			}
+			return nil
+
+		case !hasUserCart:
+			// The guest cart simply becomes the user's cart
+			resultSessionID = guestCart.SessionID
+			return tx.Model(&guestCart).Update("userId", userID).Error
+		}
+
+		for _, guestItem := range guestCart.Items {
+			var existing db.CartItem
+			query := tx.Where(&db.CartItem{CartID: userCart.ID, ProductID: guestItem.ProductID})
+			if guestItem.VariantID != nil {
+				query = query.Where("\"variantId\" = ?", *guestItem.VariantID)
+			} else {
+				query = query.Where("\"variantId\" IS NULL")
+			}
+			err := query.First(&existing).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// Move the line over as-is
+				if err := tx.Model(&guestItem).Update("cartId", userCart.ID).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			// Combine quantities, capped at available stock. Lines for
+			// products that are gone are left for checkout to reject.
+			quantity := existing.Quantity + guestItem.Quantity
+			_, available, err := cartStock(existing.ProductID, existing.VariantID)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, errVariantMismatch) {
+				return err
+			}
+			if err == nil && quantity > available {
+				quantity = available
+			}
+
+			if err := tx.Delete(&guestItem).Error; err != nil {
+				return err
+			}
+			if quantity <= 0 {
+				// Nothing left to buy
+				if err := tx.Delete(&existing).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Model(&existing).Update("quantity", quantity).Error; err != nil {
+				return err
+			}
+		}
+
+		// Keep a coupon applied as a guest unless the user's cart has its own
+		if guestCart.CouponCode != nil && userCart.CouponCode == nil {
+			if err := tx.Model(&userCart).Update("coupon_code", *guestCart.CouponCode).Error; err != nil {
+				return err
+			}
+		}
+
+		resultSessionID = userCart.SessionID
+		return tx.Delete(&guestCart).Error
+	})
+
+	return resultSessionID, err
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beauty-shop/app/api/db"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// createTestCart creates a cart with items, owned by userID when it is set
+func createTestCart(t *testing.T, sessionID string, userID *uuid.UUID, items ...db.CartItem) db.Cart {
+	t.Helper()
+
+	cart := db.Cart{SessionID: sessionID, UserID: userID}
+	if err := db.DB.Create(&cart).Error; err != nil {
+		t.Fatalf("create cart: %v", err)
+	}
+	for _, item := range items {
+		item.CartID = cart.ID
+		if err := db.DB.Omit(clause.Associations).Create(&item).Error; err != nil {
+			t.Fatalf("create cart item: %v", err)
+		}
+	}
+	return cart
+}
+
+// cartQuantities maps each line of a stored cart to its quantity, keyed by
+// product ID and then variant ID
+func cartQuantities(t *testing.T, query string, args ...interface{}) map[string]int {
+	t.Helper()
+
+	var cart db.Cart
+	if err := loadCart(db.DB, &cart, query, args...); err != nil {
+		t.Fatalf("load cart: %v", err)
+	}
+
+	quantities := map[string]int{}
+	for _, item := range cart.Items {
+		key := item.ProductID.String()
+		if item.VariantID != nil {
+			key += "/" + *item.VariantID
+		}
+		quantities[key] = item.Quantity
+	}
+	return quantities
+}
+
+// expectCart compares the quantities of a cart with want
+func expectCart(t *testing.T, got, want map[string]int) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("cart = %v, want %v", got, want)
+		return
+	}
+	for key, quantity := range want {
+		if got[key] != quantity {
+			t.Errorf("cart = %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestCartStock(t *testing.T) {
+	openTestDB(t)
+
+	category := createTestCategory(t, "Cart stock")
+	toner := createTestProduct(t, category, db.Product{Name: "Rose toner", Price: 1200, StockQuantity: 3})
+	serum := createTestProduct(t, category, db.Product{Name: "Niacinamide serum", Price: 2000, StockQuantity: 10})
+	soldOut := createTestProduct(t, category, db.Product{Name: "Clay mask", Price: 1500})
+
+	variant := db.ProductVariant{ID: "serum-50ml", ProductID: serum.ID, Name: "50ml", Price: 3000, StockQuantity: 2}
+	if err := db.DB.Create(&variant).Error; err != nil {
+		t.Fatalf("create variant: %v", err)
+	}
+
+	add := func(productID uuid.UUID, variantID string, quantity int) *httptest.ResponseRecorder {
+		t.Helper()
+		req := AddToCartRequest{CartID: "guest-stock", ProductID: productID.String(), VariantID: variantID, Quantity: quantity}
+		return postJSON(t, CartHandler, req)
+	}
+
+	tests := []struct {
+		name      string
+		productID uuid.UUID
+		variantID string
+		quantity  int
+		want      int
+	}{
+		{"within stock", toner.ID, "", 2, http.StatusOK},
+		{"combined with the line beyond stock", toner.ID, "", 2, http.StatusConflict},
+		{"up to the last unit", toner.ID, "", 1, http.StatusOK},
+		{"variant within its own stock", serum.ID, variant.ID, 2, http.StatusOK},
+		{"variant beyond its own stock", serum.ID, variant.ID, 1, http.StatusConflict},
+		{"variant of another product", toner.ID, variant.ID, 1, http.StatusBadRequest},
+		{"out of stock", soldOut.ID, "", 1, http.StatusConflict},
+		{"unknown product", uuid.Must(uuid.NewV4()), "", 1, http.StatusNotFound},
+		{"unknown variant", serum.ID, "serum-100ml", 1, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := add(tt.productID, tt.variantID, tt.quantity); rec.Code != tt.want {
+				t.Errorf("status code = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	expectCart(t, cartQuantities(t, "session_id = ?", "guest-stock"), map[string]int{
+		toner.ID.String():                    3,
+		serum.ID.String() + "/" + variant.ID: 2,
+	})
+
+	// Changing a line's quantity is checked against stock too
+	var item db.CartItem
+	if err := db.DB.Where("\"productId\" = ?", toner.ID).First(&item).Error; err != nil {
+		t.Fatalf("load cart item: %v", err)
+	}
+	update := func(quantity int) int {
+		t.Helper()
+		body, _ := json.Marshal(UpdateCartItemRequest{Quantity: &quantity})
+		req := httptest.NewRequest(http.MethodPut, "/cart/"+item.ID.String()+"?cartId=guest-stock", bytes.NewReader(body))
+		req.SetPathValue("id", item.ID.String())
+		rec := httptest.NewRecorder()
+		CartItemHandler(rec, req)
+		return rec.Code
+	}
+	if code := update(4); code != http.StatusConflict {
+		t.Errorf("update beyond stock: status code = %d, want 409", code)
+	}
+	if code := update(1); code != http.StatusOK {
+		t.Errorf("update within stock: status code = %d, want 200", code)
+	}
+}
+
+func TestMergeGuestCart(t *testing.T) {
+	openTestDB(t)
+
+	category := createTestCategory(t, "Cart merge")
+	toner := createTestProduct(t, category, db.Product{Name: "Rose toner", Price: 1200, StockQuantity: 5})
+	serum := createTestProduct(t, category, db.Product{Name: "Niacinamide serum", Price: 2000, StockQuantity: 10})
+	mask := createTestProduct(t, category, db.Product{Name: "Clay mask", Price: 1500})
+
+	variant := db.ProductVariant{ID: "toner-travel", ProductID: toner.ID, Name: "Travel size", Price: 600, StockQuantity: 2}
+	if err := db.DB.Create(&variant).Error; err != nil {
+		t.Fatalf("create variant: %v", err)
+	}
+
+	t.Run("into the user's cart", func(t *testing.T) {
+		user := createTestUser(t, "merge@example.com")
+		createTestCart(t, "user-cart", &user.ID,
+			db.CartItem{ProductID: toner.ID, Quantity: 3},
+			db.CartItem{ProductID: toner.ID, VariantID: &variant.ID, Quantity: 1},
+			db.CartItem{ProductID: mask.ID, Quantity: 1},
+		)
+		guest := createTestCart(t, "guest-cart", nil,
+			db.CartItem{ProductID: toner.ID, Quantity: 4},
+			db.CartItem{ProductID: toner.ID, VariantID: &variant.ID, Quantity: 4},
+			db.CartItem{ProductID: serum.ID, Quantity: 2},
+			db.CartItem{ProductID: mask.ID, Quantity: 1},
+		)
+		coupon := "WELCOME10"
+		if err := db.DB.Model(&guest).Update("coupon_code", coupon).Error; err != nil {
+			t.Fatalf("apply coupon: %v", err)
+		}
+
+		sessionID, err := mergeGuestCart("guest-cart", user.ID)
+		if err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+		if sessionID != "user-cart" {
+			t.Errorf("session = %q, want the user's cart", sessionID)
+		}
+
+		// Combined lines are capped at stock and sold out lines dropped
+		expectCart(t, cartQuantities(t, "session_id = ?", "user-cart"), map[string]int{
+			toner.ID.String():                    5,
+			toner.ID.String() + "/" + variant.ID: 2,
+			serum.ID.String():                    2,
+		})
+
+		var merged db.Cart
+		if err := db.DB.Where("session_id = ?", "user-cart").First(&merged).Error; err != nil {
+			t.Fatalf("load cart: %v", err)
+		}
+		if merged.CouponCode == nil || *merged.CouponCode != coupon {
+			t.Errorf("coupon = %v, want the guest's %s", merged.CouponCode, coupon)
+		}
+
+		var left int64
+		db.DB.Model(&db.Cart{}).Where("session_id = ?", "guest-cart").Count(&left)
+		if left != 0 {
+			t.Error("guest cart was not removed")
+		}
+	})
+
+	t.Run("becomes the user's cart", func(t *testing.T) {
+		user := createTestUser(t, "first-cart@example.com")
+		createTestCart(t, "first-guest-cart", nil, db.CartItem{ProductID: serum.ID, Quantity: 1})
+
+		sessionID, err := mergeGuestCart("first-guest-cart", user.ID)
+		if err != nil {
+			t.Fatalf("merge: %v", err)
+		}
+		if sessionID != "first-guest-cart" {
+			t.Errorf("session = %q, want the guest cart", sessionID)
+		}
+
+		var cart db.Cart
+		if err := db.DB.Where("session_id = ?", sessionID).First(&cart).Error; err != nil {
+			t.Fatalf("load cart: %v", err)
+		}
+		if cart.UserID == nil || *cart.UserID != user.ID {
+			t.Errorf("cart owner = %v, want %s", cart.UserID, user.ID)
+		}
+	})
+}
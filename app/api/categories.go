@@ -4,14 +4,11 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
 )
 
-// Handler handles HTTP requests for the categories endpoint
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
-
+// CategoriesHandler handles HTTP requests for the categories endpoint
+func CategoriesHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -32,6 +29,13 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Get category slug from query parameter
 	slug := r.URL.Query().Get("slug")
 
+	// Every response carries breadcrumbs, which need the whole hierarchy
+	index, err := loadCategoryIndex(db.DB)
+	if err != nil {
+		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+		return
+	}
+
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
@@ -42,9 +46,20 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Category not found", http.StatusNotFound)
 			return
 		}
+		category.Breadcrumbs = index.Breadcrumbs(category.ID)
 
 		// Return the category
 		json.NewEncoder(w).Encode(category)
+	} else if r.URL.Query().Get("tree") == "true" {
+		// Get the nested hierarchy with product counts
+		counts, err := countProductsByCategory(db.DB)
+		if err != nil {
+			http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
+			return
+		}
+
+		// Return the tree
+		json.NewEncoder(w).Encode(index.Tree(counts))
 	} else {
 		// Get all categories
 		var categories []db.Category
@@ -52,9 +67,11 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
 			return
 		}
+		for i := range categories {
+			categories[i].Breadcrumbs = index.Breadcrumbs(categories[i].ID)
+		}
 
 		// Return categories
 		json.NewEncoder(w).Encode(categories)
 	}
 }
-
@@ -0,0 +1,404 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// categoryTreeLock is the advisory lock key serialising changes to the
+// category hierarchy, so two concurrent moves cannot together form a cycle
+const categoryTreeLock = 4301
+
+// CategoryRequest represents the admin request to create or update a category.
+// An update leaves the parent alone unless parentId is sent; a null parentId
+// makes it a root category.
+type CategoryRequest struct {
+	Name        string     `json:"name"`
+	Slug        *string    `json:"slug,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	Image       *string    `json:"image,omitempty"`
+	ParentID    optionalID `json:"parentId"`
+}
+
+// optionalID is an ID field that tells an absent field apart from an explicit
+// null: Set is true whenever the field was sent, and ID is nil for null
+type optionalID struct {
+	Set bool
+	ID  *string
+}
+
+// UnmarshalJSON records that the field was sent, including as null
+func (o *optionalID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.ID)
+}
+
+// AdminCategoriesHandler handles admin requests to create categories
+func AdminCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	category, err := saveCategory(nil, req)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to save category")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusCreated, category)
+}
+
+// AdminCategoryHandler handles admin requests to update, move and archive a category
+func AdminCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	categoryID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		var req CategoryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		category, err := saveCategory(&categoryID, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save category")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, category)
+
+	case "DELETE":
+		if err := archiveCategory(categoryID); err != nil {
+			respondWithAPIError(w, err, "Failed to save category")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Category archived"})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ArchivedCategoriesHandler lists archived categories
+func ArchivedCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var categories []db.Category
+	if err := db.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&categories).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch archived categories")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, categories)
+}
+
+// RestoreCategoryHandler restores an archived category
+func RestoreCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	categoryID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	if err := restoreCategory(categoryID); err != nil {
+		respondWithAPIError(w, err, "Failed to save category")
+		return
+	}
+
+	var category db.Category
+	if err := db.DB.First(&category, "_id = ?", categoryID).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to load category")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, category)
+}
+
+// PurgeCategoryHandler permanently deletes an archived category
+func PurgeCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	categoryID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return
+	}
+
+	if err := purgeCategory(categoryID); err != nil {
+		respondWithAPIError(w, err, "Failed to save category")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Category permanently deleted"})
+}
+
+// saveCategory creates a category, or updates the one with categoryID.
+// Moving a category below itself or one of its descendants is rejected.
+func saveCategory(categoryID *uuid.UUID, req CategoryRequest) (*db.Category, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, &apiError{http.StatusBadRequest, "Name is required"}
+	}
+
+	var parentID *uuid.UUID
+	if req.ParentID.ID != nil && *req.ParentID.ID != "" {
+		id, err := uuid.FromString(*req.ParentID.ID)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Invalid parent category ID"}
+		}
+		parentID = &id
+	}
+
+	var category db.Category
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", categoryTreeLock).Error; err != nil {
+			return err
+		}
+
+		if categoryID != nil {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, "_id = ?", *categoryID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Category not found"}
+				}
+				return err
+			}
+		}
+
+		if parentID != nil {
+			var parentCount int64
+			if err := tx.Model(&db.Category{}).Where("_id = ?", *parentID).Count(&parentCount).Error; err != nil {
+				return err
+			}
+			if parentCount == 0 {
+				return &apiError{http.StatusBadRequest, "Parent category not found"}
+			}
+
+			// The new parent must not sit inside the category being moved
+			if categoryID != nil {
+				var subtree []uuid.UUID
+				if err := tx.Raw(descendantCategoriesSQL, *categoryID).Scan(&subtree).Error; err != nil {
+					return err
+				}
+				for _, id := range subtree {
+					if id == *parentID {
+						return &apiError{http.StatusConflict, "A category cannot be moved below itself or one of its subcategories"}
+					}
+				}
+			}
+		}
+
+		// Keep the existing slug unless a new one is asked for
+		if categoryID == nil || req.Slug != nil {
+			source := req.Name
+			if req.Slug != nil {
+				source = *req.Slug
+			}
+			slug, err := uniqueSlug(tx, &db.Category{}, source, category.ID)
+			if err != nil {
+				return err
+			}
+			category.Slug = slug
+		}
+
+		category.Name = req.Name
+		category.Description = req.Description
+		category.Image = req.Image
+		if categoryID == nil || req.ParentID.Set {
+			category.ParentID = parentID
+		}
+
+		return conflictOnDuplicate(tx.Omit(clause.Associations).Save(&category).Error, "Slug is already in use")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := loadCategoryIndex(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	category.Breadcrumbs = index.Breadcrumbs(category.ID)
+
+	return &category, nil
+}
+
+// archiveCategory soft deletes a category. Categories still holding active
+// products or subcategories must be emptied first.
+func archiveCategory(categoryID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", categoryTreeLock).Error; err != nil {
+			return err
+		}
+
+		var category db.Category
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&category, "_id = ?", categoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Category not found"}
+			}
+			return err
+		}
+
+		var productCount int64
+		if err := tx.Model(&db.Product{}).Where("\"categoryId\" = ?", categoryID).Count(&productCount).Error; err != nil {
+			return err
+		}
+		if productCount > 0 {
+			return &apiError{http.StatusConflict, "Category still has products; move or archive them first"}
+		}
+
+		var childCount int64
+		if err := tx.Model(&db.Category{}).Where("\"parentId\" = ?", categoryID).Count(&childCount).Error; err != nil {
+			return err
+		}
+		if childCount > 0 {
+			return &apiError{http.StatusConflict, "Category still has subcategories; move or archive them first"}
+		}
+
+		return tx.Delete(&category).Error
+	})
+}
+
+// restoreCategory brings an archived category back, provided its parent is active
+func restoreCategory(categoryID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", categoryTreeLock).Error; err != nil {
+			return err
+		}
+
+		var category db.Category
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at IS NOT NULL").First(&category, "_id = ?", categoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Archived category not found"}
+			}
+			return err
+		}
+
+		if category.ParentID != nil {
+			var parentCount int64
+			if err := tx.Model(&db.Category{}).Where("_id = ?", *category.ParentID).Count(&parentCount).Error; err != nil {
+				return err
+			}
+			if parentCount == 0 {
+				return &apiError{http.StatusConflict, "Restore the parent category first"}
+			}
+		}
+
+		return conflictOnDuplicate(tx.Unscoped().Model(&category).Update("deleted_at", nil).Error, "Slug is already in use")
+	})
+}
+
+// purgeCategory permanently removes an archived category that nothing,
+// archived or not, refers to any more
+func purgeCategory(categoryID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var category db.Category
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at IS NOT NULL").First(&category, "_id = ?", categoryID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Archived category not found"}
+			}
+			return err
+		}
+
+		var productCount int64
+		if err := tx.Unscoped().Model(&db.Product{}).Where("\"categoryId\" = ?", categoryID).Count(&productCount).Error; err != nil {
+			return err
+		}
+		if productCount > 0 {
+			return &apiError{http.StatusConflict, "Archived products still belong to this category"}
+		}
+
+		var childCount int64
+		if err := tx.Unscoped().Model(&db.Category{}).Where("\"parentId\" = ?", categoryID).Count(&childCount).Error; err != nil {
+			return err
+		}
+		if childCount > 0 {
+			return &apiError{http.StatusConflict, "Archived subcategories still belong to this category"}
+		}
+
+		// Drop the category from coupon scopes
+		if err := tx.Exec("DELETE FROM coupon_categories WHERE category_id = ?", categoryID).Error; err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Delete(&category).Error
+	})
+}
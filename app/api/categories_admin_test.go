@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCategoryRequestParentID(t *testing.T) {
+	tests := []struct {
+		body   string
+		set    bool
+		parent string
+	}{
+		{`{"name":"Serums"}`, false, ""},
+		{`{"name":"Serums","parentId":null}`, true, ""},
+		{`{"name":"Serums","parentId":"6ba7b810-9dad-11d1-80b4-00c04fd430c8"}`, true, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+	}
+
+	for _, tt := range tests {
+		var req CategoryRequest
+		if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+			t.Fatalf("decode %s: %v", tt.body, err)
+		}
+
+		parent := ""
+		if req.ParentID.ID != nil {
+			parent = *req.ParentID.ID
+		}
+		if req.ParentID.Set != tt.set || parent != tt.parent {
+			t.Errorf("%s: parentId set = %v, id = %q, want %v, %q", tt.body, req.ParentID.Set, parent, tt.set, tt.parent)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"sort"
+
+	"beauty-shop/app/api/db"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// descendantCategoriesSQL selects the ID of an active category and of all its
+// active descendants. UNION rather than UNION ALL stops at any cycle.
+const descendantCategoriesSQL = `WITH RECURSIVE tree AS (
+	SELECT _id FROM categories WHERE _id = ? AND deleted_at IS NULL
+	UNION
+	SELECT c._id FROM categories c JOIN tree t ON c."parentId" = t._id WHERE c.deleted_at IS NULL
+) SELECT _id FROM tree`
+
+// CategoryNode is a category in the nested tree response
+type CategoryNode struct {
+	db.Category
+
+	// ProductCount counts active products in this category and all below it
+	ProductCount int64           `json:"productCount"`
+	Children     []*CategoryNode `json:"children"`
+}
+
+// categoryIndex holds every active category by ID
+type categoryIndex map[uuid.UUID]db.Category
+
+// loadCategoryIndex loads all active categories
+func loadCategoryIndex(tx *gorm.DB) (categoryIndex, error) {
+	var categories []db.Category
+	if err := tx.Order("name ASC").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	index := make(categoryIndex, len(categories))
+	for _, category := range categories {
+		index[category.ID] = category
+	}
+	return index, nil
+}
+
+// Breadcrumbs returns the path from the root category down to id
+func (idx categoryIndex) Breadcrumbs(id uuid.UUID) []db.Breadcrumb {
+	var path []db.Breadcrumb
+	seen := make(map[uuid.UUID]bool)
+
+	for {
+		category, ok := idx[id]
+		if !ok || seen[id] {
+			break
+		}
+		seen[id] = true
+		path = append(path, db.Breadcrumb{ID: category.ID, Name: category.Name, Slug: category.Slug})
+
+		if category.ParentID == nil {
+			break
+		}
+		id = *category.ParentID
+	}
+
+	// Walked leaf to root; breadcrumbs read root to leaf
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Tree nests the categories under their parents, root categories first.
+// Categories whose parent is archived are shown at the root.
+func (idx categoryIndex) Tree(productCounts map[uuid.UUID]int64) []*CategoryNode {
+	nodes := make(map[uuid.UUID]*CategoryNode, len(idx))
+	for id, category := range idx {
+		category.Breadcrumbs = idx.Breadcrumbs(id)
+		nodes[id] = &CategoryNode{Category: category, Children: []*CategoryNode{}}
+	}
+
+	var roots []*CategoryNode
+	for _, node := range nodes {
+		if node.ParentID != nil {
+			if parent, ok := nodes[*node.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	sortCategoryNodes(roots)
+	for _, root := range roots {
+		sumProductCounts(root, productCounts)
+	}
+	return roots
+}
+
+// sumProductCounts sets each node's count to its own products plus its descendants'
+func sumProductCounts(node *CategoryNode, productCounts map[uuid.UUID]int64) int64 {
+	node.ProductCount = productCounts[node.ID]
+	for _, child := range node.Children {
+		node.ProductCount += sumProductCounts(child, productCounts)
+	}
+	return node.ProductCount
+}
+
+// sortCategoryNodes orders nodes by name at every level
+func sortCategoryNodes(nodes []*CategoryNode) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	for _, node := range nodes {
+		sortCategoryNodes(node.Children)
+	}
+}
+
+// countProductsByCategory counts active products directly in each category
+func countProductsByCategory(tx *gorm.DB) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		CategoryID uuid.UUID
+		Count      int64
+	}
+	if err := tx.Model(&db.Product{}).
+		Select("\"categoryId\" AS category_id, COUNT(*) AS count").
+		Group("\"categoryId\"").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.CategoryID] = row.Count
+	}
+	return counts, nil
+}
+
+// setProductBreadcrumbs fills in the category path of each product
+func setProductBreadcrumbs(tx *gorm.DB, products ...*db.Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	index, err := loadCategoryIndex(tx)
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		product.Breadcrumbs = index.Breadcrumbs(product.CategoryID)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// couponCodePattern matches a normalized coupon code
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// CouponRequest represents the request to create or replace a coupon
+type CouponRequest struct {
+	Code              string        `json:"code"`
+	Description       *string       `json:"description"`
+	Type              db.CouponType `json:"type"`
+	Value             int           `json:"value"`
+	MaxDiscount       *int          `json:"maxDiscount"`
+	BuyQuantity       int           `json:"buyQuantity"`
+	GetQuantity       int           `json:"getQuantity"`
+	MinSpend          int           `json:"minSpend"`
+	UsageLimit        *int          `json:"usageLimit"`
+	UsageLimitPerUser *int          `json:"usageLimitPerUser"`
+	StartsAt          *time.Time    `json:"startsAt"`
+	EndsAt            *time.Time    `json:"endsAt"`
+	Active            *bool         `json:"active"`
+	ProductIDs        []string      `json:"productIds"`
+	CategoryIDs       []string      `json:"categoryIds"`
+}
+
+// AdminCouponsHandler lists coupons (GET) or creates one (POST)
+func AdminCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		limit, after, err := lib.ParseCursorParams(r, "newest")
+		if err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+
+		query := db.DB.Model(&db.Coupon{})
+		if condition, args := lib.KeysetCondition("created_at", "_id", true, after); condition != "" {
+			query = query.Where(condition, args...)
+		}
+
+		coupons := []db.Coupon{}
+		if err := query.Order("created_at DESC, _id DESC").Limit(limit + 1).Find(&coupons).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch coupons")
+			return
+		}
+
+		fetched := len(coupons)
+		var next lib.Cursor
+		if fetched > limit {
+			coupons = coupons[:limit]
+			last := coupons[limit-1]
+			next = lib.Cursor{Sort: "newest", Time: &last.CreatedAt, ID: last.ID.String()}
+		}
+
+		pagination, err := lib.NewCursorPagination(limit, fetched, next)
+		if err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch coupons")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+			"coupons":    coupons,
+			"pagination": pagination,
+		})
+
+	case "POST":
+		var req CouponRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		coupon, err := saveCoupon(nil, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save coupon")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusCreated, coupon)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// AdminCouponHandler reads, replaces or deletes a coupon. Coupons that have
+// been redeemed are deactivated instead of deleted.
+func AdminCouponHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	couponID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid coupon ID")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var coupon db.Coupon
+		if err := db.DB.Preload("Products").Preload("Categories").First(&coupon, "_id = ?", couponID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lib.RespondWithError(w, http.StatusNotFound, "Coupon not found")
+				return
+			}
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch coupon")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, coupon)
+
+	case "PUT":
+		var req CouponRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		coupon, err := saveCoupon(&couponID, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save coupon")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, coupon)
+
+	case "DELETE":
+		var deactivated bool
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var coupon db.Coupon
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&coupon, "_id = ?", couponID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Coupon not found"}
+				}
+				return err
+			}
+
+			// Keep redeemed coupons so past orders can still be traced to them
+			var redemptions int64
+			if err := tx.Model(&db.CouponRedemption{}).Where("\"couponId\" = ?", couponID).Count(&redemptions).Error; err != nil {
+				return err
+			}
+			if redemptions > 0 {
+				deactivated = true
+				return tx.Model(&coupon).Update("active", false).Error
+			}
+
+			if err := tx.Model(&coupon).Association("Products").Clear(); err != nil {
+				return err
+			}
+			if err := tx.Model(&coupon).Association("Categories").Clear(); err != nil {
+				return err
+			}
+			return tx.Delete(&coupon).Error
+		})
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save coupon")
+			return
+		}
+
+		message := "Coupon deleted"
+		if deactivated {
+			message = "Coupon has been redeemed and was deactivated instead"
+		}
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": message})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// validate checks the coupon rules and returns the coupon they describe
+func (req CouponRequest) validate() (db.Coupon, error) {
+	coupon := db.Coupon{
+		Code:              normalizeCouponCode(req.Code),
+		Description:       req.Description,
+		Type:              req.Type,
+		Value:             req.Value,
+		MaxDiscount:       req.MaxDiscount,
+		BuyQuantity:       req.BuyQuantity,
+		GetQuantity:       req.GetQuantity,
+		MinSpend:          req.MinSpend,
+		UsageLimit:        req.UsageLimit,
+		UsageLimitPerUser: req.UsageLimitPerUser,
+		StartsAt:          req.StartsAt,
+		EndsAt:            req.EndsAt,
+		Active:            req.Active == nil || *req.Active,
+	}
+
+	if !couponCodePattern.MatchString(coupon.Code) {
+		return coupon, &apiError{http.StatusBadRequest, "Code must be 3 to 32 letters, digits, dashes or underscores"}
+	}
+	if !coupon.Type.IsValid() {
+		return coupon, &apiError{http.StatusBadRequest, "Type must be PERCENTAGE, FIXED_AMOUNT, FREE_SHIPPING or BUY_X_GET_Y"}
+	}
+
+	switch coupon.Type {
+	case db.CouponTypePercentage:
+		if coupon.Value < 1 || coupon.Value > 100 {
+			return coupon, &apiError{http.StatusBadRequest, "Percentage value must be between 1 and 100"}
+		}
+	case db.CouponTypeFixedAmount:
+		if coupon.Value <= 0 {
+			return coupon, &apiError{http.StatusBadRequest, "Fixed amount value must be positive"}
+		}
+	case db.CouponTypeBuyXGetY:
+		if coupon.BuyQuantity < 1 || coupon.GetQuantity < 1 {
+			return coupon, &apiError{http.StatusBadRequest, "Buy and get quantities must be at least 1"}
+		}
+	}
+
+	switch {
+	case coupon.MaxDiscount != nil && *coupon.MaxDiscount <= 0:
+		return coupon, &apiError{http.StatusBadRequest, "Maximum discount must be positive"}
+	case coupon.MinSpend < 0:
+		return coupon, &apiError{http.StatusBadRequest, "Minimum spend cannot be negative"}
+	case coupon.UsageLimit != nil && *coupon.UsageLimit <= 0:
+		return coupon, &apiError{http.StatusBadRequest, "Usage limit must be positive"}
+	case coupon.UsageLimitPerUser != nil && *coupon.UsageLimitPerUser <= 0:
+		return coupon, &apiError{http.StatusBadRequest, "Per-customer usage limit must be positive"}
+	case coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt):
+		return coupon, &apiError{http.StatusBadRequest, "End date must be after the start date"}
+	}
+
+	return coupon, nil
+}
+
+// saveCoupon creates a coupon, or replaces the one with couponID, together
+// with its product and category scope
+func saveCoupon(couponID *uuid.UUID, req CouponRequest) (*db.Coupon, error) {
+	coupon, err := req.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	productIDs, err := parseUUIDs(req.ProductIDs, "product")
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs, err := parseUUIDs(req.CategoryIDs, "category")
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if couponID != nil {
+			var existing db.Coupon
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&existing, "_id = ?", *couponID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Coupon not found"}
+				}
+				return err
+			}
+			coupon.Base = existing.Base
+			coupon.UsedCount = existing.UsedCount
+		}
+
+		var products []db.Product
+		if len(productIDs) > 0 {
+			if err := tx.Where("_id IN ?", productIDs).Find(&products).Error; err != nil {
+				return err
+			}
+			if len(products) != len(productIDs) {
+				return &apiError{http.StatusBadRequest, "One or more products were not found"}
+			}
+		}
+
+		var categories []db.Category
+		if len(categoryIDs) > 0 {
+			if err := tx.Where("_id IN ?", categoryIDs).Find(&categories).Error; err != nil {
+				return err
+			}
+			if len(categories) != len(categoryIDs) {
+				return &apiError{http.StatusBadRequest, "One or more categories were not found"}
+			}
+		}
+
+		if err := tx.Omit(clause.Associations).Save(&coupon).Error; err != nil {
+			return conflictOnDuplicate(err, "Coupon code is already in use")
+		}
+		if err := tx.Model(&coupon).Association("Products").Replace(products); err != nil {
+			return err
+		}
+		if err := tx.Model(&coupon).Association("Categories").Replace(categories); err != nil {
+			return err
+		}
+		coupon.Products, coupon.Categories = products, categories
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &coupon, nil
+}
+
+// parseUUIDs parses a list of IDs, dropping duplicates
+func parseUUIDs(raw []string, label string) ([]uuid.UUID, error) {
+	seen := make(map[uuid.UUID]bool, len(raw))
+	ids := make([]uuid.UUID, 0, len(raw))
+	for _, s := range raw {
+		id, err := uuid.FromString(s)
+		if err != nil {
+			return nil, &apiError{http.StatusBadRequest, "Invalid " + label + " ID: " + s}
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+)
+
+// CustomerSummary is a customer in the admin customer list
+type CustomerSummary struct {
+	db.User
+	OrderCount int64 `json:"orderCount"`
+	TotalSpent int64 `json:"totalSpent"`
+}
+
+// AdminCustomersHandler lists customers, newest first, one cursor page at a time.
+// The optional q parameter matches the name or email.
+func AdminCustomersHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit, after, err := lib.ParseCursorParams(r, "newest")
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	query := db.DB.Where("role = ?", db.RoleUser)
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("lower(email) LIKE ? OR lower(name) LIKE ?", pattern, pattern)
+	}
+	if condition, args := lib.KeysetCondition("created_at", "_id", true, after); condition != "" {
+		query = query.Where(condition, args...)
+	}
+
+	var users []db.User
+	if err := query.Order("created_at DESC, _id DESC").Limit(limit + 1).Find(&users).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch customers")
+		return
+	}
+
+	fetched := len(users)
+	var next lib.Cursor
+	if fetched > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		next = lib.Cursor{Sort: "newest", Time: &last.CreatedAt, ID: last.ID.String()}
+	}
+
+	customers, err := summarizeCustomers(users)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch customers")
+		return
+	}
+
+	pagination, err := lib.NewCursorPagination(limit, fetched, next)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch customers")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+		"customers":  customers,
+		"pagination": pagination,
+	})
+}
+
+// summarizeCustomers adds order totals to a page of users. Cancelled orders
+// are not counted.
+func summarizeCustomers(users []db.User) ([]CustomerSummary, error) {
+	customers := make([]CustomerSummary, len(users))
+	if len(users) == 0 {
+		return customers, nil
+	}
+
+	ids := make([]uuid.UUID, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+
+	var rows []struct {
+		UserID     uuid.UUID
+		OrderCount int64
+		TotalSpent int64
+	}
+	if err := db.DB.Model(&db.Order{}).
+		Select(`"userId" AS user_id, COUNT(*) AS order_count, COALESCE(SUM(total), 0) AS total_spent`).
+		Where(`"userId" IN ? AND status <> ?`, ids, db.OrderStatusCancelled).
+		Group(`"userId"`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uuid.UUID]int, len(rows))
+	for i, row := range rows {
+		totals[row.UserID] = i
+	}
+	for i, user := range users {
+		customers[i].User = user
+		if j, ok := totals[user.ID]; ok {
+			customers[i].OrderCount = rows[j].OrderCount
+			customers[i].TotalSpent = rows[j].TotalSpent
+		}
+	}
+	return customers, nil
+}
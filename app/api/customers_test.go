@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestAdminCustomersPages(t *testing.T) {
+	openTestDB(t)
+
+	const customers = 5
+	for i := 0; i < customers; i++ {
+		createTestUser(t, fmt.Sprintf("customer-%d@example.com", i))
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		AdminCustomersHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/customers?"+query, nil))
+		return rec
+	}
+
+	seen := map[string]bool{}
+	cursor, pages := "", 0
+	for {
+		rec := list("limit=2&cursor=" + url.QueryEscape(cursor))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status code = %d: %s", pages+1, rec.Code, rec.Body)
+		}
+		pages++
+
+		var response struct {
+			Data struct {
+				Customers  []CustomerSummary `json:"customers"`
+				Pagination struct {
+					NextCursor string `json:"nextCursor"`
+					HasNext    bool   `json:"hasNext"`
+				} `json:"pagination"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("decode page %d: %v", pages, err)
+		}
+		for _, customer := range response.Data.Customers {
+			if seen[customer.Email] {
+				t.Errorf("%s is listed twice", customer.Email)
+			}
+			seen[customer.Email] = true
+		}
+
+		if !response.Data.Pagination.HasNext {
+			break
+		}
+		cursor = response.Data.Pagination.NextCursor
+		if pages > customers {
+			t.Fatal("paging does not end")
+		}
+	}
+
+	if pages != 3 || len(seen) != customers {
+		t.Errorf("%d customers over %d pages, want %d over 3", len(seen), pages, customers)
+	}
+
+	// A cursor that was tampered with is refused
+	if rec := list("limit=2&cursor=" + url.QueryEscape(cursor+"x")); rec.Code != http.StatusBadRequest {
+		t.Errorf("tampered cursor: status code = %d, want 400", rec.Code)
+	}
+}
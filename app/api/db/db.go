@@ -4,9 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
@@ -33,6 +31,8 @@ func Initialize() {
 	var err error
 	DB, err = gorm.Open(postgres.Open(dbURL), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Report unique violations as gorm.ErrDuplicatedKey
+		TranslateError: true,
 	})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -41,30 +41,53 @@ func Initialize() {
 	fmt.Println("Connected to PostgreSQL database")
 
 	// Auto migrate the schema
-	err = DB.AutoMigrate(
+	if err := Migrate(DB); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	fmt.Println("Database migration completed")
+
+	// Seed the database if it's empty
+	SeedDatabase()
+}
+
+// Migrate creates or updates the schema on conn
+func Migrate(conn *gorm.DB) error {
+	if err := conn.AutoMigrate(
 		&User{},
+		&UserToken{},
+		&Session{},
 		&Product{},
 		&ProductImage{},
 		&Category{},
 		&Order{},
 		&OrderItem{},
+		&OrderStatusHistory{},
+		&Payment{},
+		&Refund{},
+		&RefundItem{},
 		&Cart{},
 		&CartItem{},
 		&Review{},
+		&ReviewVote{},
 		&WishlistItem{},
+		&Notification{},
 		&Address{},
 		&ProductAttribute{},
 		&ProductVariant{},
+		&Coupon{},
+		&CouponRedemption{},
+		&ShippingZone{},
+		&ShippingZoneRegion{},
+		&ShippingMethod{},
+		&ShippingRateTier{},
 		&Settings{},
-	)
-	if err != nil {
-		log.Fatalf("Failed to migrate database: %v", err)
+	); err != nil {
+		return err
 	}
 
-	fmt.Println("Database migration completed")
-
-	// Seed the database if it's empty
-	SeedDatabase()
+	// Set up full-text search, which AutoMigrate cannot express
+	return migrateSearch(conn)
 }
 
 // SeedDatabase seeds the database with initial data if it's empty
@@ -237,7 +260,9 @@ func SeedDatabase() {
 				"standardShippingRate":  500,
 			},
 			"tax": map[string]interface{}{
-				"rate": 16, // 16% VAT
+				"rate":     16, // 16% VAT
+				"mode":     "EXCLUSIVE",
+				"rounding": "HALF_UP",
 			},
 		},
 	}
@@ -246,6 +271,55 @@ func SeedDatabase() {
 	}
 
 	fmt.Println("Store settings created")
+
+	// Create shipping zones: Nairobi, the rest of Kenya and East Africa
+	zones := []ShippingZone{
+		{
+			Name:     "Nairobi",
+			Position: 0,
+			Active:   true,
+			Regions:  []ShippingZoneRegion{{Country: "KE", County: stringPtr("Nairobi")}},
+			Methods: []ShippingMethod{
+				{Name: "Standard delivery", Type: ShippingMethodStandard, Basis: ShippingBasisPrice, MinDays: intPtr(1), MaxDays: intPtr(2), Position: 0, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(5000), Rate: 250}, {Min: 5000, Rate: 0}}},
+				{Name: "Same-day express", Type: ShippingMethodExpress, Basis: ShippingBasisPrice, MinDays: intPtr(0), MaxDays: intPtr(0), Position: 1, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Rate: 450}}},
+				{Name: "Pickup point", Type: ShippingMethodPickup, Basis: ShippingBasisPrice, MinDays: intPtr(1), MaxDays: intPtr(2), Position: 2, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(2000), Rate: 100}, {Min: 2000, Rate: 0}}},
+			},
+		},
+		{
+			Name:     "Rest of Kenya",
+			Position: 1,
+			Active:   true,
+			Regions:  []ShippingZoneRegion{{Country: "KE"}},
+			Methods: []ShippingMethod{
+				{Name: "Standard delivery", Type: ShippingMethodStandard, Basis: ShippingBasisPrice, MinDays: intPtr(2), MaxDays: intPtr(4), Position: 0, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(5000), Rate: 500}, {Min: 5000, Rate: 0}}},
+				{Name: "Express courier", Type: ShippingMethodExpress, Basis: ShippingBasisWeight, MinDays: intPtr(1), MaxDays: intPtr(2), Position: 1, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(1000), Rate: 800}, {Min: 1000, Max: intPtr(5000), Rate: 1200}, {Min: 5000, Rate: 2000}}},
+				{Name: "Pickup point", Type: ShippingMethodPickup, Basis: ShippingBasisPrice, MinDays: intPtr(2), MaxDays: intPtr(4), Position: 2, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(5000), Rate: 300}, {Min: 5000, Rate: 0}}},
+			},
+		},
+		{
+			Name:     "East Africa",
+			Position: 2,
+			Active:   true,
+			Regions: []ShippingZoneRegion{
+				{Country: "UG"}, {Country: "TZ"}, {Country: "RW"}, {Country: "BI"}, {Country: "SS"},
+			},
+			Methods: []ShippingMethod{
+				{Name: "Regional courier", Type: ShippingMethodStandard, Basis: ShippingBasisWeight, MinDays: intPtr(4), MaxDays: intPtr(8), Position: 0, Active: true,
+					Tiers: []ShippingRateTier{{Min: 0, Max: intPtr(1000), Rate: 2500}, {Min: 1000, Max: intPtr(5000), Rate: 4500}, {Min: 5000, Max: intPtr(20000), Rate: 8000}}},
+			},
+		},
+	}
+	if err := DB.Create(&zones).Error; err != nil {
+		log.Fatalf("Failed to create shipping zones: %v", err)
+	}
+
+	fmt.Println("Shipping zones created")
 	fmt.Println("Database seeded successfully!")
 }
 
@@ -254,5 +328,7 @@ func stringPtr(s string) *string {
 	return &s
 }
 
-
-
+// Helper function to create int pointers
+func intPtr(i int) *int {
+	return &i
+}
@@ -31,10 +31,97 @@ func (base *Base) BeforeCreate(tx *gorm.DB) error {
 type Role string
 
 const (
-	RoleUser  Role = "USER"
-	RoleAdmin Role = "ADMIN"
+	RoleUser             Role = "USER"
+	RoleAdmin            Role = "ADMIN"
+	RoleStaff            Role = "STAFF"
+	RoleInventoryManager Role = "INVENTORY_MANAGER"
+	RoleSupport          Role = "SUPPORT"
 )
 
+// AllRoles lists every role, in display order
+var AllRoles = []Role{RoleUser, RoleAdmin, RoleStaff, RoleInventoryManager, RoleSupport}
+
+// Permission names an action on the admin API
+type Permission string
+
+const (
+	PermissionDashboardRead   Permission = "dashboard:read"
+	PermissionOrdersRead      Permission = "orders:read"
+	PermissionOrdersWrite     Permission = "orders:write"
+	PermissionOrdersRefund    Permission = "orders:refund"
+	PermissionProductsWrite   Permission = "products:write"
+	PermissionInventoryWrite  Permission = "inventory:write"
+	PermissionCustomersRead   Permission = "customers:read"
+	PermissionReviewsModerate Permission = "reviews:moderate"
+	PermissionPromotionsWrite Permission = "promotions:write"
+	PermissionSettingsWrite   Permission = "settings:write"
+	PermissionUsersManage     Permission = "users:manage"
+)
+
+// AllPermissions lists every permission, in display order
+var AllPermissions = []Permission{
+	PermissionDashboardRead,
+	PermissionOrdersRead,
+	PermissionOrdersWrite,
+	PermissionOrdersRefund,
+	PermissionProductsWrite,
+	PermissionInventoryWrite,
+	PermissionCustomersRead,
+	PermissionReviewsModerate,
+	PermissionPromotionsWrite,
+	PermissionSettingsWrite,
+	PermissionUsersManage,
+}
+
+// rolePermissions lists the permissions granted to each role
+var rolePermissions = map[Role][]Permission{
+	RoleUser:  {},
+	RoleAdmin: AllPermissions,
+	RoleStaff: {
+		PermissionDashboardRead,
+		PermissionOrdersRead,
+		PermissionOrdersWrite,
+		PermissionCustomersRead,
+	},
+	RoleInventoryManager: {
+		PermissionDashboardRead,
+		PermissionOrdersRead,
+		PermissionInventoryWrite,
+	},
+	RoleSupport: {
+		PermissionOrdersRead,
+		PermissionOrdersRefund,
+		PermissionCustomersRead,
+		PermissionReviewsModerate,
+	},
+}
+
+// IsValid reports whether the role is a known role
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// IsStaff reports whether the role has access to the admin area
+func (r Role) IsStaff() bool {
+	return len(rolePermissions[r]) > 0
+}
+
+// Permissions returns the permissions granted to the role
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// HasPermission reports whether the role grants the permission
+func (r Role) HasPermission(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
 // OrderStatus enum
 type OrderStatus string
 
@@ -46,6 +133,31 @@ const (
 	OrderStatusCancelled  OrderStatus = "CANCELLED"
 )
 
+// orderStatusTransitions lists the statuses each order status may move to
+var orderStatusTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPending:    {OrderStatusProcessing, OrderStatusCancelled},
+	OrderStatusProcessing: {OrderStatusShipped, OrderStatusCancelled},
+	OrderStatusShipped:    {OrderStatusDelivered},
+	OrderStatusDelivered:  {},
+	OrderStatusCancelled:  {},
+}
+
+// IsValid reports whether the status is a known order status
+func (s OrderStatus) IsValid() bool {
+	_, ok := orderStatusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an order may move from this status to next
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
 // PaymentStatus enum
 type PaymentStatus string
 
@@ -56,6 +168,57 @@ const (
 	PaymentStatusRefunded PaymentStatus = "REFUNDED"
 )
 
+// ReviewStatus enum
+type ReviewStatus string
+
+const (
+	ReviewStatusPending  ReviewStatus = "PENDING"
+	ReviewStatusApproved ReviewStatus = "APPROVED"
+	ReviewStatusRejected ReviewStatus = "REJECTED"
+	ReviewStatusHidden   ReviewStatus = "HIDDEN"
+)
+
+// IsValid reports whether the status is a known review status
+func (s ReviewStatus) IsValid() bool {
+	switch s {
+	case ReviewStatusPending, ReviewStatusApproved, ReviewStatusRejected, ReviewStatusHidden:
+		return true
+	}
+	return false
+}
+
+// CouponType enum
+type CouponType string
+
+const (
+	// CouponTypePercentage takes Value percent off eligible items
+	CouponTypePercentage CouponType = "PERCENTAGE"
+	// CouponTypeFixedAmount takes Value KES off eligible items
+	CouponTypeFixedAmount CouponType = "FIXED_AMOUNT"
+	// CouponTypeFreeShipping waives the shipping fee
+	CouponTypeFreeShipping CouponType = "FREE_SHIPPING"
+	// CouponTypeBuyXGetY makes GetQuantity of every BuyQuantity+GetQuantity
+	// eligible units free, cheapest first
+	CouponTypeBuyXGetY CouponType = "BUY_X_GET_Y"
+)
+
+// IsValid reports whether the type is a known coupon type
+func (t CouponType) IsValid() bool {
+	switch t {
+	case CouponTypePercentage, CouponTypeFixedAmount, CouponTypeFreeShipping, CouponTypeBuyXGetY:
+		return true
+	}
+	return false
+}
+
+// TokenPurpose enum
+type TokenPurpose string
+
+const (
+	TokenPurposeVerifyEmail   TokenPurpose = "VERIFY_EMAIL"
+	TokenPurposeResetPassword TokenPurpose = "RESET_PASSWORD"
+)
+
 // AddressType enum
 type AddressType string
 
@@ -99,29 +262,68 @@ type User struct {
 	Addresses []Address      `json:"addresses,omitempty" gorm:"foreignKey:UserID"`
 }
 
+// UserToken model stores single-use tokens for email verification and password reset.
+// Only a hash of the token is stored.
+type UserToken struct {
+	Base
+	UserID    uuid.UUID    `json:"userId" gorm:"column:userId;index"`
+	User      User         `json:"-" gorm:"foreignKey:UserID"`
+	Purpose   TokenPurpose `json:"purpose" gorm:"index"`
+	TokenHash string       `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	UsedAt    *time.Time   `json:"usedAt"`
+}
+
+// Session model stores one refresh token of a login session. Every refresh
+// rotates the token into a new row of the same family; presenting a rotated
+// token again revokes the whole family.
+type Session struct {
+	Base
+	UserID     uuid.UUID  `json:"userId" gorm:"column:userId;index"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	FamilyID   uuid.UUID  `json:"familyId" gorm:"column:familyId;type:uuid;index"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	Device     string     `json:"device"`
+	IPAddress  string     `json:"ipAddress"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RotatedAt  *time.Time `json:"rotatedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
 // Product model
 type Product struct {
 	Base
-	Name          string  `json:"name"`
-	Slug          string  `json:"slug" gorm:"uniqueIndex"`
-	Description   string  `json:"description"`
-	Price         int     `json:"price"`
-	OriginalPrice *int    `json:"originalPrice"`
+	Name          string    `json:"name"`
+	Slug          string    `json:"slug" gorm:"uniqueIndex"`
+	Description   string    `json:"description"`
+	Price         int       `json:"price"`
+	OriginalPrice *int      `json:"originalPrice"`
 	CategoryID    uuid.UUID `json:"categoryId" gorm:"column:categoryId"`
-	Category      Category `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
-	Featured      bool    `json:"featured" gorm:"default:false"`
-	InStock       bool    `json:"inStock" gorm:"default:true"`
-	StockQuantity int     `json:"stockQuantity" gorm:"default:0"`
-	SKU           *string `json:"sku" gorm:"uniqueIndex"`
+	Category      Category  `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
+	Featured      bool      `json:"featured" gorm:"default:false"`
+	InStock       bool      `json:"inStock" gorm:"default:true"`
+	StockQuantity int       `json:"stockQuantity" gorm:"default:0"`
+	SKU           *string   `json:"sku" gorm:"uniqueIndex"`
+	Weight        *int      `json:"weight"` // Shipping weight in grams
+
+	// DeletedAt is set while the product is archived
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
+
+	// Breadcrumbs is the product's category path, filled in by handlers
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs,omitempty" gorm:"-"`
+
+	// RatingSummary aggregates approved reviews, filled in by handlers
+	RatingSummary *RatingSummary `json:"ratingSummary,omitempty" gorm:"-"`
 
 	// Relations
-	Images      []ProductImage     `json:"images,omitempty" gorm:"foreignKey:ProductID"`
-	OrderItems  []OrderItem       `json:"-" gorm:"foreignKey:ProductID"`
-	CartItems   []CartItem        `json:"-" gorm:"foreignKey:ProductID"`
-	Reviews     []Review          `json:"reviews,omitempty" gorm:"foreignKey:ProductID"`
-	Wishlist    []WishlistItem    `json:"-" gorm:"foreignKey:ProductID"`
-	Attributes  []ProductAttribute `json:"attributes,omitempty" gorm:"foreignKey:ProductID"`
-	Variants    []ProductVariant   `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	Images     []ProductImage     `json:"images,omitempty" gorm:"foreignKey:ProductID"`
+	OrderItems []OrderItem        `json:"-" gorm:"foreignKey:ProductID"`
+	CartItems  []CartItem         `json:"-" gorm:"foreignKey:ProductID"`
+	Reviews    []Review           `json:"reviews,omitempty" gorm:"foreignKey:ProductID"`
+	Wishlist   []WishlistItem     `json:"-" gorm:"foreignKey:ProductID"`
+	Attributes []ProductAttribute `json:"attributes,omitempty" gorm:"foreignKey:ProductID"`
+	Variants   []ProductVariant   `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
 }
 
 // ProductImage model
@@ -145,26 +347,110 @@ type Category struct {
 	Parent      *Category  `json:"parent,omitempty" gorm:"foreignKey:ParentID"`
 	Children    []Category `json:"children,omitempty" gorm:"foreignKey:ParentID"`
 	Products    []Product  `json:"products,omitempty" gorm:"foreignKey:CategoryID"`
+
+	// DeletedAt is set while the category is archived
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
+
+	// Breadcrumbs is the path from the root category down to this one, filled in by handlers
+	Breadcrumbs []Breadcrumb `json:"breadcrumbs,omitempty" gorm:"-"`
+}
+
+// Breadcrumb is one step of a category path
+type Breadcrumb struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Slug string    `json:"slug"`
 }
 
 // Order model
 type Order struct {
 	Base
-	UserID          *uuid.UUID    `json:"userId" gorm:"column:userId"`
-	User            *User         `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	OrderNumber     string        `json:"orderNumber" gorm:"uniqueIndex"`
-	Status          OrderStatus   `json:"status" gorm:"default:PENDING"`
-	Items           []OrderItem   `json:"items" gorm:"foreignKey:OrderID"`
-	Subtotal        int           `json:"subtotal"`
-	Tax             int           `json:"tax"`
-	Shipping        int           `json:"shipping"`
-	Total           int           `json:"total"`
-	ShippingAddress JSON          `json:"shippingAddress" gorm:"type:jsonb"`
-	BillingAddress  *JSON         `json:"billingAddress" gorm:"type:jsonb"`
-	PaymentMethod   string        `json:"paymentMethod"`
-	PaymentStatus   PaymentStatus `json:"paymentStatus" gorm:"default:PENDING"`
-	Notes           *string       `json:"notes"`
-	TrackingNumber  *string       `json:"trackingNumber"`
+	UserID          *uuid.UUID  `json:"userId" gorm:"column:userId"`
+	User            *User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	OrderNumber     string      `json:"orderNumber" gorm:"uniqueIndex"`
+	Status          OrderStatus `json:"status" gorm:"default:PENDING"`
+	Items           []OrderItem `json:"items" gorm:"foreignKey:OrderID"`
+	Subtotal        int         `json:"subtotal"`
+	Tax             int         `json:"tax"`
+	TaxInclusive    bool        `json:"taxInclusive" gorm:"default:false"`
+	Shipping        int         `json:"shipping"`
+	Total           int         `json:"total"`
+	Discount        int         `json:"discount" gorm:"default:0"`
+	CouponCode      *string     `json:"couponCode"`
+	ShippingAddress JSON        `json:"shippingAddress" gorm:"type:jsonb"`
+	BillingAddress  *JSON       `json:"billingAddress" gorm:"type:jsonb"`
+
+	// ShippingMethodID and ShippingDetails record the shipping method chosen
+	// at checkout; the details are a copy so later rate changes do not alter
+	// past orders
+	ShippingMethodID *uuid.UUID `json:"shippingMethodId" gorm:"column:shippingMethodId"`
+	ShippingDetails  *JSON      `json:"shippingDetails" gorm:"type:jsonb"`
+
+	PaymentMethod  string        `json:"paymentMethod"`
+	PaymentStatus  PaymentStatus `json:"paymentStatus" gorm:"default:PENDING"`
+	Notes          *string       `json:"notes"`
+	TrackingNumber *string       `json:"trackingNumber"`
+	RefundedAmount int           `json:"refundedAmount" gorm:"default:0"`
+	NetTotal       int           `json:"netTotal" gorm:"-"`
+
+	// Relations
+	History  []OrderStatusHistory `json:"history,omitempty" gorm:"foreignKey:OrderID"`
+	Payments []Payment            `json:"payments,omitempty" gorm:"foreignKey:OrderID"`
+	Refunds  []Refund             `json:"refunds,omitempty" gorm:"foreignKey:OrderID"`
+}
+
+// AfterFind computes the order total net of refunds
+func (o *Order) AfterFind(tx *gorm.DB) error {
+	o.NetTotal = o.Total - o.RefundedAmount
+	return nil
+}
+
+// Payment model records an attempt to collect payment for an order
+type Payment struct {
+	Base
+	OrderID       uuid.UUID     `json:"orderId" gorm:"column:orderId;index"`
+	Provider      string        `json:"provider"`
+	Reference     string        `json:"reference" gorm:"uniqueIndex"`
+	TransactionID *string       `json:"transactionId"`
+	Amount        int           `json:"amount"`
+	Status        PaymentStatus `json:"status" gorm:"default:PENDING"`
+	Description   *string       `json:"description"`
+}
+
+// Refund model records money returned for an order, in full or per line
+type Refund struct {
+	Base
+	OrderID           uuid.UUID     `json:"orderId" gorm:"column:orderId;index"`
+	PaymentID         uuid.UUID     `json:"paymentId" gorm:"column:paymentId"`
+	Amount            int           `json:"amount"`
+	Reason            *string       `json:"reason"`
+	ProviderReference *string       `json:"providerReference"`
+	Status            PaymentStatus `json:"status"`
+	Description       *string       `json:"description"`
+	Restocked         bool          `json:"restocked" gorm:"default:false"`
+	CreatedByID       *uuid.UUID    `json:"createdById" gorm:"column:createdById"`
+	Items             []RefundItem  `json:"items,omitempty" gorm:"foreignKey:RefundID"`
+}
+
+// RefundItem model records the quantity of an order line covered by a refund
+type RefundItem struct {
+	Base
+	RefundID    uuid.UUID `json:"refundId" gorm:"column:refundId;index"`
+	OrderItemID uuid.UUID `json:"orderItemId" gorm:"column:orderItemId;index"`
+	Quantity    int       `json:"quantity"`
+	Amount      int       `json:"amount"`
+}
+
+// OrderStatusHistory model records every status change of an order
+type OrderStatusHistory struct {
+	Base
+	OrderID        uuid.UUID   `json:"orderId" gorm:"column:orderId;index"`
+	FromStatus     OrderStatus `json:"fromStatus"`
+	ToStatus       OrderStatus `json:"toStatus"`
+	ChangedByID    *uuid.UUID  `json:"changedById" gorm:"column:changedById"`
+	ChangedBy      *User       `json:"changedBy,omitempty" gorm:"foreignKey:ChangedByID"`
+	Note           *string     `json:"note"`
+	TrackingNumber *string     `json:"trackingNumber"`
 }
 
 // OrderItem model
@@ -177,62 +463,126 @@ type OrderItem struct {
 	Name      string    `json:"name"`
 	Price     int       `json:"price"`
 	Quantity  int       `json:"quantity"`
-	Variant   *string   `json:"variant"`
+	Discount  int       `json:"discount" gorm:"default:0"`
+	Tax       int       `json:"tax" gorm:"default:0"`
+	Total     int       `json:"total" gorm:"default:0"` // Amount charged for the line; 0 on orders placed before line pricing
+	VariantID *string   `json:"variantId" gorm:"column:variantId"`
+	Variant   *string   `json:"variant"` // Variant name at the time of purchase
 }
 
 // Cart model
 type Cart struct {
 	Base
-	SessionID string     `json:"sessionId" gorm:"uniqueIndex"`
-	Items     []CartItem `json:"items" gorm:"foreignKey:CartID"`
+	SessionID  string     `json:"sessionId" gorm:"uniqueIndex"`
+	UserID     *uuid.UUID `json:"userId" gorm:"column:userId;index"`
+	CouponCode *string    `json:"couponCode"`
+	Items      []CartItem `json:"items" gorm:"foreignKey:CartID"`
 }
 
 // CartItem model
 type CartItem struct {
 	Base
-	CartID    uuid.UUID `json:"cartId" gorm:"column:cartId"`
-	Cart      Cart      `json:"-" gorm:"foreignKey:CartID"`
-	ProductID uuid.UUID `json:"productId" gorm:"column:productId"`
-	Product   Product   `json:"product,omitempty" gorm:"foreignKey:ProductID"`
-	Quantity  int       `json:"quantity"`
-	Variant   *string   `json:"variant"`
+	CartID    uuid.UUID       `json:"cartId" gorm:"column:cartId"`
+	Cart      Cart            `json:"-" gorm:"foreignKey:CartID"`
+	ProductID uuid.UUID       `json:"productId" gorm:"column:productId"`
+	Product   Product         `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Quantity  int             `json:"quantity"`
+	VariantID *string         `json:"variantId" gorm:"column:variantId"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
 }
 
-// Review model
+// Review model. A user may review each product once; reviews are only shown
+// once approved.
 type Review struct {
 	Base
-	UserID     uuid.UUID `json:"userId" gorm:"column:userId"`
-	User       User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	ProductID  uuid.UUID `json:"productId" gorm:"column:productId"`
-	Product    Product   `json:"-" gorm:"foreignKey:ProductID"`
-	Rating     int       `json:"rating"`
-	Title      *string   `json:"title"`
-	Content    string    `json:"content"`
-	IsVerified bool      `json:"isVerified" gorm:"default:false"`
+	UserID       uuid.UUID    `json:"userId" gorm:"column:userId;uniqueIndex:idx_reviews_user_product"`
+	User         User         `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	ProductID    uuid.UUID    `json:"productId" gorm:"column:productId;uniqueIndex:idx_reviews_user_product;index"`
+	Product      Product      `json:"-" gorm:"foreignKey:ProductID"`
+	Rating       int          `json:"rating"`
+	Title        *string      `json:"title"`
+	Content      string       `json:"content"`
+	IsVerified   bool         `json:"isVerified" gorm:"default:false"`
+	Status       ReviewStatus `json:"status" gorm:"default:PENDING;index"`
+	HelpfulCount int          `json:"helpfulCount" gorm:"default:0"`
+
+	// ModeratedAt, ModeratedBy and ModerationNote record the last moderation decision
+	ModeratedAt    *time.Time `json:"moderatedAt"`
+	ModeratedBy    *uuid.UUID `json:"moderatedBy" gorm:"column:moderatedBy"`
+	ModerationNote *string    `json:"moderationNote"`
+}
+
+// ReviewVote model records a user marking a review as helpful
+type ReviewVote struct {
+	Base
+	ReviewID uuid.UUID `json:"reviewId" gorm:"column:reviewId;uniqueIndex:idx_review_votes_review_user"`
+	Review   Review    `json:"-" gorm:"foreignKey:ReviewID"`
+	UserID   uuid.UUID `json:"userId" gorm:"column:userId;uniqueIndex:idx_review_votes_review_user"`
+	User     User      `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// RatingSummary aggregates the ratings of a product's approved reviews.
+// Histogram[i] counts the reviews rated i+1 stars.
+type RatingSummary struct {
+	Average   float64  `json:"average"`
+	Count     int64    `json:"count"`
+	Histogram [5]int64 `json:"histogram"`
 }
 
 // WishlistItem model
 type WishlistItem struct {
 	Base
-	UserID    uuid.UUID `json:"userId" gorm:"column:userId"`
+	UserID    uuid.UUID `json:"userId" gorm:"column:userId;uniqueIndex:idx_wishlist_user_product"`
 	User      User      `json:"-" gorm:"foreignKey:UserID"`
-	ProductID uuid.UUID `json:"productId" gorm:"column:productId"`
+	ProductID uuid.UUID `json:"productId" gorm:"column:productId;uniqueIndex:idx_wishlist_user_product;index"`
 	Product   Product   `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+
+	// SeenPrice and SeenInStock are the product's state when the alert job
+	// last looked, so it can tell when the product comes back or gets cheaper
+	SeenPrice   *int  `json:"-"`
+	SeenInStock *bool `json:"-"`
+}
+
+// NotificationKind enum
+type NotificationKind string
+
+const (
+	NotificationKindBackInStock NotificationKind = "BACK_IN_STOCK"
+	NotificationKindPriceDrop   NotificationKind = "PRICE_DROP"
+)
+
+// Notification model is an outbox of alerts about wishlisted products.
+// Rows are queued by the alert job and marked sent once delivered.
+type Notification struct {
+	Base
+	UserID        uuid.UUID        `json:"userId" gorm:"column:userId;index:idx_notifications_dedup"`
+	User          User             `json:"-" gorm:"foreignKey:UserID"`
+	ProductID     uuid.UUID        `json:"productId" gorm:"column:productId;index:idx_notifications_dedup"`
+	Product       Product          `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Kind          NotificationKind `json:"kind" gorm:"index:idx_notifications_dedup"`
+	Price         int              `json:"price"`
+	PreviousPrice *int             `json:"previousPrice"`
+	SentAt        *time.Time       `json:"sentAt" gorm:"index"`
+	Attempts      int              `json:"attempts" gorm:"default:0"`
+	LastError     *string          `json:"lastError"`
+
+	// ClaimedUntil reserves the notification for the server sending it
+	ClaimedUntil *time.Time `json:"-"`
 }
 
 // Address model
 type Address struct {
 	Base
-	UserID    uuid.UUID  `json:"userId" gorm:"column:userId"`
-	User      User       `json:"-" gorm:"foreignKey:UserID"`
-	Name      string     `json:"name"`
-	Street    string     `json:"street"`
-	City      string     `json:"city"`
-	State     string     `json:"state"`
-	Zip       string     `json:"zip"`
-	Country   string     `json:"country"`
-	Phone     string     `json:"phone"`
-	IsDefault bool       `json:"isDefault" gorm:"default:false"`
+	UserID    uuid.UUID   `json:"userId" gorm:"column:userId;index"`
+	User      User        `json:"-" gorm:"foreignKey:UserID"`
+	Name      string      `json:"name"`
+	Street    string      `json:"street"`
+	City      string      `json:"city"`
+	State     string      `json:"state"`
+	Zip       string      `json:"zip"`
+	Country   string      `json:"country"`
+	Phone     string      `json:"phone"`
+	IsDefault bool        `json:"isDefault" gorm:"default:false"`
 	Type      AddressType `json:"type" gorm:"default:SHIPPING"`
 }
 
@@ -247,16 +597,141 @@ type ProductAttribute struct {
 
 // ProductVariant model
 type ProductVariant struct {
-	ID           string    `gorm:"primaryKey;column:_id" json:"id"`
-	ProductID    uuid.UUID `json:"productId" gorm:"column:productId"`
-	Product      Product   `json:"-" gorm:"foreignKey:ProductID"`
-	Name         string    `json:"name"`
-	SKU          *string   `json:"sku" gorm:"uniqueIndex"`
-	Price        int       `json:"price"`
+	ID            string    `gorm:"primaryKey;column:_id" json:"id"`
+	ProductID     uuid.UUID `json:"productId" gorm:"column:productId"`
+	Product       Product   `json:"-" gorm:"foreignKey:ProductID"`
+	Name          string    `json:"name"`
+	SKU           *string   `json:"sku" gorm:"uniqueIndex"`
+	Price         int       `json:"price"`
 	StockQuantity int       `json:"stockQuantity" gorm:"default:0"`
-	Attributes   JSON      `json:"attributes" gorm:"type:jsonb"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	Weight        *int      `json:"weight"` // Shipping weight in grams; the product's when unset
+	Attributes    JSON      `json:"attributes" gorm:"type:jsonb"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+
+	// DeletedAt is set once the variant is removed from its product
+	DeletedAt gorm.DeletedAt `json:"deletedAt" gorm:"index"`
+}
+
+// Coupon model describes a promotion redeemed with a code. Unset limits and
+// dates do not apply; with no products or categories every item is eligible.
+type Coupon struct {
+	Base
+	Code        string     `json:"code" gorm:"uniqueIndex"`
+	Description *string    `json:"description"`
+	Type        CouponType `json:"type"`
+
+	// Value is the percentage or the KES amount off, depending on Type
+	Value       int  `json:"value"`
+	MaxDiscount *int `json:"maxDiscount"`
+	BuyQuantity int  `json:"buyQuantity"`
+	GetQuantity int  `json:"getQuantity"`
+
+	MinSpend          int        `json:"minSpend" gorm:"default:0"`
+	UsageLimit        *int       `json:"usageLimit"`
+	UsageLimitPerUser *int       `json:"usageLimitPerUser"`
+	UsedCount         int        `json:"usedCount" gorm:"default:0"`
+	StartsAt          *time.Time `json:"startsAt"`
+	EndsAt            *time.Time `json:"endsAt"`
+	Active            bool       `json:"active"`
+
+	// Relations
+	Products   []Product  `json:"products,omitempty" gorm:"many2many:coupon_products"`
+	Categories []Category `json:"categories,omitempty" gorm:"many2many:coupon_categories"`
+}
+
+// CouponRedemption model records a coupon used by an order
+type CouponRedemption struct {
+	Base
+	CouponID uuid.UUID `json:"couponId" gorm:"column:couponId;index:idx_coupon_redemptions_coupon_user"`
+	Coupon   Coupon    `json:"-" gorm:"foreignKey:CouponID"`
+	UserID   uuid.UUID `json:"userId" gorm:"column:userId;index:idx_coupon_redemptions_coupon_user"`
+	User     User      `json:"-" gorm:"foreignKey:UserID"`
+	OrderID  uuid.UUID `json:"orderId" gorm:"column:orderId;uniqueIndex"`
+	Order    Order     `json:"-" gorm:"foreignKey:OrderID"`
+	Amount   int       `json:"amount"`
+}
+
+// ShippingZone model groups destinations that share shipping methods. Zones
+// are matched in Position order, so a county zone goes before its country's.
+type ShippingZone struct {
+	Base
+	Name     string `json:"name"`
+	Position int    `json:"position" gorm:"default:0"`
+	Active   bool   `json:"active"`
+
+	// Relations
+	Regions []ShippingZoneRegion `json:"regions" gorm:"foreignKey:ZoneID"`
+	Methods []ShippingMethod     `json:"methods" gorm:"foreignKey:ZoneID"`
+}
+
+// ShippingZoneRegion model is a destination within a zone: a country,
+// optionally narrowed to a county and a postcode prefix
+type ShippingZoneRegion struct {
+	Base
+	ZoneID         uuid.UUID `json:"zoneId" gorm:"column:zoneId;index"`
+	Country        string    `json:"country"` // ISO 3166-1 alpha-2 code, e.g. KE
+	County         *string   `json:"county"`
+	PostcodePrefix *string   `json:"postcodePrefix"`
+}
+
+// ShippingMethodType enum
+type ShippingMethodType string
+
+const (
+	ShippingMethodStandard ShippingMethodType = "STANDARD"
+	ShippingMethodExpress  ShippingMethodType = "EXPRESS"
+	ShippingMethodPickup   ShippingMethodType = "PICKUP"
+)
+
+// IsValid reports whether t is a known shipping method type
+func (t ShippingMethodType) IsValid() bool {
+	switch t {
+	case ShippingMethodStandard, ShippingMethodExpress, ShippingMethodPickup:
+		return true
+	}
+	return false
+}
+
+// ShippingRateBasis is what a shipping method's tiers are measured in
+type ShippingRateBasis string
+
+const (
+	// ShippingBasisPrice tiers by the discounted order value in KES
+	ShippingBasisPrice ShippingRateBasis = "PRICE"
+	// ShippingBasisWeight tiers by the total weight in grams
+	ShippingBasisWeight ShippingRateBasis = "WEIGHT"
+)
+
+// IsValid reports whether b is a known rate basis
+func (b ShippingRateBasis) IsValid() bool {
+	return b == ShippingBasisPrice || b == ShippingBasisWeight
+}
+
+// ShippingMethod model is a way of delivering to a zone, priced by its tiers
+type ShippingMethod struct {
+	Base
+	ZoneID   uuid.UUID          `json:"zoneId" gorm:"column:zoneId;index"`
+	Name     string             `json:"name"`
+	Type     ShippingMethodType `json:"type"`
+	Basis    ShippingRateBasis  `json:"basis"`
+	MinDays  *int               `json:"minDays"`
+	MaxDays  *int               `json:"maxDays"`
+	Position int                `json:"position" gorm:"default:0"`
+	Active   bool               `json:"active"`
+
+	// Relations
+	Tiers []ShippingRateTier `json:"tiers" gorm:"foreignKey:MethodID"`
+}
+
+// ShippingRateTier model charges Rate when the order's value or weight is at
+// least Min and below Max. A nil Max has no upper bound.
+type ShippingRateTier struct {
+	Base
+	MethodID uuid.UUID `json:"methodId" gorm:"column:methodId;index"`
+	Min      int       `json:"min"`
+	Max      *int      `json:"max"`
+	Rate     int       `json:"rate"`
 }
 
 // Settings model
@@ -267,4 +742,3 @@ type Settings struct {
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
-
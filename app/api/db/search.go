@@ -0,0 +1,70 @@
+package db
+
+import "gorm.io/gorm"
+
+// searchMigrations set up full-text product search. A generated column cannot
+// read other tables, so products.search_vector is kept current by triggers:
+// products recompute their own vector on write, and changes to attributes or
+// category names touch the affected products to trigger a recompute.
+//
+// Every statement is idempotent so they run on each start-up.
+var searchMigrations = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+
+	`CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector :=
+			setweight(to_tsvector('english', coalesce(NEW.name, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(NEW.sku, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce((SELECT name FROM categories WHERE _id = NEW."categoryId"), '')), 'B') ||
+			setweight(to_tsvector('english', coalesce((SELECT string_agg(value, ' ') FROM product_attributes WHERE "productId" = NEW._id), '')), 'B') ||
+			setweight(to_tsvector('english', coalesce(NEW.description, '')), 'C');
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS products_search_vector ON products`,
+	`CREATE TRIGGER products_search_vector BEFORE INSERT OR UPDATE ON products
+		FOR EACH ROW EXECUTE FUNCTION products_search_vector_update()`,
+
+	`CREATE OR REPLACE FUNCTION product_attributes_search_touch() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP IN ('UPDATE', 'DELETE') THEN
+			UPDATE products SET search_vector = NULL WHERE _id = OLD."productId";
+		END IF;
+		IF TG_OP IN ('INSERT', 'UPDATE') THEN
+			UPDATE products SET search_vector = NULL WHERE _id = NEW."productId";
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS product_attributes_search_touch ON product_attributes`,
+	`CREATE TRIGGER product_attributes_search_touch AFTER INSERT OR UPDATE OR DELETE ON product_attributes
+		FOR EACH ROW EXECUTE FUNCTION product_attributes_search_touch()`,
+
+	`CREATE OR REPLACE FUNCTION categories_search_touch() RETURNS trigger AS $$
+	BEGIN
+		UPDATE products SET search_vector = NULL WHERE "categoryId" = NEW._id;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS categories_search_touch ON categories`,
+	`CREATE TRIGGER categories_search_touch AFTER UPDATE OF name ON categories
+		FOR EACH ROW EXECUTE FUNCTION categories_search_touch()`,
+
+	// Backfill rows written before the triggers existed
+	`UPDATE products SET search_vector = NULL WHERE search_vector IS NULL`,
+}
+
+// migrateSearch installs the search column, indexes and triggers
+func migrateSearch(tx *gorm.DB) error {
+	for _, statement := range searchMigrations {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
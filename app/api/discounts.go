@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/pricing"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// discountLine is an item a coupon is evaluated against
+type discountLine struct {
+	ProductID  uuid.UUID
+	CategoryID uuid.UUID
+	UnitPrice  int
+	Quantity   int
+	Weight     int // Grams per unit, for shipping
+}
+
+// discountResult is what a coupon takes off an order
+type discountResult struct {
+	Discount     int
+	FreeShipping bool
+
+	// Lines splits Discount across the order lines in proportion to the
+	// value of the eligible ones
+	Lines []int
+}
+
+// normalizeCouponCode makes codes case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// loadCoupon finds a coupon by code with its product and category scope,
+// locking it when it is about to be redeemed
+func loadCoupon(tx *gorm.DB, code string, lock bool) (*db.Coupon, error) {
+	query := tx.Preload("Products").Preload("Categories")
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var coupon db.Coupon
+	if err := query.Where("code = ?", normalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &apiError{http.StatusBadRequest, "Invalid coupon code"}
+		}
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// applyCoupon checks a coupon's constraints against the order lines and
+// computes its discount. It does not check the per-user limit, which needs
+// a signed-in user; see checkCouponUserLimit.
+func applyCoupon(tx *gorm.DB, coupon *db.Coupon, lines []discountLine, now time.Time) (discountResult, error) {
+	var result discountResult
+
+	switch {
+	case !coupon.Active:
+		return result, &apiError{http.StatusBadRequest, "This coupon is no longer active"}
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return result, &apiError{http.StatusBadRequest, "This coupon is not valid yet"}
+	case coupon.EndsAt != nil && !now.Before(*coupon.EndsAt):
+		return result, &apiError{http.StatusBadRequest, "This coupon has expired"}
+	case coupon.UsageLimit != nil && coupon.UsedCount >= *coupon.UsageLimit:
+		return result, &apiError{http.StatusBadRequest, "This coupon has reached its usage limit"}
+	}
+
+	var subtotal int
+	for _, line := range lines {
+		subtotal += line.UnitPrice * line.Quantity
+	}
+	if subtotal < coupon.MinSpend {
+		return result, &apiError{http.StatusBadRequest, fmt.Sprintf("This coupon requires a minimum spend of KES %d", coupon.MinSpend)}
+	}
+
+	inScope, err := eligibleLines(tx, coupon, lines)
+	if err != nil {
+		return result, err
+	}
+
+	var eligible []discountLine
+	var eligibleSubtotal int
+	weights := make([]int, len(lines))
+	for i, line := range lines {
+		if inScope[i] {
+			eligible = append(eligible, line)
+			weights[i] = line.UnitPrice * line.Quantity
+			eligibleSubtotal += weights[i]
+		}
+	}
+	if len(eligible) == 0 {
+		return result, &apiError{http.StatusBadRequest, "This coupon does not apply to any items in your cart"}
+	}
+
+	switch coupon.Type {
+	case db.CouponTypePercentage:
+		// Round half up to the nearest shilling
+		result.Discount = (eligibleSubtotal*coupon.Value + 50) / 100
+		if coupon.MaxDiscount != nil && result.Discount > *coupon.MaxDiscount {
+			result.Discount = *coupon.MaxDiscount
+		}
+
+	case db.CouponTypeFixedAmount:
+		result.Discount = coupon.Value
+
+	case db.CouponTypeFreeShipping:
+		result.FreeShipping = true
+
+	case db.CouponTypeBuyXGetY:
+		result.Discount = buyXGetYDiscount(eligible, coupon.BuyQuantity, coupon.GetQuantity)
+		if result.Discount == 0 {
+			return result, &apiError{http.StatusBadRequest,
+				fmt.Sprintf("Add %d eligible items to your cart to use this coupon", coupon.BuyQuantity+coupon.GetQuantity)}
+		}
+	}
+
+	if result.Discount > eligibleSubtotal {
+		result.Discount = eligibleSubtotal
+	}
+	result.Lines = pricing.Allocate(result.Discount, weights)
+	return result, nil
+}
+
+// eligibleLines reports which lines are in the coupon's scope. A category
+// covers its subcategories.
+func eligibleLines(tx *gorm.DB, coupon *db.Coupon, lines []discountLine) ([]bool, error) {
+	inScope := make([]bool, len(lines))
+	if len(coupon.Products) == 0 && len(coupon.Categories) == 0 {
+		for i := range inScope {
+			inScope[i] = true
+		}
+		return inScope, nil
+	}
+
+	products := make(map[uuid.UUID]bool, len(coupon.Products))
+	for _, product := range coupon.Products {
+		products[product.ID] = true
+	}
+	categories := make(map[uuid.UUID]bool, len(coupon.Categories))
+	for _, category := range coupon.Categories {
+		categories[category.ID] = true
+	}
+
+	var index categoryIndex
+	if len(categories) > 0 {
+		var err error
+		if index, err = loadCategoryIndex(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, line := range lines {
+		inScope[i] = products[line.ProductID]
+		if !inScope[i] && index != nil {
+			for _, crumb := range index.Breadcrumbs(line.CategoryID) {
+				if categories[crumb.ID] {
+					inScope[i] = true
+					break
+				}
+			}
+		}
+	}
+	return inScope, nil
+}
+
+// buyXGetYDiscount prices the free units: eligible units are ranked from most
+// to least expensive, and in every group of buy+get units the last get are free
+func buyXGetYDiscount(lines []discountLine, buy, get int) int {
+	if buy < 1 || get < 1 {
+		return 0
+	}
+
+	var prices []int
+	for _, line := range lines {
+		for i := 0; i < line.Quantity; i++ {
+			prices = append(prices, line.UnitPrice)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(prices)))
+
+	var discount int
+	group := buy + get
+	for i := 0; i+group <= len(prices); i += group {
+		for _, price := range prices[i+buy : i+group] {
+			discount += price
+		}
+	}
+	return discount
+}
+
+// checkCouponUserLimit rejects a coupon the user has already used as often as allowed
+func checkCouponUserLimit(tx *gorm.DB, coupon *db.Coupon, userID uuid.UUID) error {
+	if coupon.UsageLimitPerUser == nil {
+		return nil
+	}
+
+	var used int64
+	if err := tx.Model(&db.CouponRedemption{}).
+		Where("\"couponId\" = ? AND \"userId\" = ?", coupon.ID, userID).Count(&used).Error; err != nil {
+		return err
+	}
+	if used >= int64(*coupon.UsageLimitPerUser) {
+		return &apiError{http.StatusBadRequest, "You have already used this coupon"}
+	}
+	return nil
+}
+
+// redeemCoupon records the coupon against an order and counts the use
+func redeemCoupon(tx *gorm.DB, coupon *db.Coupon, order db.Order) error {
+	redemption := db.CouponRedemption{
+		CouponID: coupon.ID,
+		UserID:   *order.UserID,
+		OrderID:  order.ID,
+		Amount:   order.Discount,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return err
+	}
+	return tx.Model(coupon).UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error
+}
+
+// releaseCoupon gives back the coupon use of a cancelled order
+func releaseCoupon(tx *gorm.DB, orderID uuid.UUID) error {
+	var redemption db.CouponRedemption
+	if err := tx.Where("\"orderId\" = ?", orderID).First(&redemption).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return err
+	}
+	return tx.Model(&db.Coupon{}).Where("_id = ? AND used_count > 0", redemption.CouponID).
+		UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error
+}
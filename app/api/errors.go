@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"beauty-shop/lib"
+	"gorm.io/gorm"
+)
+
+// apiError is a failure that maps to a client-facing HTTP status
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// conflictOnDuplicate turns a unique index violation into a 409 with message;
+// other errors, and nil, pass through
+func conflictOnDuplicate(err error, message string) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return &apiError{http.StatusConflict, message}
+	}
+	return err
+}
+
+// respondWithAPIError writes an apiError with its own status, or fallback as a 500
+func respondWithAPIError(w http.ResponseWriter, err error, fallback string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		lib.RespondWithError(w, apiErr.Status, apiErr.Message)
+		return
+	}
+	lib.RespondWithError(w, http.StatusInternalServerError, fallback)
+}
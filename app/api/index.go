@@ -1,19 +1,15 @@
 package handler
 
 import (
-	"encoding/json"
 	"net/http"
 	"time"
 
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
 	"beauty-shop/lib"
 )
 
-// Handler handles HTTP requests for the admin dashboard
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
-
+// DashboardHandler handles HTTP requests for the admin dashboard
+func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -31,33 +27,6 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
-		return
-	}
-
-	// Extract token from request
-	tokenString, err := lib.ExtractTokenFromRequest(r)
-	if err != nil {
-		lib.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
-	}
-
-	// Validate token
-	claims, err := lib.ValidateJWT(tokenString)
-	if err != nil {
-		lib.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
-		return
-	}
-
-	// Check if user is admin
-	if claims.Role != "ADMIN" {
-		lib.RespondWithError(w, http.StatusForbidden, "Admin access required")
-		return
-	}
-
 	// Get dashboard stats
 	stats := getDashboardStats()
 
@@ -93,60 +62,41 @@ func getDashboardStats() map[string]interface{} {
 
 	// Calculate revenue
 	var totalRevenue int64
-	db.DB.Model(&db.Order{}).Where("status IN ?", []db.OrderStatus{db.OrderStatusDelivered, db.OrderStatusShipped}).Select("SUM(total)").Row().Scan(&totalRevenue)
+	salesScope(db.DB.Model(&db.Order{})).Select("COALESCE(SUM(total - refunded_amount), 0)").Row().Scan(&totalRevenue)
 
 	// Get low stock products
 	var lowStockProducts []db.Product
 	db.DB.Where("stock_quantity <= ? AND in_stock = ?", 10, true).Limit(5).Find(&lowStockProducts)
 
-	// Calculate growth metrics (in a real app, you would compare with previous month)
-	// For now, we'll use placeholder values
-	salesGrowth := 12.5
-	ordersGrowth := 8.2
-	customersGrowth := 5.7
-	productsGrowth := 3
-
-	// Get previous month's data for comparison
-	previousMonthStart := time.Now().AddDate(0, -1, 0).Format("2006-01-02")
-	currentMonthStart := time.Now().AddDate(0, 0, -time.Now().Day()+1).Format("2006-01-02")
-
-	// Previous month revenue
-	var previousMonthRevenue int64
-	db.DB.Model(&db.Order{}).
-		Where("status IN ? AND created_at >= ? AND created_at < ?", 
-			[]db.OrderStatus{db.OrderStatusDelivered, db.OrderStatusShipped},
-			previousMonthStart,
-			currentMonthStart).
-		Select("SUM(total)").Row().Scan(&previousMonthRevenue)
-
-	// Current month revenue
-	var currentMonthRevenue int64
-	db.DB.Model(&db.Order{}).
-		Where("status IN ? AND created_at >= ?", 
-			[]db.OrderStatus{db.OrderStatusDelivered, db.OrderStatusShipped},
-			currentMonthStart).
-		Select("SUM(total)").Row().Scan(&currentMonthRevenue)
-
-	// Calculate actual growth if we have data
-	if previousMonthRevenue > 0 {
-		salesGrowth = float64(currentMonthRevenue-previousMonthRevenue) / float64(previousMonthRevenue) * 100
-	}
+	// Compare this month so far with the same days of last month, in store time
+	currentMonth, previousMonth := monthToDate(time.Now())
+
+	current, _ := summarizeSales(db.DB, currentMonth)
+	previous, _ := summarizeSales(db.DB, previousMonth)
+
+	var currentMonthProducts, previousMonthProducts int64
+	db.DB.Model(&db.Product{}).Where("created_at >= ? AND created_at < ?", currentMonth.Start, currentMonth.End).Count(&currentMonthProducts)
+	db.DB.Model(&db.Product{}).Where("created_at >= ? AND created_at < ?", previousMonth.Start, previousMonth.End).Count(&previousMonthProducts)
+
+	// Growth is a percentage, or nil (JSON null) when last month's days had
+	// nothing to compare with
+	salesGrowth := percentChange(int64(previous.Revenue), int64(current.Revenue))
+	ordersGrowth := percentChange(previous.Orders, current.Orders)
+	customersGrowth := percentChange(previous.NewCustomers, current.NewCustomers)
+	productsGrowth := percentChange(previousMonthProducts, currentMonthProducts)
 
 	return map[string]interface{}{
-		"productCount":      productCount,
-		"categoryCount":     categoryCount,
-		"userCount":         userCount,
-		"orderCount":        orderCount,
+		"productCount":       productCount,
+		"categoryCount":      categoryCount,
+		"userCount":          userCount,
+		"orderCount":         orderCount,
 		"pendingOrdersCount": pendingOrdersCount,
-		"recentOrders":      recentOrders,
-		"totalRevenue":      totalRevenue,
-		"lowStockProducts":  lowStockProducts,
-		"salesGrowth":       salesGrowth,
-		"ordersGrowth":      ordersGrowth,
-		"customersGrowth":   customersGrowth,
-		"productsGrowth":    productsGrowth,
+		"recentOrders":       recentOrders,
+		"totalRevenue":       totalRevenue,
+		"lowStockProducts":   lowStockProducts,
+		"salesGrowth":        salesGrowth,
+		"ordersGrowth":       ordersGrowth,
+		"customersGrowth":    customersGrowth,
+		"productsGrowth":     productsGrowth,
 	}
 }
-
-
-
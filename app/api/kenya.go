@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"strings"
+	"unicode"
+
+	"beauty-shop/app/api/payments"
+)
+
+// kenyanCounties lists the 47 counties of Kenya
+var kenyanCounties = []string{
+	"Baringo", "Bomet", "Bungoma", "Busia", "Elgeyo-Marakwet", "Embu",
+	"Garissa", "Homa Bay", "Isiolo", "Kajiado", "Kakamega", "Kericho",
+	"Kiambu", "Kilifi", "Kirinyaga", "Kisii", "Kisumu", "Kitui",
+	"Kwale", "Laikipia", "Lamu", "Machakos", "Makueni", "Mandera",
+	"Marsabit", "Meru", "Migori", "Mombasa", "Murang'a", "Nairobi",
+	"Nakuru", "Nandi", "Narok", "Nyamira", "Nyandarua", "Nyeri",
+	"Samburu", "Siaya", "Taita-Taveta", "Tana River", "Tharaka-Nithi", "Trans Nzoia",
+	"Turkana", "Uasin Gishu", "Vihiga", "Wajir", "West Pokot",
+}
+
+// countiesByKey maps a county name reduced to lowercase letters to its
+// canonical spelling, so "taita taveta" and "Muranga" are accepted
+var countiesByKey = func() map[string]string {
+	byKey := make(map[string]string, len(kenyanCounties)+1)
+	for _, county := range kenyanCounties {
+		byKey[countyKey(county)] = county
+	}
+	byKey[countyKey("Nairobi City")] = "Nairobi"
+	return byKey
+}()
+
+// countyKey reduces a county name to lowercase letters
+func countyKey(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), " county")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// normalizeCounty returns the canonical name of a Kenyan county
+func normalizeCounty(name string) (string, bool) {
+	county, ok := countiesByKey[countyKey(name)]
+	return county, ok
+}
+
+// deliveryCountries lists the countries orders can be shipped to, by
+// ISO 3166-1 alpha-2 code
+var deliveryCountries = []struct{ Code, Name string }{
+	{"KE", "Kenya"}, {"UG", "Uganda"}, {"TZ", "Tanzania"},
+	{"RW", "Rwanda"}, {"BI", "Burundi"}, {"SS", "South Sudan"},
+}
+
+// normalizeCountry returns the ISO code and name of a delivery country given
+// either; an empty name means Kenya
+func normalizeCountry(name string) (code, canonical string, ok bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = "ke"
+	}
+	for _, country := range deliveryCountries {
+		if name == strings.ToLower(country.Code) || name == strings.ToLower(country.Name) {
+			return country.Code, country.Name, true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeKenyanPhone formats a Kenyan mobile number as +254XXXXXXXXX
+func normalizeKenyanPhone(phone string) (string, bool) {
+	msisdn, err := payments.NormalizeMsisdn(phone)
+	if err != nil {
+		return "", false
+	}
+	return "+" + msisdn, true
+}
+
+// normalizeInternationalPhone formats a number given with its country code,
+// as +XXX or 00XXX, in E.164 form
+func normalizeInternationalPhone(phone string) (string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(phone))
+
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits = digits[1:]
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	default:
+		return "", false
+	}
+
+	if len(digits) < 9 || len(digits) > 15 || digits[0] == '0' || strings.Trim(digits, "0123456789") != "" {
+		return "", false
+	}
+	return "+" + digits, true
+}
@@ -0,0 +1,175 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSession is what a fake SMTP server was told in one conversation
+type smtpSession struct {
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTP accepts one connection on a local port and speaks just enough
+// SMTP to take a message. With stall set it accepts and never answers.
+func fakeSMTP(t *testing.T, stall bool) (string, <-chan smtpSession) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if stall {
+			// Hold the connection until the test is over
+			conn.Read(make([]byte, 1))
+			return
+		}
+
+		var session smtpSession
+		r := bufio.NewReader(conn)
+		reply := func(line string) {
+			conn.Write([]byte(line + "\r\n"))
+		}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				session.From = command
+				reply("250 OK")
+			case "RCPT":
+				session.To = append(session.To, command)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				session.Data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				sessions <- session
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), sessions
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, sessions := fakeSMTP(t, false)
+	m := &SMTPMailer{Addr: addr, From: "Beauty Shop <no-reply@beautyshop.com>"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "wanjiru@example.com",
+		Subject: "Verify your email",
+		Body:    "Hello Wanjiru,\nConfirm your address.",
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	var session smtpSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server never saw QUIT")
+	}
+
+	if session.From != "MAIL FROM:<no-reply@beautyshop.com>" {
+		t.Errorf("envelope sender = %q, want the bare From address", session.From)
+	}
+	if len(session.To) != 1 || session.To[0] != "RCPT TO:<wanjiru@example.com>" {
+		t.Errorf("recipients = %q, want wanjiru@example.com only", session.To)
+	}
+
+	header, body, ok := strings.Cut(session.Data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header break: %q", session.Data)
+	}
+	for _, want := range []string{
+		"From: Beauty Shop <no-reply@beautyshop.com>",
+		"To: wanjiru@example.com",
+		"Subject: Verify your email",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	} {
+		if !strings.Contains(header+"\r\n", want+"\r\n") {
+			t.Errorf("headers lack %q:\n%s", want, header)
+		}
+	}
+	if !strings.Contains(header, "\r\nDate: ") {
+		t.Errorf("headers lack a Date:\n%s", header)
+	}
+	if body != "Hello Wanjiru,\r\nConfirm your address.\r\n" {
+		t.Errorf("body = %q, want CRLF line endings", body)
+	}
+}
+
+func TestSMTPMailerGivesUpOnAStalledServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{"mailer timeout", 100 * time.Millisecond, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}},
+		{"context deadline", time.Minute, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, _ := fakeSMTP(t, true)
+			m := &SMTPMailer{Addr: addr, From: "no-reply@beautyshop.com", Timeout: tt.timeout}
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			err := m.Send(ctx, Message{To: "wanjiru@example.com", Subject: "Hello", Body: "Hello"})
+			if err == nil {
+				t.Fatal("send to a stalled server succeeded")
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("send gave up after %s", elapsed)
+			}
+		})
+	}
+}
@@ -2,66 +2,63 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
-	"strings"
 
-	"github.com/golang-jwt/jwt/v4"
+	"beauty-shop/app/api/auth"
+	"beauty-shop/app/api/db"
 )
 
-// Claims struct for JWT
-type Claims struct {
-	UserID string `json:"userId"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
-	jwt.RegisteredClaims
-}
-
-// JWT secret key
-var jwtKey = []byte("your-secret-key") // In production, use an environment variable
-
 // AuthMiddleware validates JWT tokens and adds user info to request context
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+		// Let preflight requests through to the handler's CORS handling
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check if the header has the correct format
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
+		// Get the token from the Authorization header
+		tokenStr, err := auth.TokenFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		// Get the token
-		tokenStr := headerParts[1]
-
-		// Parse the token
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		// Parse and validate the token
+		claims, err := auth.ParseAccessToken(tokenStr)
+		if err != nil {
+			if !errors.Is(err, auth.ErrInvalidToken) {
+				log.Printf("Token verification unavailable: %v", err)
 			}
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
 
+		// Reject tokens whose session has been logged out or revoked
+		if claims.SessionID != "" {
+			var live int64
+			if err := db.DB.Model(&db.Session{}).
+				Where("\"familyId\" = ? AND revoked_at IS NULL", claims.SessionID).
+				Count(&live).Error; err != nil {
+				log.Printf("Session lookup failed: %v", err)
+				http.Error(w, "Failed to verify session", http.StatusInternalServerError)
+				return
+			}
+			if live == 0 {
+				http.Error(w, "Session has been revoked", http.StatusUnauthorized)
+				return
+			}
+		}
+
 		// Add user info to request context
 		ctx := context.WithValue(r.Context(), "userId", claims.UserID)
 		ctx = context.WithValue(ctx, "email", claims.Email)
 		ctx = context.WithValue(ctx, "role", claims.Role)
+		ctx = context.WithValue(ctx, "sessionId", claims.SessionID)
 
 		// Call the next handler with the updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
-
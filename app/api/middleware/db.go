@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
 	"net/http"
 	"sync"
 )
@@ -14,13 +14,17 @@ var (
 // InitDB initializes the database connection
 func InitDB(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		mu.Lock()
-		if !initialized {
-			db.Initialize()
-			initialized = true
-		}
-		mu.Unlock()
+		EnsureDB()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// EnsureDB initializes the database connection once, for code outside requests
+func EnsureDB() {
+	mu.Lock()
+	if !initialized {
+		db.Initialize()
+		initialized = true
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"beauty-shop/app/api/db"
+)
+
+// RequirePermission authenticates the request like AuthMiddleware and then
+// rejects callers whose role does not grant the permission
+func RequirePermission(permission db.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Let preflight requests through to the handler's CORS handling
+			if r.Method == "OPTIONS" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			role, _ := r.Context().Value("role").(string)
+			if !db.Role(role).HasPermission(permission) {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/mailer"
+)
+
+// Alert is one change to a wishlisted product
+type Alert struct {
+	Kind          db.NotificationKind `json:"kind"`
+	ProductID     string              `json:"productId"`
+	ProductName   string              `json:"productName"`
+	ProductURL    string              `json:"productUrl"`
+	Price         int                 `json:"price"`
+	PreviousPrice *int                `json:"previousPrice,omitempty"`
+}
+
+// Digest gathers the pending alerts of one user so they are sent together
+type Digest struct {
+	UserID string  `json:"userId"`
+	Email  string  `json:"email"`
+	Name   string  `json:"name,omitempty"`
+	Alerts []Alert `json:"alerts"`
+}
+
+// Notifier delivers alert digests to users
+type Notifier interface {
+	Notify(ctx context.Context, digest Digest) error
+}
+
+var (
+	defaultNotifier Notifier
+	defaultMu       sync.Mutex
+)
+
+// Default returns the process-wide notifier, configured from the environment on first use
+func Default() Notifier {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultNotifier == nil {
+		defaultNotifier = FromEnv()
+	}
+	return defaultNotifier
+}
+
+// SetDefault replaces the process-wide notifier
+func SetDefault(n Notifier) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultNotifier = n
+}
+
+// FromEnv returns a webhook notifier when NOTIFY_WEBHOOK_URL is set,
+// otherwise one that emails through the default mailer
+func FromEnv() Notifier {
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		return &WebhookNotifier{
+			URL:    url,
+			Secret: os.Getenv("NOTIFY_WEBHOOK_SECRET"),
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return &EmailNotifier{}
+}
+
+// EmailNotifier emails digests as plain text
+type EmailNotifier struct {
+	// Mailer sends the email; nil uses mailer.Default()
+	Mailer mailer.Mailer
+}
+
+// Notify emails the digest to the user
+func (n *EmailNotifier) Notify(ctx context.Context, digest Digest) error {
+	m := n.Mailer
+	if m == nil {
+		m = mailer.Default()
+	}
+
+	var body strings.Builder
+	greeting := "Hi"
+	if digest.Name != "" {
+		greeting += " " + digest.Name
+	}
+	body.WriteString(greeting + ",\n\nGood news about items on your Beauty Shop wishlist:\n\n")
+	for _, alert := range digest.Alerts {
+		switch alert.Kind {
+		case db.NotificationKindBackInStock:
+			fmt.Fprintf(&body, "- %s is back in stock at KES %d\n", alert.ProductName, alert.Price)
+		case db.NotificationKindPriceDrop:
+			if alert.PreviousPrice != nil {
+				fmt.Fprintf(&body, "- %s dropped from KES %d to KES %d\n", alert.ProductName, *alert.PreviousPrice, alert.Price)
+			} else {
+				fmt.Fprintf(&body, "- %s is now KES %d\n", alert.ProductName, alert.Price)
+			}
+		}
+		fmt.Fprintf(&body, "  %s\n", alert.ProductURL)
+	}
+	body.WriteString("\nYou are receiving this because these products are on your wishlist.")
+
+	subject := "An item on your wishlist has changed"
+	if len(digest.Alerts) > 1 {
+		subject = fmt.Sprintf("%d items on your wishlist have changed", len(digest.Alerts))
+	}
+
+	return m.Send(ctx, mailer.Message{To: digest.Email, Subject: subject, Body: body.String()})
+}
+
+// WebhookNotifier posts digests as JSON to a URL. With a secret set, the
+// X-Signature header carries the body's HMAC-SHA256 as "sha256=<hex>".
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// Notify posts the digest to the webhook
+func (n *WebhookNotifier) Notify(ctx context.Context, digest Digest) error {
+	payload, err := json.Marshal(digest)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notify: webhook returned %s", resp.Status)
+	}
+	return nil
+}
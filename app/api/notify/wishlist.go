@@ -0,0 +1,315 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultInterval is how often the wishlist alert job runs
+	DefaultInterval = 10 * time.Minute
+
+	// DefaultCooldown is how long a user waits before being alerted about
+	// the same change to the same product again
+	DefaultCooldown = 24 * time.Hour
+
+	// maxAttempts is how many times a notification is tried before it is given up
+	maxAttempts = 5
+
+	// dispatchBatchSize caps the notifications sent per run
+	dispatchBatchSize = 200
+
+	// dispatchLease is how long a server holds the notifications it claimed
+	// for sending. Failed digests, and those of a server that stopped
+	// mid-send, become claimable again when it runs out.
+	dispatchLease = 5 * time.Minute
+
+	// wishlistScanLock is the advisory lock key that keeps change detection
+	// to one server at a time
+	wishlistScanLock = 4302
+)
+
+// WishlistAlerts detects wishlisted products that came back in stock or got
+// cheaper, queues a notification per user and product, and sends them
+type WishlistAlerts struct {
+	DB       *gorm.DB
+	Notifier Notifier
+
+	// Cooldown suppresses repeat alerts of the same kind for a product
+	Cooldown time.Duration
+
+	// AppURL is the storefront base URL used in product links
+	AppURL string
+}
+
+// NewWishlistAlerts returns the job with the default notifier and settings
+func NewWishlistAlerts(tx *gorm.DB) *WishlistAlerts {
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if appURL == "" {
+		appURL = "http://localhost:3000"
+	}
+	return &WishlistAlerts{
+		DB:       tx,
+		Notifier: Default(),
+		Cooldown: DefaultCooldown,
+		AppURL:   appURL,
+	}
+}
+
+// Run runs the job every interval until ctx is cancelled
+func (j *WishlistAlerts) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunOnce(ctx); err != nil {
+			log.Printf("Wishlist alerts failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce queues alerts for changes since the last run and sends pending ones
+func (j *WishlistAlerts) RunOnce(ctx context.Context) error {
+	if err := j.Detect(ctx); err != nil {
+		return err
+	}
+	return j.Dispatch(ctx)
+}
+
+// Detect compares each wishlisted product with the state last seen for that
+// wishlist entry and queues notifications for restocks and price drops
+func (j *WishlistAlerts) Detect(ctx context.Context) error {
+	return j.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", wishlistScanLock).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			// Another server is already scanning
+			return nil
+		}
+
+		// Archived products are skipped until they are restored
+		var items []db.WishlistItem
+		if err := tx.Joins("Product").Where(`"Product"._id IS NOT NULL AND "Product".deleted_at IS NULL`).
+			Find(&items).Error; err != nil {
+			return err
+		}
+
+		since := time.Now().Add(-j.Cooldown)
+		for _, item := range items {
+			product := item.Product
+
+			// Entries seen for the first time only record the current state
+			if item.SeenPrice != nil && item.SeenInStock != nil {
+				if !*item.SeenInStock && product.InStock {
+					if err := j.enqueue(tx, item, db.NotificationKindBackInStock, nil, since); err != nil {
+						return err
+					}
+				}
+				if product.InStock && product.Price < *item.SeenPrice {
+					if err := j.enqueue(tx, item, db.NotificationKindPriceDrop, item.SeenPrice, since); err != nil {
+						return err
+					}
+				}
+			}
+
+			if item.SeenPrice != nil && *item.SeenPrice == product.Price &&
+				item.SeenInStock != nil && *item.SeenInStock == product.InStock {
+				continue
+			}
+			if err := tx.Model(&item).UpdateColumns(map[string]interface{}{
+				"seen_price":    product.Price,
+				"seen_in_stock": product.InStock,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// enqueue queues a notification unless the user was alerted about the same
+// kind of change to the product within the cooldown
+func (j *WishlistAlerts) enqueue(tx *gorm.DB, item db.WishlistItem, kind db.NotificationKind, previousPrice *int, since time.Time) error {
+	var recent int64
+	if err := tx.Model(&db.Notification{}).
+		Where("\"userId\" = ? AND \"productId\" = ? AND kind = ? AND created_at > ?", item.UserID, item.ProductID, kind, since).
+		Count(&recent).Error; err != nil {
+		return err
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	return tx.Create(&db.Notification{
+		UserID:        item.UserID,
+		ProductID:     item.ProductID,
+		Kind:          kind,
+		Price:         item.Product.Price,
+		PreviousPrice: previousPrice,
+	}).Error
+}
+
+// Dispatch sends pending notifications, one digest per user. Each user's
+// notifications are claimed in a short transaction and sent outside it, so
+// no rows stay locked while email or webhooks are slow; rows claimed by
+// another server are skipped.
+func (j *WishlistAlerts) Dispatch(ctx context.Context) error {
+	for sent := 0; sent < dispatchBatchSize; {
+		pending, err := j.claim(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if err := j.send(ctx, pending); err != nil {
+			return err
+		}
+		sent += len(pending)
+	}
+	return nil
+}
+
+// claim leases the pending notifications of the next user with any, and
+// loads their recipients
+func (j *WishlistAlerts) claim(ctx context.Context) ([]db.Notification, error) {
+	var pending []db.Notification
+	err := j.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		claimable := "sent_at IS NULL AND attempts < ? AND (claimed_until IS NULL OR claimed_until < ?)"
+
+		var next []db.Notification
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(claimable, maxAttempts, now).Order("created_at").Limit(1).
+			Find(&next).Error; err != nil {
+			return err
+		}
+		if len(next) == 0 {
+			return nil
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(claimable, maxAttempts, now).Where("\"userId\" = ?", next[0].UserID).
+			Order("created_at").Limit(dispatchBatchSize).
+			Find(&pending).Error; err != nil {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(pending))
+		for i, n := range pending {
+			ids[i] = n.ID
+		}
+		if err := tx.Model(&db.Notification{}).Where("_id IN ?", ids).
+			Update("claimed_until", now.Add(dispatchLease)).Error; err != nil {
+			return err
+		}
+
+		return j.loadRecipients(tx, pending)
+	})
+	return pending, err
+}
+
+// loadRecipients fills in the user and product of each notification,
+// including archived products
+func (j *WishlistAlerts) loadRecipients(tx *gorm.DB, pending []db.Notification) error {
+	userIDs := make([]uuid.UUID, 0, len(pending))
+	productIDs := make([]uuid.UUID, 0, len(pending))
+	for _, n := range pending {
+		userIDs = append(userIDs, n.UserID)
+		productIDs = append(productIDs, n.ProductID)
+	}
+
+	var users []db.User
+	if err := tx.Where("_id IN ?", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+	var products []db.Product
+	if err := tx.Unscoped().Where("_id IN ?", productIDs).Find(&products).Error; err != nil {
+		return err
+	}
+
+	usersByID := make(map[uuid.UUID]db.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	productsByID := make(map[uuid.UUID]db.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	for i := range pending {
+		pending[i].User = usersByID[pending[i].UserID]
+		pending[i].Product = productsByID[pending[i].ProductID]
+	}
+	return nil
+}
+
+// send delivers one user's claimed notifications as a digest and records the
+// outcome. Alerts about products that are gone again or left the wishlist are
+// dropped. Failed notifications keep their lease, so they are retried on a
+// later run rather than straight away.
+func (j *WishlistAlerts) send(ctx context.Context, notifications []db.Notification) error {
+	tx := j.DB.WithContext(ctx)
+
+	user := notifications[0].User
+	digest := Digest{UserID: user.ID.String(), Email: user.Email}
+	if user.Name != nil {
+		digest.Name = *user.Name
+	}
+
+	ids := make([]uuid.UUID, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+
+		var wishlisted int64
+		if err := tx.Model(&db.WishlistItem{}).
+			Where("\"userId\" = ? AND \"productId\" = ?", n.UserID, n.ProductID).Count(&wishlisted).Error; err != nil {
+			return err
+		}
+		if wishlisted == 0 || n.Product.DeletedAt.Valid || !n.Product.InStock {
+			continue
+		}
+
+		digest.Alerts = append(digest.Alerts, Alert{
+			Kind:          n.Kind,
+			ProductID:     n.ProductID.String(),
+			ProductName:   n.Product.Name,
+			ProductURL:    j.AppURL + "/products/" + n.Product.Slug,
+			Price:         n.Price,
+			PreviousPrice: n.PreviousPrice,
+		})
+	}
+
+	var sendErr error
+	if len(digest.Alerts) > 0 && user.Email != "" {
+		sendErr = j.Notifier.Notify(ctx, digest)
+	}
+
+	updates := map[string]interface{}{"attempts": gorm.Expr("attempts + 1")}
+	if sendErr != nil {
+		log.Printf("Failed to send wishlist alerts to user %s: %v", user.ID, sendErr)
+		updates["last_error"] = sendErr.Error()
+	} else {
+		updates["sent_at"] = time.Now()
+		updates["last_error"] = nil
+		updates["claimed_until"] = nil
+	}
+	return tx.Model(&db.Notification{}).Where("_id IN ?", ids).Updates(updates).Error
+}
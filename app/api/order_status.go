@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpdateOrderStatusRequest represents the request to change an order's status
+type UpdateOrderStatusRequest struct {
+	Status         db.OrderStatus `json:"status"`
+	Note           *string        `json:"note,omitempty"`
+	TrackingNumber *string        `json:"trackingNumber,omitempty"`
+}
+
+// OrderStatusHandler handles admin requests to change an order's status
+func OrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only allow PATCH requests
+	if r.Method != "PATCH" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get user info set by the auth middleware
+	userIDStr, _, _, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	adminID, err := uuid.FromString(userIDStr)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	orderID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+
+	// Parse request body
+	var req UpdateOrderStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !req.Status.IsValid() {
+		lib.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid order status: %s", req.Status))
+		return
+	}
+
+	// Apply the transition
+	if err := changeOrderStatus(orderID, adminID, req); err != nil {
+		respondWithAPIError(w, err, "Failed to update order status")
+		return
+	}
+
+	// Return the updated order with its history
+	var order db.Order
+	if err := loadOrderDetail(db.DB).First(&order, "_id = ?", orderID).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch order")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, order)
+}
+
+// changeOrderStatus moves an order to a new status, recording the change,
+// restocking items when the order is cancelled and settling cash on delivery
+// when it is delivered
+func changeOrderStatus(orderID, changedBy uuid.UUID, req UpdateOrderStatusRequest) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the order so concurrent updates see a consistent status
+		var order db.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, "_id = ?", orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Order not found"}
+			}
+			return err
+		}
+
+		if !order.Status.CanTransitionTo(req.Status) {
+			return &apiError{http.StatusConflict, fmt.Sprintf("Cannot change order status from %s to %s", order.Status, req.Status)}
+		}
+
+		// Put cancelled items back on the shelf and give back the coupon use
+		if req.Status == db.OrderStatusCancelled {
+			if err := restockItems(tx, order.Items); err != nil {
+				return err
+			}
+			if err := releaseCoupon(tx, order.ID); err != nil {
+				return err
+			}
+		}
+
+		// Reviews written before delivery become verified purchases
+		if req.Status == db.OrderStatusDelivered && order.UserID != nil {
+			if err := verifyDeliveredReviews(tx, *order.UserID, order.Items); err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{"status": req.Status}
+		note := req.Note
+
+		// Cash on delivery is collected when the order is handed over
+		if req.Status == db.OrderStatusDelivered && order.PaymentMethod == payments.ProviderCashOnDelivery &&
+			order.PaymentStatus == db.PaymentStatusPending {
+			if err := collectCashPayment(tx, order); err != nil {
+				return err
+			}
+			updates["payment_status"] = db.PaymentStatusPaid
+
+			collected := "Cash payment collected on delivery"
+			if note != nil && *note != "" {
+				collected = *note + "\n" + collected
+			}
+			note = &collected
+		}
+
+		if req.TrackingNumber != nil {
+			updates["tracking_number"] = *req.TrackingNumber
+		}
+		if err := tx.Model(&order).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		history := db.OrderStatusHistory{
+			OrderID:        order.ID,
+			FromStatus:     order.Status,
+			ToStatus:       req.Status,
+			ChangedByID:    &changedBy,
+			Note:           note,
+			TrackingNumber: req.TrackingNumber,
+		}
+		return tx.Create(&history).Error
+	})
+}
+
+// restockItems returns order items to product or variant stock, leaving out
+// units that completed refunds have already put back
+func restockItems(tx *gorm.DB, items []db.OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	itemIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ID
+	}
+
+	var restocked []struct {
+		OrderItemID uuid.UUID
+		Quantity    int
+	}
+	if err := tx.Table("refund_items ri").
+		Joins("JOIN refunds r ON r._id = ri.\"refundId\"").
+		Select("ri.\"orderItemId\" AS order_item_id, SUM(ri.quantity) AS quantity").
+		Where("ri.\"orderItemId\" IN ? AND r.restocked = ? AND r.status = ?", itemIDs, true, db.PaymentStatusRefunded).
+		Group("ri.\"orderItemId\"").Scan(&restocked).Error; err != nil {
+		return err
+	}
+	alreadyRestocked := make(map[uuid.UUID]int, len(restocked))
+	for _, line := range restocked {
+		alreadyRestocked[line.OrderItemID] = line.Quantity
+	}
+
+	for _, item := range items {
+		quantity := item.Quantity - alreadyRestocked[item.ID]
+		if quantity <= 0 {
+			continue
+		}
+		if err := restockItem(tx, item, quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restockItem returns quantity units of an order item to product or variant
+// stock, including archived ones so the count is right if they are restored
+func restockItem(tx *gorm.DB, item db.OrderItem, quantity int) error {
+	if item.VariantID != nil {
+		return tx.Unscoped().Model(&db.ProductVariant{}).Where("_id = ?", *item.VariantID).
+			Update("stock_quantity", gorm.Expr("stock_quantity + ?", quantity)).Error
+	}
+
+	return tx.Unscoped().Model(&db.Product{}).Where("_id = ?", item.ProductID).Updates(map[string]interface{}{
+		"stock_quantity": gorm.Expr("stock_quantity + ?", quantity),
+		"in_stock":       true,
+	}).Error
+}
+
+// loadOrderDetail preloads an order's items and status history timeline.
+// Items resolve their products even after those have been archived.
+func loadOrderDetail(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Items").
+		Preload("Items.Product", func(tx *gorm.DB) *gorm.DB {
+			return tx.Unscoped()
+		}).
+		Preload("User").Preload("Payments").Preload("Refunds.Items").
+		Preload("History", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("created_at ASC")
+		}).
+		Preload("History.ChangedBy")
+}
@@ -2,15 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"sort"
 	"time"
 
-	"beauty-shop/api/db"
-	"beauty-shop/api/middleware"
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/app/api/pricing"
+	"beauty-shop/lib"
 	"github.com/gofrs/uuid"
-	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateOrderRequest represents the request to create a new order
@@ -18,18 +22,32 @@ type CreateOrderRequest struct {
 	Items []struct {
 		ProductID string `json:"productId"`
 		Quantity  int    `json:"quantity"`
-		Variant   string `json:"variant,omitempty"`
+		VariantID string `json:"variantId,omitempty"`
 	} `json:"items"`
-	ShippingAddress db.JSON `json:"shippingAddress"`
-	BillingAddress  db.JSON `json:"billingAddress,omitempty"`
-	PaymentMethod   string  `json:"paymentMethod"`
+
+	// Each address is either a saved address ID or an inline address
+	AddressID        string          `json:"addressId,omitempty"`
+	ShippingAddress  *AddressRequest `json:"shippingAddress,omitempty"`
+	BillingAddressID string          `json:"billingAddressId,omitempty"`
+	BillingAddress   *AddressRequest `json:"billingAddress,omitempty"`
+
+	// ShippingMethodID picks a method from GET /shipping/quote; the cheapest
+	// one is used when it is left out
+	ShippingMethodID string `json:"shippingMethodId,omitempty"`
+
+	PaymentMethod string `json:"paymentMethod"`
+	PaymentPhone  string `json:"paymentPhone,omitempty"`
+	CouponCode    string `json:"couponCode,omitempty"`
 }
 
-// Handler handles HTTP requests for orders
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
+// orderAddresses are the validated address snapshots copied onto an order
+type orderAddresses struct {
+	Shipping db.JSON
+	Billing  *db.JSON
+}
 
+// OrdersHandler handles HTTP requests for orders
+func OrdersHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
@@ -44,39 +62,15 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
-	// Check for authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
+	// Get user ID set by the auth middleware
+	userIDStr, _, _, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
 		http.Error(w, "Authorization required", http.StatusUnauthorized)
 		return
 	}
 
-	// Extract user ID from context (would be set by auth middleware in a real app)
-	// For now, we'll parse it manually
-	userIDStr := r.Context().Value("userId")
-	if userIDStr == nil {
-		// Apply auth middleware manually for this handler
-		var claims middleware.Claims
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			http.Error(w, "Invalid Authorization header format", http.StatusUnauthorized)
-			return
-		}
-		
-		token, err := jwt.ParseWithClaims(tokenParts[1], &claims, func(token *jwt.Token) (interface{}, error) {
-			return middleware.JwtKey, nil
-		})
-		
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
-		}
-		
-		userIDStr = claims.UserID
-	}
-
 	// Parse user ID
-	userID, err := uuid.FromString(userIDStr.(string))
+	userID, err := uuid.FromString(userIDStr)
 	if err != nil {
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
@@ -84,7 +78,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Find the user
 	var user db.User
-	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := db.DB.First(&user, "_id = ?", userID).Error; err != nil {
 		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
@@ -92,15 +86,43 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Handle different HTTP methods
 	switch r.Method {
 	case "GET":
-		// Get all orders for the user
+		// Get the user's orders, newest first, one page at a time
+		limit, after, err := lib.ParseCursorParams(r, "newest")
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		query := db.DB.Preload("Items").Where(&db.Order{UserID: &userID})
+		if condition, args := lib.KeysetCondition("created_at", "_id", true, after); condition != "" {
+			query = query.Where(condition, args...)
+		}
+
 		var orders []db.Order
-		if err := db.DB.Preload("Items").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
+		if err := query.Order("created_at DESC, _id DESC").Limit(limit + 1).Find(&orders).Error; err != nil {
+			http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+			return
+		}
+
+		fetched := len(orders)
+		var next lib.Cursor
+		if fetched > limit {
+			orders = orders[:limit]
+			last := orders[limit-1]
+			next = lib.Cursor{Sort: "newest", Time: &last.CreatedAt, ID: last.ID.String()}
+		}
+
+		pagination, err := lib.NewCursorPagination(limit, fetched, next)
+		if err != nil {
 			http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 			return
 		}
 
 		// Return orders
-		json.NewEncoder(w).Encode(orders)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"orders":     orders,
+			"pagination": pagination,
+		})
 
 	case "POST":
 		// Create a new order
@@ -116,8 +138,15 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if orderReq.ShippingAddress == nil {
-			http.Error(w, "Shipping address is required", http.StatusBadRequest)
+		// Validate the addresses and snapshot them for the order
+		addresses, err := resolveOrderAddresses(userID, orderReq)
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				http.Error(w, apiErr.Message, apiErr.Status)
+				return
+			}
+			http.Error(w, "Failed to fetch address", http.StatusInternalServerError)
 			return
 		}
 
@@ -126,143 +155,528 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Generate order number
-		orderNumber := fmt.Sprintf("ORD-%s", time.Now().Format("20060102-150405"))
+		provider, err := payments.Get(orderReq.PaymentMethod)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unsupported payment method: %s", orderReq.PaymentMethod), http.StatusBadRequest)
+			return
+		}
 
-		// Calculate subtotal, tax, and shipping
-		var subtotal int
-		var orderItems []db.OrderItem
+		// M-Pesa prompts go to the payment phone, defaulting to the shipping phone
+		paymentPhone := orderReq.PaymentPhone
+		if paymentPhone == "" {
+			paymentPhone, _ = addresses.Shipping["phone"].(string)
+		}
+		if provider.Name() == payments.ProviderMpesa {
+			if _, err := payments.NormalizeMsisdn(paymentPhone); err != nil {
+				http.Error(w, "A valid M-Pesa phone number is required", http.StatusBadRequest)
+				return
+			}
+		}
 
-		// Get store settings for tax and shipping
-		var settings db.Settings
-		if err := db.DB.Where("key = ?", "store").First(&settings).Error; err != nil {
+		// Get the store's tax and shipping rules
+		settings, err := loadPricing()
+		if err != nil {
 			http.Error(w, "Failed to fetch store settings", http.StatusInternalServerError)
 			return
 		}
 
-		// Default values
-		taxRate := 16 // 16% VAT
-		shippingRate := 500
-		freeShippingThreshold := 5000
-
-		// Extract values from settings
-		if settings.Value != nil {
-			if tax, ok := settings.Value["tax"].(map[string]interface{}); ok {
-				if rate, ok := tax["rate"].(float64); ok {
-					taxRate = int(rate)
-				}
+		// Reserve stock and create the order in a single transaction
+		order, err := placeOrder(userID, orderReq, addresses, settings)
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				http.Error(w, apiErr.Message, apiErr.Status)
+				return
 			}
+			http.Error(w, "Failed to create order", http.StatusInternalServerError)
+			return
+		}
 
-			if shipping, ok := settings.Value["shipping"].(map[string]interface{}); ok {
-				if rate, ok := shipping["standardShippingRate"].(float64); ok {
-					shippingRate = int(rate)
-				}
-				if threshold, ok := shipping["freeShippingThreshold"].(float64); ok {
-					freeShippingThreshold = int(threshold)
-				}
-			}
+		// Start collecting payment
+		initiatePayment(r.Context(), provider, order, paymentPhone)
+
+		// Return the new order
+		var createdOrder db.Order
+		if err := db.DB.Preload("Items").Preload("Payments").First(&createdOrder, "_id = ?", order.ID).Error; err != nil {
+			http.Error(w, "Failed to fetch created order", http.StatusInternalServerError)
+			return
 		}
 
-		// Process order items
-		for _, item := range orderReq.Items {
-			productID, err := uuid.FromString(item.ProductID)
-			if err != nil {
-				http.Error(w, "Invalid product ID", http.StatusBadRequest)
-				return
-			}
+		json.NewEncoder(w).Encode(createdOrder)
 
-			// Get product
-			var product db.Product
-			if err := db.DB.First(&product, "id = ?", productID).Error; err != nil {
-				http.Error(w, fmt.Sprintf("Product not found: %s", item.ProductID), http.StatusBadRequest)
-				return
-			}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
 
-			// Check stock
-			if !product.InStock || product.StockQuantity < item.Quantity {
-				http.Error(w, fmt.Sprintf("Product %s is out of stock or has insufficient quantity", product.Name), http.StatusBadRequest)
-				return
-			}
+// placeOrder reserves stock and creates the order and its items in one transaction.
+// Product and variant rows are locked with SELECT ... FOR UPDATE so concurrent
+// checkouts cannot oversell, and any failure rolls back every stock change.
+func placeOrder(userID uuid.UUID, orderReq CreateOrderRequest, addresses orderAddresses, settings pricing.Settings) (*db.Order, error) {
+	// Parse and validate items before touching the database
+	quantities, err := parseOrderItems(orderReq)
+	if err != nil {
+		return nil, err
+	}
 
-			// Add to subtotal
-			itemTotal := product.Price * item.Quantity
-			subtotal += itemTotal
+	var order db.Order
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock and check every product and variant before writing anything
+		products, variants, err := loadOrderStock(tx, quantities, true)
+		if err != nil {
+			return err
+		}
 
-			// Create order item
-			orderItem := db.OrderItem{
-				ProductID: productID,
-				Name:      product.Name,
-				Price:     product.Price,
-				Quantity:  item.Quantity,
-			}
+		destination, err := destinationFromAddress(addresses.Shipping)
+		if err != nil {
+			return err
+		}
 
-			if item.Variant != "" {
-				orderItem.Variant = &item.Variant
-			}
+		priced, err := priceOrder(tx, userID, orderReq, products, variants, settings, &destination, true)
+		if err != nil {
+			return err
+		}
 
-			orderItems = append(orderItems, orderItem)
+		// Decrement stock on the locked rows
+		for _, productID := range quantities.productIDs {
+			quantity := quantities.products[productID]
+			if quantity == 0 {
+				continue
+			}
 
-			// Update product stock
-			product.StockQuantity -= item.Quantity
-			if product.StockQuantity == 0 {
-				product.InStock = false
+			remaining := products[productID].StockQuantity - quantity
+			if err := tx.Model(&db.Product{}).Where("_id = ?", productID).Updates(map[string]interface{}{
+				"stock_quantity": remaining,
+				"in_stock":       remaining > 0,
+			}).Error; err != nil {
+				return err
 			}
-			db.DB.Save(&product)
 		}
 
-		// Calculate tax and shipping
-		tax := (subtotal * taxRate) / 100
-		shipping := shippingRate
-		if subtotal >= freeShippingThreshold {
-			shipping = 0
+		for _, variantID := range quantities.variantIDs {
+			remaining := variants[variantID].StockQuantity - quantities.variants[variantID]
+			if err := tx.Model(&db.ProductVariant{}).Where("_id = ?", variantID).
+				Update("stock_quantity", remaining).Error; err != nil {
+				return err
+			}
 		}
 
-		// Calculate total
-		total := subtotal + tax + shipping
-
-		// Create new order
-		order := db.Order{
+		order = db.Order{
 			UserID:          &userID,
-			OrderNumber:     orderNumber,
+			OrderNumber:     lib.GenerateOrderNumber(),
 			Status:          db.OrderStatusPending,
-			Subtotal:        subtotal,
-			Tax:             tax,
-			Shipping:        shipping,
-			Total:           total,
-			ShippingAddress: orderReq.ShippingAddress,
-			BillingAddress:  orderReq.BillingAddress,
+			Subtotal:        priced.Quote.Subtotal,
+			Discount:        priced.Quote.Discount,
+			Tax:             priced.Quote.Tax,
+			TaxInclusive:    priced.Quote.TaxMode == pricing.TaxInclusive,
+			Shipping:        priced.Quote.Shipping,
+			Total:           priced.Quote.Total,
+			ShippingAddress: addresses.Shipping,
+			BillingAddress:  addresses.Billing,
 			PaymentMethod:   orderReq.PaymentMethod,
 			PaymentStatus:   db.PaymentStatusPending,
+			Items:           priced.Items,
 		}
 
-		// Save order to database
-		if err := db.DB.Create(&order).Error; err != nil {
-			http.Error(w, "Failed to create order", http.StatusInternalServerError)
-			return
+		if priced.Coupon != nil {
+			order.CouponCode = &priced.Coupon.Code
+		}
+		if priced.Shipping != nil {
+			details := priced.Shipping.details()
+			order.ShippingMethodID = priced.Shipping.MethodID
+			order.ShippingDetails = &details
 		}
 
-		// Save order items
-		for i := range orderItems {
-			orderItems[i].OrderID = order.ID
-			if err := db.DB.Create(&orderItems[i]).Error; err != nil {
-				http.Error(w, "Failed to create order item", http.StatusInternalServerError)
-				return
+		// Save the order together with its items
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+
+		if priced.Coupon != nil {
+			return redeemCoupon(tx, priced.Coupon, order)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// orderQuantities groups an order request's items by what they draw stock
+// from, with IDs sorted so rows are always locked in the same order
+type orderQuantities struct {
+	products        map[uuid.UUID]int
+	variants        map[string]int
+	variantProducts map[string]uuid.UUID
+	productIDs      []uuid.UUID
+	variantIDs      []string
+}
+
+// parseOrderItems validates the items of an order request
+func parseOrderItems(orderReq CreateOrderRequest) (orderQuantities, error) {
+	quantities := orderQuantities{
+		products:        make(map[uuid.UUID]int),
+		variants:        make(map[string]int),
+		variantProducts: make(map[string]uuid.UUID),
+		productIDs:      make([]uuid.UUID, 0, len(orderReq.Items)),
+		variantIDs:      make([]string, 0),
+	}
+
+	for _, item := range orderReq.Items {
+		productID, err := uuid.FromString(item.ProductID)
+		if err != nil {
+			return quantities, &apiError{http.StatusBadRequest, "Invalid product ID"}
+		}
+		if item.Quantity <= 0 {
+			return quantities, &apiError{http.StatusBadRequest, "Item quantity must be positive"}
+		}
+
+		if _, seen := quantities.products[productID]; !seen {
+			quantities.productIDs = append(quantities.productIDs, productID)
+			quantities.products[productID] = 0
+		}
+
+		// Variant lines draw on the variant's stock, others on the product's
+		if item.VariantID == "" {
+			quantities.products[productID] += item.Quantity
+			continue
+		}
+
+		if owner, seen := quantities.variantProducts[item.VariantID]; seen && owner != productID {
+			return quantities, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s does not belong to product %s", item.VariantID, productID)}
+		}
+		if _, seen := quantities.variants[item.VariantID]; !seen {
+			quantities.variantIDs = append(quantities.variantIDs, item.VariantID)
+		}
+		quantities.variantProducts[item.VariantID] = productID
+		quantities.variants[item.VariantID] += item.Quantity
+	}
+
+	// Lock rows in a consistent order to avoid deadlocks between checkouts
+	sort.Slice(quantities.productIDs, func(i, j int) bool {
+		return quantities.productIDs[i].String() < quantities.productIDs[j].String()
+	})
+	sort.Strings(quantities.variantIDs)
+
+	return quantities, nil
+}
+
+// loadOrderStock loads the ordered products and variants and checks their
+// stock, locking the rows when the order is about to be placed
+func loadOrderStock(tx *gorm.DB, quantities orderQuantities, lock bool) (map[uuid.UUID]db.Product, map[string]db.ProductVariant, error) {
+	query := func() *gorm.DB {
+		if lock {
+			return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		return tx
+	}
+
+	products := make(map[uuid.UUID]db.Product, len(quantities.productIDs))
+	for _, productID := range quantities.productIDs {
+		var product db.Product
+		if err := query().First(&product, "_id = ?", productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Product not found: %s", productID)}
 			}
+			return nil, nil, err
 		}
 
-		// Return the new order
-		var createdOrder db.Order
-		if err := db.DB.Preload("Items").First(&createdOrder, "id = ?", order.ID).Error; err != nil {
-			http.Error(w, "Failed to fetch created order", http.StatusInternalServerError)
-			return
+		if quantity := quantities.products[productID]; quantity > 0 && (!product.InStock || product.StockQuantity < quantity) {
+			return nil, nil, &apiError{http.StatusConflict, fmt.Sprintf("Product %s is out of stock or has insufficient quantity", product.Name)}
 		}
 
-		json.NewEncoder(w).Encode(createdOrder)
+		products[productID] = product
+	}
 
-	default:
+	// Reject variants of other products
+	variants := make(map[string]db.ProductVariant, len(quantities.variantIDs))
+	for _, variantID := range quantities.variantIDs {
+		var variant db.ProductVariant
+		if err := query().First(&variant, "_id = ?", variantID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant not found: %s", variantID)}
+			}
+			return nil, nil, err
+		}
+
+		if variant.ProductID != quantities.variantProducts[variantID] {
+			return nil, nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s does not belong to product %s", variantID, quantities.variantProducts[variantID])}
+		}
+
+		if variant.StockQuantity < quantities.variants[variantID] {
+			product := products[variant.ProductID]
+			return nil, nil, &apiError{http.StatusConflict, fmt.Sprintf("Product %s (%s) is out of stock or has insufficient quantity", product.Name, variant.Name)}
+		}
+
+		variants[variantID] = variant
+	}
+
+	return products, variants, nil
+}
+
+// pricedOrder is an order request priced against the catalog
+type pricedOrder struct {
+	Items    []db.OrderItem
+	Quote    pricing.Quote
+	Coupon   *db.Coupon
+	Shipping *ShippingOption
+}
+
+// priceOrder builds the order items, charging the variant price where one is
+// chosen, and prices them with the requested coupon and shipping method. The
+// coupon is locked when the order is about to be placed so usage limits hold
+// under concurrent checkouts. Without a destination shipping is the flat-rate
+// estimate.
+func priceOrder(tx *gorm.DB, userID uuid.UUID, orderReq CreateOrderRequest, products map[uuid.UUID]db.Product,
+	variants map[string]db.ProductVariant, settings pricing.Settings, destination *shippingDestination, lock bool) (pricedOrder, error) {
+	var priced pricedOrder
+
+	var lines []discountLine
+	for _, item := range orderReq.Items {
+		productID := uuid.FromStringOrNil(item.ProductID)
+		product := products[productID]
+
+		orderItem := db.OrderItem{
+			ProductID: productID,
+			Name:      product.Name,
+			Price:     product.Price,
+			Quantity:  item.Quantity,
+		}
+
+		var chosen *db.ProductVariant
+		if item.VariantID != "" {
+			variant := variants[item.VariantID]
+			orderItem.VariantID = &variant.ID
+			orderItem.Variant = &variant.Name
+			orderItem.Price = variant.Price
+			chosen = &variant
+		}
+
+		priced.Items = append(priced.Items, orderItem)
+		lines = append(lines, discountLine{
+			ProductID:  productID,
+			CategoryID: product.CategoryID,
+			UnitPrice:  orderItem.Price,
+			Quantity:   orderItem.Quantity,
+			Weight:     unitWeight(product, chosen),
+		})
+	}
+
+	var discount discountResult
+	if orderReq.CouponCode != "" {
+		coupon, err := loadCoupon(tx, orderReq.CouponCode, lock)
+		if err != nil {
+			return priced, err
+		}
+		if err := checkCouponUserLimit(tx, coupon, userID); err != nil {
+			return priced, err
+		}
+		if discount, err = applyCoupon(tx, coupon, lines, time.Now()); err != nil {
+			return priced, err
+		}
+		priced.Coupon = coupon
+	}
+
+	var shipping *int
+	if destination != nil {
+		options, err := shippingOptions(tx, *destination, newShippingParcel(lines, discount), settings)
+		if err != nil {
+			return priced, err
+		}
+		option, err := chooseShippingOption(options, orderReq.ShippingMethodID)
+		if err != nil {
+			return priced, err
+		}
+		priced.Shipping = &option
+		shipping = &option.Rate
+	}
+
+	priced.Quote = settings.Quote(pricing.Input{
+		Lines:        quoteLines(lines, discount),
+		Shipping:     shipping,
+		FreeShipping: discount.FreeShipping,
+	})
+	for i, line := range priced.Quote.Lines {
+		priced.Items[i].Discount = line.Discount
+		priced.Items[i].Tax = line.Tax
+		priced.Items[i].Total = line.Total
+	}
+
+	return priced, nil
+}
+
+// OrderPreview is the priced breakdown of an order request
+type OrderPreview struct {
+	Items      []db.OrderItem  `json:"items"`
+	Subtotal   int             `json:"subtotal"`
+	Discount   int             `json:"discount"`
+	Tax        int             `json:"tax"`
+	Shipping   int             `json:"shipping"`
+	Total      int             `json:"total"`
+	TaxRate    float64         `json:"taxRate"`
+	TaxMode    pricing.TaxMode `json:"taxMode"`
+	CouponCode *string         `json:"couponCode"`
+
+	// ShippingMethod is nil until a shipping address is given
+	ShippingMethod *ShippingOption `json:"shippingMethod"`
+}
+
+// OrderPreviewHandler prices an order request the way checkout would,
+// without reserving stock or redeeming the coupon. Addresses are optional;
+// shipping is quoted for the shipping address when one is given.
+func OrderPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only allow POST requests
+	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
+
+	var orderReq CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&orderReq); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(orderReq.Items) == 0 {
+		http.Error(w, "Order must contain at least one item", http.StatusBadRequest)
+		return
 	}
+
+	settings, err := loadPricing()
+	if err != nil {
+		http.Error(w, "Failed to fetch store settings", http.StatusInternalServerError)
+		return
+	}
+
+	var priced pricedOrder
+	quantities, err := parseOrderItems(orderReq)
+	if err == nil {
+		var products map[uuid.UUID]db.Product
+		var variants map[string]db.ProductVariant
+		var destination *shippingDestination
+		if products, variants, err = loadOrderStock(db.DB, quantities, false); err == nil {
+			destination, err = previewDestination(userID, orderReq)
+		}
+		if err == nil {
+			priced, err = priceOrder(db.DB, userID, orderReq, products, variants, settings, destination, false)
+		}
+	}
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			http.Error(w, apiErr.Message, apiErr.Status)
+			return
+		}
+		http.Error(w, "Failed to price order", http.StatusInternalServerError)
+		return
+	}
+
+	preview := OrderPreview{
+		Items:    priced.Items,
+		Subtotal: priced.Quote.Subtotal,
+		Discount: priced.Quote.Discount,
+		Tax:      priced.Quote.Tax,
+		Shipping: priced.Quote.Shipping,
+		Total:    priced.Quote.Total,
+		TaxRate:  priced.Quote.TaxRate,
+		TaxMode:  priced.Quote.TaxMode,
+	}
+	if priced.Coupon != nil {
+		preview.CouponCode = &priced.Coupon.Code
+	}
+	preview.ShippingMethod = priced.Shipping
+
+	json.NewEncoder(w).Encode(preview)
 }
 
+// previewDestination returns where a previewed order would ship, or nil when
+// the request has no shipping address yet
+func previewDestination(userID uuid.UUID, orderReq CreateOrderRequest) (*shippingDestination, error) {
+	address, err := resolveAddress(userID, orderReq.AddressID, orderReq.ShippingAddress, "shipping")
+	if err != nil || address == nil {
+		return nil, err
+	}
+	destination, err := destinationFromAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return &destination, nil
+}
+
+// OrderHandler handles HTTP requests for a single order
+func OrderHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only allow GET requests
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	// Get user info set by the auth middleware
+	userIDStr, _, role, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Authorization required", http.StatusUnauthorized)
+		return
+	}
 
+	// Parse order ID from the URL path
+	orderID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	// Staff who can read orders see any order, customers only their own
+	query := loadOrderDetail(db.DB)
+	if !db.Role(role).HasPermission(db.PermissionOrdersRead) {
+		userID, err := uuid.FromString(userIDStr)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		query = query.Where(&db.Order{UserID: &userID})
+	}
+
+	var order db.Order
+	if err := query.First(&order, "_id = ?", orderID).Error; err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return the order
+	json.NewEncoder(w).Encode(order)
+}
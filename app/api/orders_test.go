@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/app/api/pricing"
+)
+
+func TestPlaceOrderDoesNotOversell(t *testing.T) {
+	openTestDB(t)
+
+	const stock, buyers = 3, 12
+
+	tests := []struct {
+		name    string
+		variant bool
+	}{
+		{"product", false},
+		{"variant", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, tt.name+"@example.com")
+			category := createTestCategory(t, "Oversell "+tt.name)
+
+			product := db.Product{Name: "Last serum " + tt.name, Price: 1000, StockQuantity: stock}
+			if tt.variant {
+				product.StockQuantity = 100
+			}
+			product = createTestProduct(t, category, product)
+
+			var variant db.ProductVariant
+			if tt.variant {
+				variant = db.ProductVariant{ID: "variant-" + product.ID.String(), ProductID: product.ID, Name: "30ml", Price: 1000, StockQuantity: stock}
+				if err := db.DB.Create(&variant).Error; err != nil {
+					t.Fatalf("create variant: %v", err)
+				}
+			}
+
+			var req CreateOrderRequest
+			req.Items = append(req.Items, struct {
+				ProductID string `json:"productId"`
+				Quantity  int    `json:"quantity"`
+				VariantID string `json:"variantId,omitempty"`
+			}{ProductID: product.ID.String(), Quantity: 1, VariantID: variant.ID})
+			req.PaymentMethod = payments.ProviderCashOnDelivery
+
+			addresses := orderAddresses{Shipping: testShippingAddress}
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				placed   int
+				soldOut  int
+				failures []error
+			)
+			start := make(chan struct{})
+			for i := 0; i < buyers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, err := placeOrder(user.ID, req, addresses, pricing.Defaults)
+
+					mu.Lock()
+					defer mu.Unlock()
+					var apiErr *apiError
+					switch {
+					case err == nil:
+						placed++
+					case errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict:
+						soldOut++
+					default:
+						failures = append(failures, err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			for _, err := range failures {
+				t.Errorf("placeOrder: %v", err)
+			}
+			if placed != stock {
+				t.Errorf("placed %d orders, want %d", placed, stock)
+			}
+			if soldOut != buyers-stock {
+				t.Errorf("%d orders were refused as out of stock, want %d", soldOut, buyers-stock)
+			}
+
+			remaining := remainingStock(t, product, variant)
+			if remaining != 0 {
+				t.Errorf("stock left = %d, want 0", remaining)
+			}
+
+			var sold int64
+			if err := db.DB.Model(&db.OrderItem{}).Where("\"productId\" = ?", product.ID).
+				Select("COALESCE(SUM(quantity), 0)").Scan(&sold).Error; err != nil {
+				t.Fatalf("count sold units: %v", err)
+			}
+			if sold != stock {
+				t.Errorf("sold %d units, want %d", sold, stock)
+			}
+		})
+	}
+}
+
+// remainingStock reloads the stock an order line draws on
+func remainingStock(t *testing.T, product db.Product, variant db.ProductVariant) int {
+	t.Helper()
+
+	if variant.ID != "" {
+		if err := db.DB.First(&variant, "_id = ?", variant.ID).Error; err != nil {
+			t.Fatalf("reload variant: %v", err)
+		}
+		return variant.StockQuantity
+	}
+
+	if err := db.DB.First(&product, "_id = ?", product.ID).Error; err != nil {
+		t.Fatalf("reload product: %v", err)
+	}
+	if product.InStock {
+		t.Errorf("product %s is still marked in stock", product.Name)
+	}
+	return product.StockQuantity
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/lib"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentCallbackHandler handles asynchronous payment results from providers
+func PaymentCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	provider, err := payments.Get(r.PathValue("provider"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusNotFound, "Unknown payment provider")
+		return
+	}
+
+	// Authenticate and parse the callback
+	result, err := provider.VerifyCallback(r)
+	if err != nil {
+		if errors.Is(err, payments.ErrCallbackNotSupported) {
+			lib.RespondWithError(w, http.StatusNotFound, "Payment provider does not accept callbacks")
+			return
+		}
+		log.Printf("Rejected %s payment callback: %v", provider.Name(), err)
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid callback")
+		return
+	}
+
+	if err := applyPaymentResult(provider.Name(), result); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			lib.RespondWithError(w, http.StatusNotFound, "Payment not found")
+			return
+		}
+		log.Printf("Failed to apply %s payment callback %s: %v", provider.Name(), result.Reference, err)
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to process callback")
+		return
+	}
+
+	// Acknowledge in the format Daraja expects; other providers ignore the body
+	lib.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	})
+}
+
+// initiatePayment asks the order's payment provider to start collecting payment
+// and records the attempt. Failures are recorded on the payment and order
+// rather than returned, since the order itself has already been placed.
+func initiatePayment(ctx context.Context, provider payments.Provider, order *db.Order, phone string) {
+	payment := db.Payment{
+		OrderID:  order.ID,
+		Provider: provider.Name(),
+		Amount:   order.Total,
+		Status:   db.PaymentStatusPending,
+	}
+
+	result, err := provider.Initiate(ctx, payments.InitiateRequest{
+		OrderID:     order.ID.String(),
+		OrderNumber: order.OrderNumber,
+		Amount:      order.Total,
+		Currency:    "KES",
+		Phone:       phone,
+	})
+	if err != nil {
+		log.Printf("Failed to initiate %s payment for order %s: %v", provider.Name(), order.OrderNumber, err)
+		description := err.Error()
+		payment.Reference = "FAILED-" + order.OrderNumber + "-" + lib.GenerateRandomString(6)
+		payment.Status = db.PaymentStatusFailed
+		payment.Description = &description
+	} else {
+		payment.Reference = result.Reference
+		payment.Status = result.Status
+		if result.Message != "" {
+			payment.Description = &result.Message
+		}
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		return tx.Model(order).Update("payment_status", payment.Status).Error
+	})
+	if err != nil {
+		log.Printf("Failed to record payment for order %s: %v", order.OrderNumber, err)
+		return
+	}
+
+	order.PaymentStatus = payment.Status
+}
+
+// applyPaymentResult moves a pending payment and its order to PAID or FAILED.
+// Callbacks for payments that are no longer pending are ignored, so providers
+// retrying a callback cannot change the outcome twice.
+func applyPaymentResult(providerName string, result *payments.CallbackResult) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var payment db.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&db.Payment{Provider: providerName, Reference: result.Reference}).
+			First(&payment).Error; err != nil {
+			return err
+		}
+
+		// Already settled: acknowledge without changing anything
+		if payment.Status != db.PaymentStatusPending {
+			return nil
+		}
+
+		// A success must report the amount paid, and it must be the order total
+		status := result.Status
+		description := result.Description
+		if status == db.PaymentStatusPaid {
+			switch {
+			case result.Amount <= 0:
+				status = db.PaymentStatusFailed
+				description = "Payment result did not report the amount paid"
+			case result.Amount != payment.Amount:
+				status = db.PaymentStatusFailed
+				description = "Paid amount does not match order total"
+			}
+		}
+
+		updates := map[string]interface{}{"status": status}
+		if result.TransactionID != "" {
+			updates["transaction_id"] = result.TransactionID
+		}
+		if description != "" {
+			updates["description"] = description
+		}
+		if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&db.Order{}).Where("_id = ?", payment.OrderID).
+			Update("payment_status", status).Error
+	})
+}
+
+// collectCashPayment marks an order's pending cash-on-delivery payment as
+// paid, recording one if none was saved when the order was placed
+func collectCashPayment(tx *gorm.DB, order db.Order) error {
+	description := "Cash collected on delivery"
+	result := tx.Model(&db.Payment{}).
+		Where(&db.Payment{OrderID: order.ID, Provider: payments.ProviderCashOnDelivery, Status: db.PaymentStatusPending}).
+		Updates(map[string]interface{}{"status": db.PaymentStatusPaid, "description": description})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	return tx.Create(&db.Payment{
+		OrderID:     order.ID,
+		Provider:    payments.ProviderCashOnDelivery,
+		Reference:   "COD-" + order.OrderNumber,
+		Amount:      order.Total,
+		Status:      db.PaymentStatusPaid,
+		Description: &description,
+	}).Error
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+)
+
+// mpesaCallback builds a Daraja STK callback; amount < 0 leaves it out
+func mpesaCallback(reference string, resultCode int, amount int) string {
+	items := `{"Name":"MpesaReceiptNumber","Value":"NLJ7RT61SV"}`
+	if amount >= 0 {
+		items += fmt.Sprintf(`,{"Name":"Amount","Value":%d}`, amount)
+	}
+	metadata := ""
+	if resultCode == 0 {
+		metadata = `,"CallbackMetadata":{"Item":[` + items + `]}`
+	}
+	return fmt.Sprintf(`{"Body":{"stkCallback":{"MerchantRequestID":"29115-34620561-1","CheckoutRequestID":%q,"ResultCode":%d,"ResultDesc":"Processed"%s}}}`,
+		reference, resultCode, metadata)
+}
+
+// stkResult is the outcome an STK callback reports
+type stkResult struct {
+	code, amount int
+}
+
+func TestPaymentCallback(t *testing.T) {
+	openTestDB(t)
+	payments.Register(payments.NewMpesa(payments.MpesaConfig{
+		CallbackURL:   "https://shop.example.com/payments/mpesa/callback",
+		CallbackToken: "s3cret",
+	}, nil))
+
+	user := createTestUser(t, "callbacks@example.com")
+
+	tests := []struct {
+		name       string
+		token      string
+		callbacks  []stkResult
+		wantCode   int
+		wantStatus db.PaymentStatus
+	}{
+		{
+			name:       "paid in full",
+			token:      "s3cret",
+			callbacks:  []stkResult{{0, 1500}},
+			wantCode:   http.StatusOK,
+			wantStatus: db.PaymentStatusPaid,
+		},
+		{
+			name:       "replayed after success",
+			token:      "s3cret",
+			callbacks:  []stkResult{{0, 1500}, {1032, 0}, {0, 1}},
+			wantCode:   http.StatusOK,
+			wantStatus: db.PaymentStatusPaid,
+		},
+		{
+			name:       "amount mismatch",
+			token:      "s3cret",
+			callbacks:  []stkResult{{0, 1}},
+			wantCode:   http.StatusOK,
+			wantStatus: db.PaymentStatusFailed,
+		},
+		{
+			name:       "missing amount",
+			token:      "s3cret",
+			callbacks:  []stkResult{{0, -1}},
+			wantCode:   http.StatusOK,
+			wantStatus: db.PaymentStatusFailed,
+		},
+		{
+			name:       "wrong token",
+			token:      "guess",
+			callbacks:  []stkResult{{0, 1500}},
+			wantCode:   http.StatusBadRequest,
+			wantStatus: db.PaymentStatusPending,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := db.Order{
+				UserID:        &user.ID,
+				OrderNumber:   fmt.Sprintf("ORD-CB-%d", i),
+				Total:         1500,
+				PaymentMethod: payments.ProviderMpesa,
+				PaymentStatus: db.PaymentStatusPending,
+			}
+			if err := db.DB.Create(&order).Error; err != nil {
+				t.Fatalf("create order: %v", err)
+			}
+			payment := db.Payment{
+				OrderID:   order.ID,
+				Provider:  payments.ProviderMpesa,
+				Reference: "ws_CO_" + order.OrderNumber,
+				Amount:    order.Total,
+				Status:    db.PaymentStatusPending,
+			}
+			if err := db.DB.Create(&payment).Error; err != nil {
+				t.Fatalf("create payment: %v", err)
+			}
+
+			for _, callback := range tt.callbacks {
+				body := mpesaCallback(payment.Reference, callback.code, callback.amount)
+				req := httptest.NewRequest(http.MethodPost, "/payments/mpesa/callback?token="+tt.token, strings.NewReader(body))
+				req.SetPathValue("provider", payments.ProviderMpesa)
+				rec := httptest.NewRecorder()
+
+				PaymentCallbackHandler(rec, req)
+
+				if rec.Code != tt.wantCode {
+					t.Fatalf("status code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+				}
+			}
+
+			if err := db.DB.First(&payment, "_id = ?", payment.ID).Error; err != nil {
+				t.Fatalf("reload payment: %v", err)
+			}
+			if err := db.DB.First(&order, "_id = ?", order.ID).Error; err != nil {
+				t.Fatalf("reload order: %v", err)
+			}
+			if payment.Status != tt.wantStatus || order.PaymentStatus != tt.wantStatus {
+				t.Errorf("payment %s, order %s, want %s", payment.Status, order.PaymentStatus, tt.wantStatus)
+			}
+		})
+	}
+}
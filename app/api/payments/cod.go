@@ -0,0 +1,50 @@
+package payments
+
+import (
+	"context"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+)
+
+// CashOnDelivery is a provider for orders paid in cash when they are delivered
+type CashOnDelivery struct{}
+
+// NewCashOnDelivery creates a cash-on-delivery provider
+func NewCashOnDelivery() *CashOnDelivery {
+	return &CashOnDelivery{}
+}
+
+// Name returns the payment method name
+func (c *CashOnDelivery) Name() string {
+	return ProviderCashOnDelivery
+}
+
+// Initiate records that payment will be collected on delivery
+func (c *CashOnDelivery) Initiate(ctx context.Context, req InitiateRequest) (*InitiateResult, error) {
+	return &InitiateResult{
+		Reference: "COD-" + req.OrderNumber,
+		Status:    db.PaymentStatusPending,
+		Message:   "Payment will be collected on delivery",
+	}, nil
+}
+
+// VerifyCallback is not supported; cash payments are settled when staff mark
+// the order DELIVERED
+func (c *CashOnDelivery) VerifyCallback(r *http.Request) (*CallbackResult, error) {
+	return nil, ErrCallbackNotSupported
+}
+
+// Refund records a cash refund handed back to the customer
+func (c *CashOnDelivery) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	return &RefundResult{
+		Reference: req.Reference,
+		Status:    db.PaymentStatusRefunded,
+		Message:   "Refund to be paid out in cash",
+	}, nil
+}
+
+// VerifyRefundCallback is not supported; cash refunds are settled when issued
+func (c *CashOnDelivery) VerifyRefundCallback(r *http.Request) (*RefundCallbackResult, error) {
+	return nil, ErrCallbackNotSupported
+}
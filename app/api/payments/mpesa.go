@@ -0,0 +1,483 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"beauty-shop/app/api/db"
+)
+
+// MpesaSandboxURL is the base URL of the Daraja sandbox
+const MpesaSandboxURL = "https://sandbox.safaricom.co.ke"
+
+// MpesaConfig holds the Daraja credentials and endpoints
+type MpesaConfig struct {
+	BaseURL        string
+	ConsumerKey    string
+	ConsumerSecret string
+	ShortCode      string
+	PassKey        string
+	CallbackURL    string
+
+	// CallbackToken is appended to the callback URL and checked on every callback,
+	// since Daraja does not sign its requests. It is required.
+	CallbackToken string
+
+	// Reversal (refund) settings. Daraja reports the outcome of a reversal
+	// to ResultURL, or to TimeoutURL (ResultURL when empty) if it expires in
+	// the queue; both get the callback token and the refund ID added.
+	InitiatorName      string
+	SecurityCredential string
+	ResultURL          string
+	TimeoutURL         string
+}
+
+// MpesaConfigFromEnv reads the M-Pesa configuration from environment variables
+func MpesaConfigFromEnv() MpesaConfig {
+	baseURL := os.Getenv("MPESA_BASE_URL")
+	if baseURL == "" {
+		baseURL = MpesaSandboxURL
+	}
+
+	return MpesaConfig{
+		BaseURL:            baseURL,
+		ConsumerKey:        os.Getenv("MPESA_CONSUMER_KEY"),
+		ConsumerSecret:     os.Getenv("MPESA_CONSUMER_SECRET"),
+		ShortCode:          os.Getenv("MPESA_SHORTCODE"),
+		PassKey:            os.Getenv("MPESA_PASSKEY"),
+		CallbackURL:        os.Getenv("MPESA_CALLBACK_URL"),
+		CallbackToken:      os.Getenv("MPESA_CALLBACK_TOKEN"),
+		InitiatorName:      os.Getenv("MPESA_INITIATOR_NAME"),
+		SecurityCredential: os.Getenv("MPESA_SECURITY_CREDENTIAL"),
+		ResultURL:          os.Getenv("MPESA_RESULT_URL"),
+		TimeoutURL:         os.Getenv("MPESA_TIMEOUT_URL"),
+	}
+}
+
+// Mpesa is a provider for Safaricom M-Pesa payments via Daraja STK Push
+type Mpesa struct {
+	config MpesaConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewMpesa creates an M-Pesa provider. A nil client uses a default with a timeout.
+func NewMpesa(config MpesaConfig, client *http.Client) *Mpesa {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	return &Mpesa{config: config, client: client}
+}
+
+// Name returns the payment method name
+func (m *Mpesa) Name() string {
+	return ProviderMpesa
+}
+
+// stkPushRequest is the Daraja STK Push request body
+type stkPushRequest struct {
+	BusinessShortCode string `json:"BusinessShortCode"`
+	Password          string `json:"Password"`
+	Timestamp         string `json:"Timestamp"`
+	TransactionType   string `json:"TransactionType"`
+	Amount            int    `json:"Amount"`
+	PartyA            string `json:"PartyA"`
+	PartyB            string `json:"PartyB"`
+	PhoneNumber       string `json:"PhoneNumber"`
+	CallBackURL       string `json:"CallBackURL"`
+	AccountReference  string `json:"AccountReference"`
+	TransactionDesc   string `json:"TransactionDesc"`
+}
+
+// stkPushResponse is the Daraja STK Push response body
+type stkPushResponse struct {
+	MerchantRequestID   string `json:"MerchantRequestID"`
+	CheckoutRequestID   string `json:"CheckoutRequestID"`
+	ResponseCode        string `json:"ResponseCode"`
+	ResponseDescription string `json:"ResponseDescription"`
+	CustomerMessage     string `json:"CustomerMessage"`
+	ErrorCode           string `json:"errorCode"`
+	ErrorMessage        string `json:"errorMessage"`
+}
+
+// Initiate sends an STK Push prompt to the customer's phone
+func (m *Mpesa) Initiate(ctx context.Context, req InitiateRequest) (*InitiateResult, error) {
+	phone, err := NormalizeMsisdn(req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("mpesa: amount must be positive")
+	}
+
+	timestamp := time.Now().In(nairobi).Format("20060102150405")
+	password := base64.StdEncoding.EncodeToString([]byte(m.config.ShortCode + m.config.PassKey + timestamp))
+
+	body := stkPushRequest{
+		BusinessShortCode: m.config.ShortCode,
+		Password:          password,
+		Timestamp:         timestamp,
+		TransactionType:   "CustomerPayBillOnline",
+		Amount:            req.Amount,
+		PartyA:            phone,
+		PartyB:            m.config.ShortCode,
+		PhoneNumber:       phone,
+		CallBackURL:       m.callbackURL(),
+		AccountReference:  req.OrderNumber,
+		TransactionDesc:   "Payment for order " + req.OrderNumber,
+	}
+
+	var resp stkPushResponse
+	if err := m.post(ctx, "/mpesa/stkpush/v1/processrequest", body, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.ResponseCode != "0" {
+		return nil, fmt.Errorf("mpesa: stk push rejected: %s%s", resp.ResponseDescription, resp.ErrorMessage)
+	}
+
+	return &InitiateResult{
+		Reference: resp.CheckoutRequestID,
+		Status:    db.PaymentStatusPending,
+		Message:   resp.CustomerMessage,
+	}, nil
+}
+
+// stkCallback is the body Daraja posts to the callback URL
+type stkCallback struct {
+	Body struct {
+		StkCallback struct {
+			MerchantRequestID string `json:"MerchantRequestID"`
+			CheckoutRequestID string `json:"CheckoutRequestID"`
+			ResultCode        int    `json:"ResultCode"`
+			ResultDesc        string `json:"ResultDesc"`
+			CallbackMetadata  struct {
+				Item []struct {
+					Name  string      `json:"Name"`
+					Value interface{} `json:"Value"`
+				} `json:"Item"`
+			} `json:"CallbackMetadata"`
+		} `json:"stkCallback"`
+	} `json:"Body"`
+}
+
+// VerifyCallback checks the callback token and parses an STK Push result.
+// Without a configured token every callback is rejected.
+func (m *Mpesa) VerifyCallback(r *http.Request) (*CallbackResult, error) {
+	if !m.validToken(r.URL.Query().Get("token")) {
+		return nil, ErrInvalidCallback
+	}
+
+	var callback stkCallback
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	stk := callback.Body.StkCallback
+	if stk.CheckoutRequestID == "" {
+		return nil, fmt.Errorf("%w: missing CheckoutRequestID", ErrInvalidCallback)
+	}
+
+	result := &CallbackResult{
+		Reference:   stk.CheckoutRequestID,
+		Status:      db.PaymentStatusFailed,
+		Description: stk.ResultDesc,
+	}
+
+	if stk.ResultCode == 0 {
+		result.Status = db.PaymentStatusPaid
+		for _, item := range stk.CallbackMetadata.Item {
+			switch item.Name {
+			case "MpesaReceiptNumber":
+				result.TransactionID = fmt.Sprint(item.Value)
+			case "Amount":
+				if amount, ok := item.Value.(float64); ok {
+					result.Amount = int(amount)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// reversalRequest is the Daraja transaction reversal request body
+type reversalRequest struct {
+	Initiator              string `json:"Initiator"`
+	SecurityCredential     string `json:"SecurityCredential"`
+	CommandID              string `json:"CommandID"`
+	TransactionID          string `json:"TransactionID"`
+	Amount                 int    `json:"Amount"`
+	ReceiverParty          string `json:"ReceiverParty"`
+	RecieverIdentifierType string `json:"RecieverIdentifierType"`
+	ResultURL              string `json:"ResultURL"`
+	QueueTimeOutURL        string `json:"QueueTimeOutURL"`
+	Remarks                string `json:"Remarks"`
+	Occasion               string `json:"Occasion"`
+}
+
+// reversalResponse is the Daraja transaction reversal response body
+type reversalResponse struct {
+	ConversationID           string `json:"ConversationID"`
+	OriginatorConversationID string `json:"OriginatorConversationID"`
+	ResponseCode             string `json:"ResponseCode"`
+	ResponseDescription      string `json:"ResponseDescription"`
+	ErrorMessage             string `json:"errorMessage"`
+}
+
+// Refund requests a reversal of an M-Pesa transaction. Reversals complete
+// asynchronously, so the refund stays PENDING until Daraja reports the result.
+func (m *Mpesa) Refund(ctx context.Context, req RefundRequest) (*RefundResult, error) {
+	if req.TransactionID == "" {
+		return nil, fmt.Errorf("mpesa: refund requires the M-Pesa receipt number")
+	}
+	if m.config.ResultURL == "" {
+		return nil, fmt.Errorf("mpesa: refund requires a result URL")
+	}
+
+	timeoutURL := m.config.TimeoutURL
+	if timeoutURL == "" {
+		timeoutURL = m.config.ResultURL
+	}
+
+	remarks := req.Reason
+	if remarks == "" {
+		remarks = "Refund"
+	}
+
+	body := reversalRequest{
+		Initiator:              m.config.InitiatorName,
+		SecurityCredential:     m.config.SecurityCredential,
+		CommandID:              "TransactionReversal",
+		TransactionID:          req.TransactionID,
+		Amount:                 req.Amount,
+		ReceiverParty:          m.config.ShortCode,
+		RecieverIdentifierType: "11",
+		ResultURL:              m.refundURL(m.config.ResultURL, req.RefundID, false),
+		QueueTimeOutURL:        m.refundURL(timeoutURL, req.RefundID, true),
+		Remarks:                remarks,
+		Occasion:               req.Reference,
+	}
+
+	var resp reversalResponse
+	if err := m.post(ctx, "/mpesa/reversal/v1/request", body, &resp); err != nil {
+		return nil, err
+	}
+
+	if resp.ResponseCode != "0" {
+		return nil, fmt.Errorf("mpesa: reversal rejected: %s%s", resp.ResponseDescription, resp.ErrorMessage)
+	}
+
+	return &RefundResult{
+		Reference: resp.ConversationID,
+		Status:    db.PaymentStatusPending,
+		Message:   resp.ResponseDescription,
+	}, nil
+}
+
+// reversalResult is the body Daraja posts to the reversal result URL
+type reversalResult struct {
+	Result struct {
+		ResultType               int             `json:"ResultType"`
+		ResultCode               json.RawMessage `json:"ResultCode"`
+		ResultDesc               string          `json:"ResultDesc"`
+		OriginatorConversationID string          `json:"OriginatorConversationID"`
+		ConversationID           string          `json:"ConversationID"`
+		TransactionID            string          `json:"TransactionID"`
+	} `json:"Result"`
+}
+
+// VerifyRefundCallback checks the callback token and parses a reversal result
+// or queue timeout. A timeout fails the refund, so it can be issued again.
+func (m *Mpesa) VerifyRefundCallback(r *http.Request) (*RefundCallbackResult, error) {
+	query := r.URL.Query()
+	if !m.validToken(query.Get("token")) {
+		return nil, ErrInvalidCallback
+	}
+
+	refundID := query.Get("refund")
+	if refundID == "" {
+		return nil, fmt.Errorf("%w: missing refund ID", ErrInvalidCallback)
+	}
+
+	if query.Get("timeout") != "" {
+		return &RefundCallbackResult{
+			RefundID:    refundID,
+			Status:      db.PaymentStatusFailed,
+			Description: "Reversal timed out in the M-Pesa queue",
+		}, nil
+	}
+
+	var callback reversalResult
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+	}
+
+	result := &RefundCallbackResult{
+		RefundID:    refundID,
+		Status:      db.PaymentStatusFailed,
+		Description: callback.Result.ResultDesc,
+	}
+	// Daraja sends the result code as a number or a string
+	if strings.Trim(string(callback.Result.ResultCode), `"`) == "0" {
+		result.Status = db.PaymentStatusRefunded
+	}
+
+	return result, nil
+}
+
+// validToken reports whether a callback carries the configured callback token.
+// Without a configured token every callback is rejected.
+func (m *Mpesa) validToken(token string) bool {
+	return m.config.CallbackToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.config.CallbackToken)) == 1
+}
+
+// callbackURL returns the configured callback URL with the verification token
+func (m *Mpesa) callbackURL() string {
+	return withQuery(m.config.CallbackURL, url.Values{"token": {m.config.CallbackToken}})
+}
+
+// refundURL returns a reversal result or timeout URL identifying the refund
+func (m *Mpesa) refundURL(base, refundID string, timeout bool) string {
+	query := url.Values{"token": {m.config.CallbackToken}, "refund": {refundID}}
+	if timeout {
+		query.Set("timeout", "1")
+	}
+	return withQuery(base, query)
+}
+
+// withQuery appends query parameters to a URL that may already have some
+func withQuery(base string, query url.Values) string {
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + query.Encode()
+}
+
+// accessToken returns a cached OAuth token, fetching a new one when it expires
+func (m *Mpesa) accessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && time.Now().Before(m.tokenExpiry) {
+		return m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.config.BaseURL+"/oauth/v1/generate?grant_type=client_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(m.config.ConsumerKey, m.config.ConsumerSecret)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("mpesa: token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mpesa: token request returned %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("mpesa: invalid token response: %w", err)
+	}
+
+	expiresIn, err := body.ExpiresIn.Int64()
+	if err != nil || expiresIn <= 0 {
+		expiresIn = 3599
+	}
+
+	// Refresh a minute early so tokens never expire mid-request
+	m.token = body.AccessToken
+	m.tokenExpiry = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+
+	return m.token, nil
+}
+
+// post sends an authenticated JSON request to Daraja and decodes the response
+func (m *Mpesa) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+	token, err := m.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.config.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mpesa: request to %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("mpesa: invalid response from %s (%s): %w", path, resp.Status, err)
+	}
+
+	return nil
+}
+
+// nairobi is the timezone Daraja expects timestamps in
+var nairobi = func() *time.Location {
+	loc, err := time.LoadLocation("Africa/Nairobi")
+	if err != nil {
+		return time.FixedZone("EAT", 3*60*60)
+	}
+	return loc
+}()
+
+// NormalizeMsisdn converts a Kenyan phone number to the 2547XXXXXXXX form Daraja expects
+func NormalizeMsisdn(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case len(digits) == 12 && strings.HasPrefix(digits, "254"):
+	case len(digits) == 10 && strings.HasPrefix(digits, "0"):
+		digits = "254" + digits[1:]
+	case len(digits) == 9:
+		digits = "254" + digits
+	default:
+		return "", fmt.Errorf("mpesa: invalid phone number %q", phone)
+	}
+
+	if digits[3] != '7' && digits[3] != '1' {
+		return "", fmt.Errorf("mpesa: invalid phone number %q", phone)
+	}
+
+	return digits, nil
+}
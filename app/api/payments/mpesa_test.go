@@ -0,0 +1,386 @@
+package payments
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"beauty-shop/app/api/db"
+)
+
+// fakeDaraja is a local stand-in for the Daraja API that records what it is sent
+type fakeDaraja struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	tokens     int
+	stkPushes  []stkPushRequest
+	reversals  []reversalRequest
+	stkResult  stkPushResponse
+	revResult  reversalResponse
+	authHeader string
+}
+
+func newFakeDaraja(t *testing.T) *fakeDaraja {
+	t.Helper()
+
+	fake := &fakeDaraja{
+		stkResult: stkPushResponse{
+			MerchantRequestID: "29115-34620561-1",
+			CheckoutRequestID: "ws_CO_191220191020363925",
+			ResponseCode:      "0",
+			CustomerMessage:   "Success. Request accepted for processing",
+		},
+		revResult: reversalResponse{
+			ConversationID:      "AG_20191219_00004e48cf7e3533f581",
+			ResponseCode:        "0",
+			ResponseDescription: "Accept the service request successfully.",
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth/v1/generate", func(w http.ResponseWriter, r *http.Request) {
+		key, secret, ok := r.BasicAuth()
+		if !ok || key != "key" || secret != "secret" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		fake.mu.Lock()
+		fake.tokens++
+		fake.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "test-token", "expires_in": "3599"})
+	})
+	mux.HandleFunc("POST /mpesa/stkpush/v1/processrequest", func(w http.ResponseWriter, r *http.Request) {
+		var req stkPushRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		fake.mu.Lock()
+		fake.authHeader = r.Header.Get("Authorization")
+		fake.stkPushes = append(fake.stkPushes, req)
+		result := fake.stkResult
+		fake.mu.Unlock()
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("POST /mpesa/reversal/v1/request", func(w http.ResponseWriter, r *http.Request) {
+		var req reversalRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		fake.mu.Lock()
+		fake.reversals = append(fake.reversals, req)
+		result := fake.revResult
+		fake.mu.Unlock()
+		json.NewEncoder(w).Encode(result)
+	})
+
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// provider returns an M-Pesa provider talking to the fake
+func (f *fakeDaraja) provider() *Mpesa {
+	return NewMpesa(MpesaConfig{
+		BaseURL:            f.URL + "/",
+		ConsumerKey:        "key",
+		ConsumerSecret:     "secret",
+		ShortCode:          "174379",
+		PassKey:            "passkey",
+		CallbackURL:        "https://shop.example.com/payments/mpesa/callback",
+		CallbackToken:      "s3cret",
+		InitiatorName:      "testapi",
+		SecurityCredential: "credential",
+		ResultURL:          "https://shop.example.com/payments/mpesa/reversal/result",
+		TimeoutURL:         "https://shop.example.com/payments/mpesa/reversal/timeout",
+	}, f.Client())
+}
+
+func TestMpesaInitiate(t *testing.T) {
+	fake := newFakeDaraja(t)
+	mpesa := fake.provider()
+
+	for i := 0; i < 2; i++ {
+		result, err := mpesa.Initiate(context.Background(), InitiateRequest{
+			OrderNumber: "ORD-1001",
+			Amount:      1500,
+			Currency:    "KES",
+			Phone:       "0712 345 678",
+		})
+		if err != nil {
+			t.Fatalf("Initiate: %v", err)
+		}
+		if result.Reference != fake.stkResult.CheckoutRequestID || result.Status != db.PaymentStatusPending {
+			t.Errorf("result = %+v, want the CheckoutRequestID and PENDING", result)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.tokens != 1 {
+		t.Errorf("fetched %d access tokens, want 1 cached token", fake.tokens)
+	}
+	if fake.authHeader != "Bearer test-token" {
+		t.Errorf("Authorization = %q", fake.authHeader)
+	}
+
+	push := fake.stkPushes[0]
+	if push.PhoneNumber != "254712345678" || push.PartyA != "254712345678" {
+		t.Errorf("phone = %q / %q, want 254712345678", push.PhoneNumber, push.PartyA)
+	}
+	if push.Amount != 1500 || push.AccountReference != "ORD-1001" || push.BusinessShortCode != "174379" {
+		t.Errorf("push = %+v", push)
+	}
+	password, _ := base64.StdEncoding.DecodeString(push.Password)
+	if string(password) != "174379passkey"+push.Timestamp {
+		t.Errorf("password decodes to %q, want shortcode, passkey and timestamp", password)
+	}
+	if push.CallBackURL != "https://shop.example.com/payments/mpesa/callback?token=s3cret" {
+		t.Errorf("CallBackURL = %q, want the callback token added", push.CallBackURL)
+	}
+}
+
+func TestMpesaInitiateErrors(t *testing.T) {
+	fake := newFakeDaraja(t)
+	mpesa := fake.provider()
+
+	tests := []struct {
+		name   string
+		req    InitiateRequest
+		reject bool
+	}{
+		{"invalid phone", InitiateRequest{OrderNumber: "ORD-1", Amount: 100, Phone: "12345"}, false},
+		{"zero amount", InitiateRequest{OrderNumber: "ORD-1", Amount: 0, Phone: "0712345678"}, false},
+		{"rejected by Daraja", InitiateRequest{OrderNumber: "ORD-1", Amount: 100, Phone: "0712345678"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reject {
+				fake.mu.Lock()
+				fake.stkResult = stkPushResponse{ResponseCode: "1", ResponseDescription: "Rejected"}
+				fake.mu.Unlock()
+			}
+			if _, err := mpesa.Initiate(context.Background(), tt.req); err == nil {
+				t.Error("Initiate succeeded, want an error")
+			}
+		})
+	}
+}
+
+// stkCallbackBody builds a Daraja STK callback; amount < 0 leaves it out
+func stkCallbackBody(checkoutRequestID string, resultCode int, amount float64) string {
+	items := []map[string]interface{}{{"Name": "MpesaReceiptNumber", "Value": "NLJ7RT61SV"}}
+	if amount >= 0 {
+		items = append(items, map[string]interface{}{"Name": "Amount", "Value": amount})
+	}
+
+	stk := map[string]interface{}{
+		"MerchantRequestID": "29115-34620561-1",
+		"CheckoutRequestID": checkoutRequestID,
+		"ResultCode":        resultCode,
+		"ResultDesc":        "The service request is processed successfully.",
+	}
+	if resultCode == 0 {
+		stk["CallbackMetadata"] = map[string]interface{}{"Item": items}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"Body": map[string]interface{}{"stkCallback": stk}})
+	return string(body)
+}
+
+func TestMpesaVerifyCallback(t *testing.T) {
+	fake := newFakeDaraja(t)
+	mpesa := fake.provider()
+	unconfigured := NewMpesa(MpesaConfig{BaseURL: fake.URL}, fake.Client())
+
+	tests := []struct {
+		name     string
+		provider *Mpesa
+		query    string
+		body     string
+		want     *CallbackResult
+	}{
+		{
+			name:     "paid",
+			provider: mpesa,
+			query:    "?token=s3cret",
+			body:     stkCallbackBody("ws_CO_1", 0, 1500),
+			want:     &CallbackResult{Reference: "ws_CO_1", Status: db.PaymentStatusPaid, TransactionID: "NLJ7RT61SV", Amount: 1500},
+		},
+		{
+			name:     "paid without an amount",
+			provider: mpesa,
+			query:    "?token=s3cret",
+			body:     stkCallbackBody("ws_CO_1", 0, -1),
+			want:     &CallbackResult{Reference: "ws_CO_1", Status: db.PaymentStatusPaid, TransactionID: "NLJ7RT61SV"},
+		},
+		{
+			name:     "cancelled by the customer",
+			provider: mpesa,
+			query:    "?token=s3cret",
+			body:     stkCallbackBody("ws_CO_1", 1032, 0),
+			want:     &CallbackResult{Reference: "ws_CO_1", Status: db.PaymentStatusFailed},
+		},
+		{name: "missing token", provider: mpesa, body: stkCallbackBody("ws_CO_1", 0, 1500)},
+		{name: "wrong token", provider: mpesa, query: "?token=guess", body: stkCallbackBody("ws_CO_1", 0, 1500)},
+		{name: "no token configured", provider: unconfigured, query: "?token=", body: stkCallbackBody("ws_CO_1", 0, 1500)},
+		{name: "missing CheckoutRequestID", provider: mpesa, query: "?token=s3cret", body: stkCallbackBody("", 0, 1500)},
+		{name: "malformed body", provider: mpesa, query: "?token=s3cret", body: "{"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/payments/mpesa/callback"+tt.query, strings.NewReader(tt.body))
+			result, err := tt.provider.VerifyCallback(r)
+
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCallback) {
+					t.Fatalf("err = %v, want ErrInvalidCallback", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyCallback: %v", err)
+			}
+			result.Description = ""
+			if *result != *tt.want {
+				t.Errorf("result = %+v, want %+v", *result, *tt.want)
+			}
+		})
+	}
+}
+
+func TestMpesaRefund(t *testing.T) {
+	fake := newFakeDaraja(t)
+	mpesa := fake.provider()
+
+	if _, err := mpesa.Refund(context.Background(), RefundRequest{Reference: "ws_CO_1", Amount: 500}); err == nil {
+		t.Error("Refund without a receipt number succeeded")
+	}
+
+	result, err := mpesa.Refund(context.Background(), RefundRequest{
+		RefundID:      "refund-1",
+		Reference:     "ws_CO_1",
+		TransactionID: "NLJ7RT61SV",
+		Amount:        500,
+		Reason:        "Damaged in transit",
+	})
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if result.Reference != fake.revResult.ConversationID || result.Status != db.PaymentStatusPending {
+		t.Errorf("result = %+v, want the ConversationID and PENDING until Daraja reports back", result)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.reversals) != 1 {
+		t.Fatalf("sent %d reversals, want 1", len(fake.reversals))
+	}
+	reversal := fake.reversals[0]
+	if reversal.TransactionID != "NLJ7RT61SV" || reversal.Amount != 500 || reversal.CommandID != "TransactionReversal" {
+		t.Errorf("reversal = %+v", reversal)
+	}
+	if reversal.Remarks != "Damaged in transit" || reversal.Occasion != "ws_CO_1" {
+		t.Errorf("remarks = %q, occasion = %q", reversal.Remarks, reversal.Occasion)
+	}
+	if reversal.ResultURL != "https://shop.example.com/payments/mpesa/reversal/result?refund=refund-1&token=s3cret" {
+		t.Errorf("ResultURL = %q, want the refund ID and callback token added", reversal.ResultURL)
+	}
+	if reversal.QueueTimeOutURL != "https://shop.example.com/payments/mpesa/reversal/timeout?refund=refund-1&timeout=1&token=s3cret" {
+		t.Errorf("QueueTimeOutURL = %q, want the refund ID, timeout flag and callback token added", reversal.QueueTimeOutURL)
+	}
+}
+
+func TestMpesaVerifyRefundCallback(t *testing.T) {
+	fake := newFakeDaraja(t)
+	mpesa := fake.provider()
+
+	result := func(code string) string {
+		return `{"Result":{"ResultType":0,"ResultCode":` + code + `,"ResultDesc":"Done","ConversationID":"AG_1","TransactionID":"NLJ41HAY6Q"}}`
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  *RefundCallbackResult
+	}{
+		{
+			name:  "reversed",
+			query: "?token=s3cret&refund=refund-1",
+			body:  result("0"),
+			want:  &RefundCallbackResult{RefundID: "refund-1", Status: db.PaymentStatusRefunded, Description: "Done"},
+		},
+		{
+			name:  "reversed with a string code",
+			query: "?token=s3cret&refund=refund-1",
+			body:  result(`"0"`),
+			want:  &RefundCallbackResult{RefundID: "refund-1", Status: db.PaymentStatusRefunded, Description: "Done"},
+		},
+		{
+			name:  "rejected",
+			query: "?token=s3cret&refund=refund-1",
+			body:  result("2001"),
+			want:  &RefundCallbackResult{RefundID: "refund-1", Status: db.PaymentStatusFailed, Description: "Done"},
+		},
+		{
+			name:  "timed out",
+			query: "?token=s3cret&refund=refund-1&timeout=1",
+			want:  &RefundCallbackResult{RefundID: "refund-1", Status: db.PaymentStatusFailed, Description: "Reversal timed out in the M-Pesa queue"},
+		},
+		{name: "wrong token", query: "?token=guess&refund=refund-1", body: result("0")},
+		{name: "missing refund ID", query: "?token=s3cret", body: result("0")},
+		{name: "malformed body", query: "?token=s3cret&refund=refund-1", body: "{"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/payments/mpesa/refunds/callback"+tt.query, strings.NewReader(tt.body))
+			got, err := mpesa.VerifyRefundCallback(r)
+
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCallback) {
+					t.Fatalf("err = %v, want ErrInvalidCallback", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyRefundCallback: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("result = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMsisdn(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"0712345678", "254712345678"},
+		{"+254 712 345 678", "254712345678"},
+		{"712345678", "254712345678"},
+		{"0112345678", "254112345678"},
+		{"0612345678", ""},
+		{"12345", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeMsisdn(tt.phone)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NormalizeMsisdn(%q) = %q, want an error", tt.phone, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeMsisdn(%q) = %q, %v, want %q", tt.phone, got, err, tt.want)
+		}
+	}
+}
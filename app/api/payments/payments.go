@@ -0,0 +1,142 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+
+	"beauty-shop/app/api/db"
+)
+
+// Provider names accepted as an order's payment method
+const (
+	ProviderMpesa          = "mpesa"
+	ProviderCashOnDelivery = "cod"
+)
+
+var (
+	// ErrUnknownProvider is returned when no provider is registered under a name
+	ErrUnknownProvider = errors.New("unknown payment provider")
+
+	// ErrCallbackNotSupported is returned by providers that never call back
+	ErrCallbackNotSupported = errors.New("payment provider does not support callbacks")
+
+	// ErrInvalidCallback is returned when a callback fails verification
+	ErrInvalidCallback = errors.New("invalid payment callback")
+)
+
+// InitiateRequest describes a payment to collect for an order
+type InitiateRequest struct {
+	OrderID     string
+	OrderNumber string
+	Amount      int
+	Currency    string
+	Phone       string
+}
+
+// InitiateResult is the provider's answer to a payment request
+type InitiateResult struct {
+	// Reference identifies the payment in later callbacks and refunds
+	Reference string
+	Status    db.PaymentStatus
+	Message   string
+}
+
+// CallbackResult is the verified outcome reported by a provider callback
+type CallbackResult struct {
+	Reference     string
+	Status        db.PaymentStatus
+	TransactionID string
+	Amount        int
+	Description   string
+}
+
+// RefundRequest describes money to return for a completed payment
+type RefundRequest struct {
+	// RefundID identifies the refund in later refund callbacks
+	RefundID      string
+	Reference     string
+	TransactionID string
+	Amount        int
+	Reason        string
+}
+
+// RefundResult is the provider's answer to a refund request. A PENDING
+// refund is settled later by a refund callback.
+type RefundResult struct {
+	Reference string
+	Status    db.PaymentStatus
+	Message   string
+}
+
+// RefundCallbackResult is the verified outcome reported by a refund callback
+type RefundCallbackResult struct {
+	RefundID    string
+	Status      db.PaymentStatus
+	Description string
+}
+
+// Provider collects, confirms and refunds payments
+type Provider interface {
+	// Name returns the payment method name stored on orders
+	Name() string
+
+	// Initiate starts collecting a payment
+	Initiate(ctx context.Context, req InitiateRequest) (*InitiateResult, error)
+
+	// VerifyCallback authenticates and parses an asynchronous provider callback
+	VerifyCallback(r *http.Request) (*CallbackResult, error)
+
+	// Refund returns all or part of a completed payment
+	Refund(ctx context.Context, req RefundRequest) (*RefundResult, error)
+
+	// VerifyRefundCallback authenticates and parses the asynchronous outcome of a refund
+	VerifyRefundCallback(r *http.Request) (*RefundCallbackResult, error)
+}
+
+var (
+	providers   = map[string]Provider{}
+	providersMu sync.RWMutex
+	initOnce    sync.Once
+	initErr     error
+)
+
+// Register makes a provider available under its name, replacing any existing one
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Get returns the provider registered under name
+func Get(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Initialize registers the providers configured in the environment.
+// Cash on delivery is always available; M-Pesa is enabled when its
+// consumer key is set, and requires a callback token since Daraja does not
+// sign its callbacks.
+func Initialize() error {
+	initOnce.Do(func() {
+		Register(NewCashOnDelivery())
+
+		if os.Getenv("MPESA_CONSUMER_KEY") != "" {
+			config := MpesaConfigFromEnv()
+			if config.CallbackToken == "" {
+				initErr = errors.New("MPESA_CALLBACK_TOKEN must be set when M-Pesa is enabled")
+				return
+			}
+			Register(NewMpesa(config, nil))
+		}
+	})
+	return initErr
+}
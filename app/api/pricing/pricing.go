@@ -0,0 +1,260 @@
+// Package pricing turns order lines into a priced quote: line discounts,
+// VAT and shipping. Amounts are whole Kenyan shillings.
+package pricing
+
+import (
+	"errors"
+	"math"
+)
+
+// TaxMode says whether catalog prices include VAT
+type TaxMode string
+
+const (
+	// TaxExclusive adds VAT on top of catalog prices
+	TaxExclusive TaxMode = "EXCLUSIVE"
+	// TaxInclusive treats catalog prices as already including VAT
+	TaxInclusive TaxMode = "INCLUSIVE"
+)
+
+// IsValid reports whether m is a known tax mode
+func (m TaxMode) IsValid() bool {
+	return m == TaxExclusive || m == TaxInclusive
+}
+
+// Rounding is how fractions of a shilling are rounded
+type Rounding string
+
+const (
+	RoundHalfUp   Rounding = "HALF_UP"
+	RoundHalfEven Rounding = "HALF_EVEN"
+	RoundDown     Rounding = "DOWN"
+	RoundUp       Rounding = "UP"
+)
+
+// IsValid reports whether r is a known rounding policy
+func (r Rounding) IsValid() bool {
+	switch r {
+	case RoundHalfUp, RoundHalfEven, RoundDown, RoundUp:
+		return true
+	}
+	return false
+}
+
+// Divide returns num/den rounded by the policy. Both must be non-negative
+// and den positive.
+func (r Rounding) Divide(num, den int64) int64 {
+	q, rem := num/den, num%den
+	if rem == 0 {
+		return q
+	}
+
+	switch r {
+	case RoundDown:
+		return q
+	case RoundUp:
+		return q + 1
+	case RoundHalfEven:
+		if 2*rem > den || (2*rem == den && q%2 == 1) {
+			return q + 1
+		}
+		return q
+	default:
+		if 2*rem >= den {
+			return q + 1
+		}
+		return q
+	}
+}
+
+// Settings are the store's pricing rules
+type Settings struct {
+	// TaxRate is the VAT rate in percent, e.g. 16
+	TaxRate  float64  `json:"taxRate"`
+	TaxMode  TaxMode  `json:"taxMode"`
+	Rounding Rounding `json:"rounding"`
+
+	// ShippingRate is charged unless the discounted subtotal reaches
+	// FreeShippingThreshold
+	ShippingRate          int `json:"shippingRate"`
+	FreeShippingThreshold int `json:"freeShippingThreshold"`
+}
+
+// Defaults are used for anything the store settings leave out
+var Defaults = Settings{
+	TaxRate:               16, // 16% VAT
+	TaxMode:               TaxExclusive,
+	Rounding:              RoundHalfUp,
+	ShippingRate:          500,
+	FreeShippingThreshold: 5000,
+}
+
+// Validate checks that the settings can price an order
+func (s Settings) Validate() error {
+	switch {
+	case math.IsNaN(s.TaxRate) || s.TaxRate < 0 || s.TaxRate > 100:
+		return errors.New("tax rate must be between 0 and 100")
+	case !s.TaxMode.IsValid():
+		return errors.New("tax mode must be EXCLUSIVE or INCLUSIVE")
+	case !s.Rounding.IsValid():
+		return errors.New("rounding must be HALF_UP, HALF_EVEN, DOWN or UP")
+	case s.ShippingRate < 0:
+		return errors.New("shipping rate cannot be negative")
+	case s.FreeShippingThreshold < 0:
+		return errors.New("free shipping threshold cannot be negative")
+	}
+	return nil
+}
+
+// Line is an order line to price
+type Line struct {
+	UnitPrice int
+	Quantity  int
+
+	// Discount is the part of the order's discount taken off this line
+	Discount int
+}
+
+// Input is what a quote is computed from
+type Input struct {
+	Lines []Line
+
+	// Shipping is the rate of the chosen shipping method. Without one the
+	// flat rate from the settings applies.
+	Shipping     *int
+	FreeShipping bool
+}
+
+// QuoteLine is a priced order line
+type QuoteLine struct {
+	UnitPrice int `json:"unitPrice"`
+	Quantity  int `json:"quantity"`
+	Subtotal  int `json:"subtotal"`
+	Discount  int `json:"discount"`
+	Tax       int `json:"tax"`
+	Total     int `json:"total"`
+}
+
+// Quote is a priced order. Subtotal is before discounts; Tax is added to
+// Total in exclusive mode and already part of the prices in inclusive mode.
+type Quote struct {
+	Lines    []QuoteLine `json:"lines"`
+	Subtotal int         `json:"subtotal"`
+	Discount int         `json:"discount"`
+	Tax      int         `json:"tax"`
+	Shipping int         `json:"shipping"`
+	Total    int         `json:"total"`
+	TaxRate  float64     `json:"taxRate"`
+	TaxMode  TaxMode     `json:"taxMode"`
+}
+
+// Quote prices the lines. VAT is charged on each line after its discount.
+// The flat shipping rate is free once the discounted subtotal reaches the
+// threshold.
+func (s Settings) Quote(in Input) Quote {
+	quote := Quote{
+		Lines:   make([]QuoteLine, 0, len(in.Lines)),
+		TaxRate: s.TaxRate,
+		TaxMode: s.TaxMode,
+	}
+
+	var discounted int
+	for _, line := range in.Lines {
+		q := QuoteLine{
+			UnitPrice: line.UnitPrice,
+			Quantity:  line.Quantity,
+			Subtotal:  line.UnitPrice * line.Quantity,
+			Discount:  line.Discount,
+		}
+		if q.Discount < 0 {
+			q.Discount = 0
+		}
+		if q.Discount > q.Subtotal {
+			q.Discount = q.Subtotal
+		}
+
+		net := q.Subtotal - q.Discount
+		q.Tax = s.tax(net)
+		q.Total = net
+		if s.TaxMode != TaxInclusive {
+			q.Total += q.Tax
+		}
+
+		quote.Lines = append(quote.Lines, q)
+		quote.Subtotal += q.Subtotal
+		quote.Discount += q.Discount
+		quote.Tax += q.Tax
+		quote.Total += q.Total
+		discounted += net
+	}
+
+	switch {
+	case len(in.Lines) == 0 || in.FreeShipping:
+		// Nothing to ship, or shipping is on the house
+	case in.Shipping != nil:
+		quote.Shipping = *in.Shipping
+	case discounted < s.FreeShippingThreshold:
+		quote.Shipping = s.ShippingRate
+	}
+	quote.Total += quote.Shipping
+
+	return quote
+}
+
+// tax returns the VAT on a net amount: added on top in exclusive mode, or
+// the VAT share of it in inclusive mode
+func (s Settings) tax(net int) int {
+	if net <= 0 {
+		return 0
+	}
+
+	basisPoints := int64(math.Round(s.TaxRate * 100))
+	den := int64(10000)
+	if s.TaxMode == TaxInclusive {
+		den += basisPoints
+	}
+	return int(s.Rounding.Divide(int64(net)*basisPoints, den))
+}
+
+// Allocate splits amount across weights in proportion, handing leftover
+// shillings to the largest remainders so the parts always add up to amount.
+// Zero weights get nothing.
+func Allocate(amount int, weights []int) []int {
+	parts := make([]int, len(weights))
+
+	var total int64
+	for _, weight := range weights {
+		if weight > 0 {
+			total += int64(weight)
+		}
+	}
+	if amount <= 0 || total == 0 {
+		return parts
+	}
+
+	remainders := make([]int64, len(weights))
+	allocated := 0
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		share := int64(amount) * int64(weight)
+		parts[i] = int(share / total)
+		remainders[i] = share % total
+		allocated += parts[i]
+	}
+
+	// Earlier lines win ties so the split is deterministic
+	for ; allocated < amount; allocated++ {
+		best := -1
+		for i, remainder := range remainders {
+			if weights[i] > 0 && (best < 0 || remainder > remainders[best]) {
+				best = i
+			}
+		}
+		parts[best]++
+		remainders[best] = -1
+	}
+
+	return parts
+}
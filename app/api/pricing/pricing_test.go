@@ -0,0 +1,151 @@
+package pricing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundingDivide(t *testing.T) {
+	tests := []struct {
+		num, den                   int64
+		halfUp, halfEven, down, up int64
+	}{
+		{6, 3, 2, 2, 2, 2},
+		{9, 4, 2, 2, 2, 3},
+		{11, 4, 3, 3, 2, 3},
+		{5, 2, 3, 2, 2, 3}, // tie below an even number
+		{7, 2, 4, 4, 3, 4}, // tie below an odd number
+		{0, 7, 0, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		for rounding, want := range map[Rounding]int64{
+			RoundHalfUp:   tt.halfUp,
+			RoundHalfEven: tt.halfEven,
+			RoundDown:     tt.down,
+			RoundUp:       tt.up,
+		} {
+			if got := rounding.Divide(tt.num, tt.den); got != want {
+				t.Errorf("%s: %d/%d = %d, want %d", rounding, tt.num, tt.den, got, want)
+			}
+		}
+	}
+}
+
+func TestQuoteTax(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		line     Line
+		tax      int
+		total    int
+	}{
+		{"exclusive adds VAT", Settings{TaxRate: 16, TaxMode: TaxExclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 1000, Quantity: 1}, 160, 1160},
+		{"inclusive reports the VAT share", Settings{TaxRate: 16, TaxMode: TaxInclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 1160, Quantity: 1}, 160, 1160},
+		{"inclusive rounds half up", Settings{TaxRate: 16, TaxMode: TaxInclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 1000, Quantity: 1}, 138, 1000},
+		{"inclusive rounds down", Settings{TaxRate: 16, TaxMode: TaxInclusive, Rounding: RoundDown}, Line{UnitPrice: 1000, Quantity: 1}, 137, 1000},
+		{"exclusive rounds up", Settings{TaxRate: 16, TaxMode: TaxExclusive, Rounding: RoundUp}, Line{UnitPrice: 1003, Quantity: 1}, 161, 1164},
+		{"exclusive rounds down", Settings{TaxRate: 16, TaxMode: TaxExclusive, Rounding: RoundDown}, Line{UnitPrice: 1003, Quantity: 1}, 160, 1163},
+		{"half up on a tie", Settings{TaxRate: 10, TaxMode: TaxExclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 5, Quantity: 1}, 1, 6},
+		{"half even on a tie to even", Settings{TaxRate: 10, TaxMode: TaxExclusive, Rounding: RoundHalfEven}, Line{UnitPrice: 5, Quantity: 1}, 0, 5},
+		{"half even on a tie to odd", Settings{TaxRate: 10, TaxMode: TaxExclusive, Rounding: RoundHalfEven}, Line{UnitPrice: 15, Quantity: 1}, 2, 17},
+		{"tax after the line discount", Settings{TaxRate: 16, TaxMode: TaxExclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 1000, Quantity: 2, Discount: 500}, 240, 1740},
+		{"discount capped at the line", Settings{TaxRate: 16, TaxMode: TaxExclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 100, Quantity: 1, Discount: 500}, 0, 0},
+		{"zero rate", Settings{TaxRate: 0, TaxMode: TaxExclusive, Rounding: RoundHalfUp}, Line{UnitPrice: 999, Quantity: 3}, 0, 2997},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			free := 0
+			quote := tt.settings.Quote(Input{Lines: []Line{tt.line}, Shipping: &free})
+			if quote.Tax != tt.tax || quote.Total != tt.total {
+				t.Errorf("tax = %d, total = %d, want %d, %d", quote.Tax, quote.Total, tt.tax, tt.total)
+			}
+			if line := quote.Lines[0]; line.Tax != quote.Tax || line.Total != quote.Total {
+				t.Errorf("line = %+v, want the quote's tax and total", line)
+			}
+		})
+	}
+}
+
+func TestQuoteTotals(t *testing.T) {
+	quote := Defaults.Quote(Input{Lines: []Line{
+		{UnitPrice: 1500, Quantity: 2, Discount: 300},
+		{UnitPrice: 800, Quantity: 1, Discount: 100},
+	}})
+
+	want := Quote{
+		Lines: []QuoteLine{
+			{UnitPrice: 1500, Quantity: 2, Subtotal: 3000, Discount: 300, Tax: 432, Total: 3132},
+			{UnitPrice: 800, Quantity: 1, Subtotal: 800, Discount: 100, Tax: 112, Total: 812},
+		},
+		Subtotal: 3800,
+		Discount: 400,
+		Tax:      544,
+		Shipping: 500,
+		Total:    4444,
+		TaxRate:  16,
+		TaxMode:  TaxExclusive,
+	}
+	if !reflect.DeepEqual(quote, want) {
+		t.Errorf("quote = %+v\nwant %+v", quote, want)
+	}
+}
+
+func TestQuoteShipping(t *testing.T) {
+	method := 300
+
+	tests := []struct {
+		name  string
+		input Input
+		want  int
+	}{
+		{"below the threshold", Input{Lines: []Line{{UnitPrice: 4999, Quantity: 1}}}, 500},
+		{"at the threshold", Input{Lines: []Line{{UnitPrice: 5000, Quantity: 1}}}, 0},
+		{"threshold uses the discounted subtotal", Input{Lines: []Line{{UnitPrice: 3000, Quantity: 2, Discount: 1500}}}, 500},
+		{"shipping method rate", Input{Lines: []Line{{UnitPrice: 9000, Quantity: 1}}, Shipping: &method}, 300},
+		{"free shipping coupon", Input{Lines: []Line{{UnitPrice: 100, Quantity: 1}}, Shipping: &method, FreeShipping: true}, 0},
+		{"nothing to ship", Input{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Defaults.Quote(tt.input).Shipping; got != tt.want {
+				t.Errorf("shipping = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		amount  int
+		weights []int
+		want    []int
+	}{
+		{100, []int{1, 1, 1}, []int{34, 33, 33}},
+		{7, []int{1, 2}, []int{2, 5}},
+		{10, []int{3, 0, 7}, []int{3, 0, 7}},
+		{1000, []int{2999, 4999, 1}, []int{375, 625, 0}},
+		{500, []int{1500, 800, 1250}, []int{211, 113, 176}},
+		{0, []int{1, 2}, []int{0, 0}},
+		{50, []int{0, 0}, []int{0, 0}},
+		{50, []int{}, []int{}},
+	}
+
+	for _, tt := range tests {
+		got := Allocate(tt.amount, tt.weights)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Allocate(%d, %v) = %v, want %v", tt.amount, tt.weights, got, tt.want)
+		}
+
+		sum, positive := 0, false
+		for i, part := range got {
+			sum += part
+			positive = positive || tt.weights[i] > 0
+		}
+		if positive && tt.amount > 0 && sum != tt.amount {
+			t.Errorf("Allocate(%d, %v) parts add up to %d", tt.amount, tt.weights, sum)
+		}
+	}
+}
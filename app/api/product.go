@@ -4,15 +4,12 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
 	"github.com/gofrs/uuid"
 )
 
-// Handler handles HTTP requests for a single product
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
-
+// ProductHandler handles HTTP requests for a single product
+func ProductHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -30,9 +27,17 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get product ID or slug from the URL path
+	// Get product ID or slug from the URL path, falling back to query parameters
 	productID := r.URL.Query().Get("id")
 	productSlug := r.URL.Query().Get("slug")
+	if pathValue := r.PathValue("slug"); pathValue != "" {
+		// The admin UI addresses products by ID, the storefront by slug
+		if _, err := uuid.FromString(pathValue); err == nil {
+			productID = pathValue
+		} else {
+			productSlug = pathValue
+		}
+	}
 
 	if productID == "" && productSlug == "" {
 		http.Error(w, "Product ID or slug is required", http.StatusBadRequest)
@@ -51,7 +56,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := query.First(&product, "id = ?", id).Error; err != nil {
+		if err := query.First(&product, "_id = ?", id).Error; err != nil {
 			http.Error(w, "Product not found", http.StatusNotFound)
 			return
 		}
@@ -62,12 +67,23 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Add category breadcrumbs
+	if err := setProductBreadcrumbs(db.DB, &product); err != nil {
+		http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
+		return
+	}
+
+	// Add the rating summary of approved reviews
+	summary, err := loadRatingSummary(db.DB, product.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch product", http.StatusInternalServerError)
+		return
+	}
+	product.RatingSummary = summary
+
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
 	// Return the product
 	json.NewEncoder(w).Encode(product)
 }
-
-
-
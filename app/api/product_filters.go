@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// priceBucketEdges are the lower bounds of the price facet buckets in KES;
+// the last bucket is open-ended
+var priceBucketEdges = []int{0, 1000, 2500, 5000, 10000}
+
+// productSort is a listing order: an SQL expression over products and its
+// direction. Rows with equal values are ordered by ID in the same direction
+// so the order is total and can be resumed from a cursor.
+type productSort struct {
+	Expression string
+	Desc       bool
+
+	// Time is set when the expression is a timestamp rather than a number
+	Time bool
+}
+
+// productSorts maps the sort parameter to the listing order. Aggregates are
+// cast to float8 so cursor values round-trip exactly.
+var productSorts = map[string]productSort{
+	"newest":     {Expression: "products.created_at", Desc: true, Time: true},
+	"price_asc":  {Expression: "products.price"},
+	"price_desc": {Expression: "products.price", Desc: true},
+	"popularity": {Expression: popularitySQL, Desc: true},
+	"rating":     {Expression: `(SELECT COALESCE(AVG(rv.rating), 0)::float8 FROM reviews rv WHERE rv."productId" = products._id AND rv.status = 'APPROVED')`, Desc: true},
+}
+
+// popularitySQL is the number of units of a product sold, counting the same
+// orders as salesScope and leaving out units refunded back
+const popularitySQL = `(SELECT COALESCE(SUM(oi.quantity
+		- COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri JOIN refunds rf ON rf._id = ri."refundId"
+			WHERE ri."orderItemId" = oi._id AND rf.status = 'REFUNDED'), 0)), 0)::float8
+	FROM order_items oi JOIN orders o ON o._id = oi."orderId"
+	WHERE oi."productId" = products._id AND o.status <> 'CANCELLED'
+		AND (o.payment_status IN ('PAID', 'REFUNDED') OR o.status IN ('SHIPPED', 'DELIVERED')))`
+
+// OrderBy returns the ORDER BY clause of the sort
+func (s productSort) OrderBy() string {
+	if s.Desc {
+		return s.Expression + " DESC, products._id DESC"
+	}
+	return s.Expression + " ASC, products._id ASC"
+}
+
+// cursorAfter returns the cursor pointing past product in this sort
+func (s productSort) cursorAfter(tx *gorm.DB, name string, product db.Product) (lib.Cursor, error) {
+	cursor := lib.Cursor{Sort: name, ID: product.ID.String()}
+	if s.Time {
+		cursor.Time = &product.CreatedAt
+		return cursor, nil
+	}
+
+	var value float64
+	if err := tx.Model(&db.Product{}).Unscoped().Select(s.Expression).
+		Where("products._id = ?", product.ID).Scan(&value).Error; err != nil {
+		return cursor, err
+	}
+	cursor.Number = &value
+	return cursor, nil
+}
+
+// productFilters are the listing filters parsed from the query string
+type productFilters struct {
+	CategoryID *uuid.UUID
+	Featured   bool
+	MinPrice   *int
+	MaxPrice   *int
+	InStock    bool
+	OnSale     bool
+	MinRating  *float64
+
+	// Attributes maps a lowercase attribute name to the accepted lowercase
+	// values. Values of one attribute are ORed, different attributes ANDed.
+	Attributes map[string][]string
+	Sort       string
+}
+
+// AttributeFacet counts the products having each value of an attribute
+type AttributeFacet struct {
+	Name   string       `json:"name"`
+	Values []FacetCount `json:"values"`
+}
+
+// FacetCount is the number of products with a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PriceBucket counts the products in a price range; Max is exclusive and
+// nil for the open-ended top bucket
+type PriceBucket struct {
+	Min   int   `json:"min"`
+	Max   *int  `json:"max"`
+	Count int64 `json:"count"`
+}
+
+// parseProductFilters reads the filters of the products listing.
+// Attribute filters are given as attr=name:value and may repeat.
+func parseProductFilters(query url.Values) (productFilters, error) {
+	filters := productFilters{
+		Featured:   query.Get("featured") == "true",
+		InStock:    query.Get("inStock") == "true",
+		OnSale:     query.Get("onSale") == "true",
+		Attributes: make(map[string][]string),
+		Sort:       query.Get("sort"),
+	}
+
+	if filters.Sort == "" {
+		filters.Sort = "newest"
+	}
+	if _, ok := productSorts[filters.Sort]; !ok {
+		return filters, fmt.Errorf("unknown sort %q", filters.Sort)
+	}
+
+	for param, target := range map[string]**int{"minPrice": &filters.MinPrice, "maxPrice": &filters.MaxPrice} {
+		if raw := query.Get(param); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 0 {
+				return filters, fmt.Errorf("invalid %s", param)
+			}
+			*target = &value
+		}
+	}
+
+	if raw := query.Get("minRating"); raw != "" {
+		rating, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return filters, fmt.Errorf("invalid minRating")
+		}
+		filters.MinRating = &rating
+	}
+
+	for _, raw := range query["attr"] {
+		name, value, ok := strings.Cut(raw, ":")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return filters, fmt.Errorf("invalid attr %q, expected name:value", raw)
+		}
+		filters.Attributes[name] = append(filters.Attributes[name], value)
+	}
+
+	return filters, nil
+}
+
+// apply adds the filters to a products query. The price range, or the
+// filter on one attribute given as "attr:<name>", can be left out with
+// except so facets show the choices that filter would offer.
+func (f productFilters) apply(tx *gorm.DB, except string) *gorm.DB {
+	if f.CategoryID != nil {
+		tx = tx.Where("products.\"categoryId\" IN ("+descendantCategoriesSQL+")", *f.CategoryID)
+	}
+	if f.Featured {
+		tx = tx.Where("products.featured = ?", true)
+	}
+	if except != "price" {
+		if f.MinPrice != nil {
+			tx = tx.Where("products.price >= ?", *f.MinPrice)
+		}
+		if f.MaxPrice != nil {
+			tx = tx.Where("products.price <= ?", *f.MaxPrice)
+		}
+	}
+	if f.InStock {
+		tx = tx.Where("products.in_stock = ?", true)
+	}
+	if f.OnSale {
+		tx = tx.Where("products.original_price > products.price")
+	}
+	if f.MinRating != nil {
+		tx = tx.Where(`(SELECT AVG(rv.rating) FROM reviews rv WHERE rv."productId" = products._id AND rv.status = 'APPROVED') >= ?`, *f.MinRating)
+	}
+	for name, values := range f.Attributes {
+		if except == "attr:"+name {
+			continue
+		}
+		tx = tx.Where(`EXISTS (SELECT 1 FROM product_attributes pa
+			WHERE pa."productId" = products._id AND lower(pa.name) = ? AND lower(pa.value) IN ?)`, name, values)
+	}
+	return tx
+}
+
+// attributeFacets counts products per attribute value. An attribute being
+// filtered on is counted without its own filter, so its other values stay
+// selectable.
+func (f productFilters) attributeFacets(tx *gorm.DB) ([]AttributeFacet, error) {
+	type facetRow struct {
+		Name  string
+		Value string
+		Count int64
+	}
+
+	count := func(except, onlyName string) ([]facetRow, error) {
+		matching := f.apply(tx.Model(&db.Product{}), except).Select("products._id")
+		query := tx.Table("product_attributes pa").
+			Select(`pa.name, pa.value, COUNT(DISTINCT pa."productId") AS count`).
+			Where(`pa."productId" IN (?)`, matching)
+		if onlyName != "" {
+			query = query.Where("lower(pa.name) = ?", onlyName)
+		}
+
+		var rows []facetRow
+		err := query.Group("pa.name, pa.value").Scan(&rows).Error
+		return rows, err
+	}
+
+	rows, err := count("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Recount the filtered attributes without their own filter
+	var merged []facetRow
+	for _, row := range rows {
+		if _, filtered := f.Attributes[strings.ToLower(row.Name)]; !filtered {
+			merged = append(merged, row)
+		}
+	}
+	for name := range f.Attributes {
+		own, err := count("attr:"+name, name)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, own...)
+	}
+
+	byName := make(map[string]*AttributeFacet)
+	var facets []*AttributeFacet
+	for _, row := range merged {
+		facet, ok := byName[row.Name]
+		if !ok {
+			facet = &AttributeFacet{Name: row.Name}
+			byName[row.Name] = facet
+			facets = append(facets, facet)
+		}
+		facet.Values = append(facet.Values, FacetCount{Value: row.Value, Count: row.Count})
+	}
+
+	sort.Slice(facets, func(i, j int) bool { return facets[i].Name < facets[j].Name })
+	result := make([]AttributeFacet, len(facets))
+	for i, facet := range facets {
+		sort.Slice(facet.Values, func(a, b int) bool { return facet.Values[a].Value < facet.Values[b].Value })
+		result[i] = *facet
+	}
+	return result, nil
+}
+
+// priceFacets counts products per price bucket, ignoring the price filter
+func (f productFilters) priceFacets(tx *gorm.DB) ([]PriceBucket, error) {
+	var bucketSQL strings.Builder
+	bucketSQL.WriteString("CASE")
+	for i := len(priceBucketEdges) - 1; i > 0; i-- {
+		fmt.Fprintf(&bucketSQL, " WHEN products.price >= %d THEN %d", priceBucketEdges[i], i)
+	}
+	bucketSQL.WriteString(" ELSE 0 END")
+
+	var rows []struct {
+		Bucket int
+		Count  int64
+	}
+	if err := f.apply(tx.Model(&db.Product{}), "price").
+		Select(bucketSQL.String() + " AS bucket, COUNT(*) AS count").
+		Group("bucket").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := make([]PriceBucket, len(priceBucketEdges))
+	for i, lower := range priceBucketEdges {
+		buckets[i].Min = lower
+		if i+1 < len(priceBucketEdges) {
+			upper := priceBucketEdges[i+1]
+			buckets[i].Max = &upper
+		}
+	}
+	for _, row := range rows {
+		buckets[row.Bucket].Count = row.Count
+	}
+	return buckets, nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"beauty-shop/app/api/db"
+)
+
+func TestPopularitySort(t *testing.T) {
+	openTestDB(t)
+
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "popular@example.com")
+	category := createTestCategory(t, "Popularity")
+	toner := createTestProduct(t, category, db.Product{Name: "Rose toner", Price: 1000, StockQuantity: 20})
+	serum := createTestProduct(t, category, db.Product{Name: "Niacinamide serum", Price: 2000, StockQuantity: 20})
+	mask := createTestProduct(t, category, db.Product{Name: "Clay mask", Price: 1500, StockQuantity: 20})
+
+	deliver := func(order *db.Order) {
+		t.Helper()
+		for _, status := range []db.OrderStatus{db.OrderStatusProcessing, db.OrderStatusShipped, db.OrderStatusDelivered} {
+			if err := changeOrderStatus(order.ID, admin.ID, UpdateOrderStatusRequest{Status: status}); err != nil {
+				t.Fatalf("move order to %s: %v", status, err)
+			}
+		}
+	}
+
+	// Two rose toners delivered; five more ordered and then cancelled
+	deliver(placeTestOrder(t, user, toner, 2))
+	cancelled := placeTestOrder(t, user, toner, 5)
+	if err := changeOrderStatus(cancelled.ID, admin.ID, UpdateOrderStatusRequest{Status: db.OrderStatusCancelled}); err != nil {
+		t.Fatalf("cancel order: %v", err)
+	}
+
+	// Three serums delivered, two of them refunded
+	sold := placeTestOrder(t, user, serum, 3)
+	deliver(sold)
+	reloadOrder(t, sold)
+	refund := CreateRefundRequest{Restock: true}
+	refund.Items = append(refund.Items, struct {
+		OrderItemID string `json:"orderItemId"`
+		Quantity    int    `json:"quantity"`
+	}{OrderItemID: sold.Items[0].ID.String(), Quantity: 2})
+	if _, err := issueRefund(context.Background(), sold.ID, admin.ID, refund); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+
+	// Four masks ordered for cash on delivery but not yet shipped or paid
+	placeTestOrder(t, user, mask, 4)
+
+	sort := productSorts["popularity"]
+	tests := []struct {
+		product db.Product
+		want    float64
+	}{
+		{toner, 2},
+		{serum, 1},
+		{mask, 0},
+	}
+	for _, tt := range tests {
+		var got float64
+		if err := db.DB.Model(&db.Product{}).Select(sort.Expression).Where("products._id = ?", tt.product.ID).Scan(&got).Error; err != nil {
+			t.Fatalf("popularity of %s: %v", tt.product.Name, err)
+		}
+		if got != tt.want {
+			t.Errorf("popularity of %s = %v, want %v", tt.product.Name, got, tt.want)
+		}
+	}
+
+	var names []string
+	if err := db.DB.Model(&db.Product{}).Where("\"categoryId\" = ?", category.ID).Order(sort.OrderBy()).Pluck("name", &names).Error; err != nil {
+		t.Fatalf("sort: %v", err)
+	}
+	if len(names) != 3 || names[0] != toner.Name || names[1] != serum.Name || names[2] != mask.Name {
+		t.Errorf("sorted = %q, want toner, serum, mask", names)
+	}
+}
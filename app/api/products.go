@@ -3,16 +3,13 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
 )
 
-// Handler handles HTTP requests for the products endpoint
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
-
+// ProductsHandler handles HTTP requests for the products endpoint
+func ProductsHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -32,52 +29,79 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Get query parameters
 	category := r.URL.Query().Get("category")
-	featured := r.URL.Query().Get("featured")
-	limit := r.URL.Query().Get("limit")
-	page := r.URL.Query().Get("page")
 
-	// Query products from database
-	query := db.DB.Preload("Images").Preload("Category")
+	filters, err := parseProductFilters(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sort := productSorts[filters.Sort]
+
+	// Pagination resumes after the cursor of the previous page
+	limit, after, err := lib.ParseCursorParams(r, filters.Sort)
+	if err != nil {
+		http.Error(w, "Invalid cursor", http.StatusBadRequest)
+		return
+	}
 
-	// Filter by category if provided
+	// Filter by category, including all of its subcategories
 	if category != "" {
 		var categoryObj db.Category
 		if err := db.DB.Where("slug = ?", category).First(&categoryObj).Error; err == nil {
-			query = query.Where("category_id = ?", categoryObj.ID)
+			filters.CategoryID = &categoryObj.ID
 		}
 	}
 
-	// Filter by featured if provided
-	if featured == "true" {
-		query = query.Where("featured = ?", true)
-	}
+	// Get total count
+	var total int64
+	filters.apply(db.DB.Model(&db.Product{}), "").Count(&total)
 
-	// Pagination
-	var pageSize int = 10 // Default page size
-	var pageNum int = 1   // Default page number
+	// Query products from database, one more than the page to detect a next page
+	query := filters.apply(db.DB.Preload("Images").Preload("Category"), "")
+	if condition, args := lib.KeysetCondition(sort.Expression, "products._id", sort.Desc, after); condition != "" {
+		query = query.Where(condition, args...)
+	}
 
-	if limit != "" {
-		if parsedLimit, err := strconv.Atoi(limit); err == nil && parsedLimit > 0 {
-			pageSize = parsedLimit
-		}
+	var products []db.Product
+	if err := query.Order(sort.OrderBy()).Limit(limit + 1).Find(&products).Error; err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
 	}
 
-	if page != "" {
-		if parsedPage, err := strconv.Atoi(page); err == nil && parsedPage > 0 {
-			pageNum = parsedPage
+	var next lib.Cursor
+	fetched := len(products)
+	if fetched > limit {
+		products = products[:limit]
+		if next, err = sort.cursorAfter(db.DB, filters.Sort, products[limit-1]); err != nil {
+			http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+			return
 		}
 	}
 
-	// Calculate offset
-	offset := (pageNum - 1) * pageSize
+	// Add category breadcrumbs
+	withBreadcrumbs := make([]*db.Product, len(products))
+	for i := range products {
+		withBreadcrumbs[i] = &products[i]
+	}
+	if err := setProductBreadcrumbs(db.DB, withBreadcrumbs...); err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
 
-	// Get total count
-	var total int64
-	query.Model(&db.Product{}).Count(&total)
+	// Count products per attribute value and price bucket for the filter sidebar
+	attributeFacets, err := filters.attributeFacets(db.DB)
+	if err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
+	priceFacets, err := filters.priceFacets(db.DB)
+	if err != nil {
+		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		return
+	}
 
-	// Execute the query with pagination
-	var products []db.Product
-	if err := query.Limit(pageSize).Offset(offset).Find(&products).Error; err != nil {
+	pagination, err := lib.NewCursorPagination(limit, fetched, next)
+	if err != nil {
 		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
 		return
 	}
@@ -85,12 +109,12 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Prepare response
 	response := map[string]interface{}{
 		"products": products,
-		"pagination": map[string]interface{}{
-			"total":    total,
-			"page":     pageNum,
-			"pageSize": pageSize,
-			"pages":    (total + int64(pageSize) - 1) / int64(pageSize),
+		"facets": map[string]interface{}{
+			"attributes": attributeFacets,
+			"price":      priceFacets,
 		},
+		"total":      total,
+		"pagination": pagination,
 	}
 
 	// Set content type
@@ -99,6 +123,3 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// Return products
 	json.NewEncoder(w).Encode(response)
 }
-
-
-
@@ -0,0 +1,628 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProductRequest represents the admin request to create or replace a product.
+// Images and attributes are replaced wholesale; variants are matched by ID so
+// existing variants keep their identity in carts and orders.
+type ProductRequest struct {
+	Name          string  `json:"name"`
+	Slug          *string `json:"slug,omitempty"`
+	Description   string  `json:"description"`
+	Price         int     `json:"price"`
+	OriginalPrice *int    `json:"originalPrice,omitempty"`
+	CategoryID    string  `json:"categoryId"`
+	Featured      bool    `json:"featured"`
+	StockQuantity int     `json:"stockQuantity"`
+	SKU           *string `json:"sku,omitempty"`
+	Weight        *int    `json:"weight,omitempty"` // Grams
+	Images        []struct {
+		URL    string  `json:"url"`
+		Alt    *string `json:"alt,omitempty"`
+		IsMain bool    `json:"isMain"`
+	} `json:"images"`
+	Attributes []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"attributes"`
+	Variants []struct {
+		ID            *string `json:"id,omitempty"`
+		Name          string  `json:"name"`
+		SKU           *string `json:"sku,omitempty"`
+		Price         int     `json:"price"`
+		StockQuantity int     `json:"stockQuantity"`
+		Weight        *int    `json:"weight,omitempty"`
+		Attributes    db.JSON `json:"attributes,omitempty"`
+	} `json:"variants"`
+}
+
+// AdminProductsHandler handles admin requests to create products
+func AdminProductsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	product, err := saveProduct(nil, req)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to save product")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusCreated, product)
+}
+
+// AdminProductHandler handles admin requests to read, replace and archive a product
+func AdminProductHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	productID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Archived products stay viewable by admins
+		var product db.Product
+		if err := loadProductDetail(db.DB.Unscoped()).First(&product, "_id = ?", productID).Error; err != nil {
+			lib.RespondWithError(w, http.StatusNotFound, "Product not found")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, product)
+
+	case "PUT":
+		var req ProductRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		product, err := saveProduct(&productID, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save product")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, product)
+
+	case "DELETE":
+		if err := archiveProduct(productID); err != nil {
+			respondWithAPIError(w, err, "Failed to save product")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Product archived"})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ArchivedProductsHandler lists archived products
+func ArchivedProductsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var products []db.Product
+	if err := db.DB.Unscoped().Preload("Images").Preload("Category", func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped()
+	}).Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&products).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch archived products")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, products)
+}
+
+// RestoreProductHandler restores an archived product
+func RestoreProductHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	productID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	if err := restoreProduct(productID); err != nil {
+		respondWithAPIError(w, err, "Failed to save product")
+		return
+	}
+
+	var product db.Product
+	if err := loadProductDetail(db.DB).First(&product, "_id = ?", productID).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to load product")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, product)
+}
+
+// PurgeProductHandler permanently deletes an archived product
+func PurgeProductHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	productID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	if err := purgeProduct(productID); err != nil {
+		respondWithAPIError(w, err, "Failed to save product")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Product permanently deleted"})
+}
+
+// saveProduct creates a product, or replaces the one with productID, together
+// with its images, attributes and variants in a single transaction
+func saveProduct(productID *uuid.UUID, req ProductRequest) (*db.Product, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, &apiError{http.StatusBadRequest, "Name is required"}
+	}
+	if req.Price <= 0 {
+		return nil, &apiError{http.StatusBadRequest, "Price must be positive"}
+	}
+	if req.OriginalPrice != nil && *req.OriginalPrice < req.Price {
+		return nil, &apiError{http.StatusBadRequest, "Original price cannot be below the price"}
+	}
+	if req.StockQuantity < 0 {
+		return nil, &apiError{http.StatusBadRequest, "Stock quantity cannot be negative"}
+	}
+	if req.Weight != nil && *req.Weight < 0 {
+		return nil, &apiError{http.StatusBadRequest, "Weight cannot be negative"}
+	}
+
+	categoryID, err := uuid.FromString(req.CategoryID)
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, "Invalid category ID"}
+	}
+
+	// Every SKU in the request must be unique, across products and variants
+	req.SKU = normalizeSKU(req.SKU)
+	var skus []string
+	if req.SKU != nil {
+		skus = append(skus, *req.SKU)
+	}
+	inStock := req.StockQuantity > 0
+	for i := range req.Variants {
+		variant := &req.Variants[i]
+		variant.Name = strings.TrimSpace(variant.Name)
+		if variant.Name == "" {
+			return nil, &apiError{http.StatusBadRequest, "Variant name is required"}
+		}
+		if variant.Price <= 0 {
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s must have a positive price", variant.Name)}
+		}
+		if variant.StockQuantity < 0 {
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s cannot have negative stock", variant.Name)}
+		}
+		if variant.Weight != nil && *variant.Weight < 0 {
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s cannot have a negative weight", variant.Name)}
+		}
+		if variant.StockQuantity > 0 {
+			inStock = true
+		}
+		variant.SKU = normalizeSKU(variant.SKU)
+		if variant.SKU != nil {
+			skus = append(skus, *variant.SKU)
+		}
+	}
+	seen := make(map[string]bool, len(skus))
+	for _, sku := range skus {
+		if seen[sku] {
+			return nil, &apiError{http.StatusConflict, fmt.Sprintf("SKU %s is used more than once", sku)}
+		}
+		seen[sku] = true
+	}
+
+	var product db.Product
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if productID != nil {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Variants").
+				First(&product, "_id = ?", *productID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Product not found"}
+				}
+				return err
+			}
+		}
+
+		var categoryCount int64
+		if err := tx.Model(&db.Category{}).Where("_id = ?", categoryID).Count(&categoryCount).Error; err != nil {
+			return err
+		}
+		if categoryCount == 0 {
+			return &apiError{http.StatusBadRequest, "Category not found"}
+		}
+
+		if err := checkSKUsAvailable(tx, skus, product.ID); err != nil {
+			return err
+		}
+
+		// Keep the existing slug unless a new one is asked for
+		if productID == nil || req.Slug != nil {
+			source := req.Name
+			if req.Slug != nil {
+				source = *req.Slug
+			}
+			slug, err := uniqueSlug(tx, &db.Product{}, source, product.ID)
+			if err != nil {
+				return err
+			}
+			product.Slug = slug
+		}
+
+		product.Name = req.Name
+		product.Description = req.Description
+		product.Price = req.Price
+		product.OriginalPrice = req.OriginalPrice
+		product.CategoryID = categoryID
+		product.Featured = req.Featured
+		product.InStock = inStock
+		product.StockQuantity = req.StockQuantity
+		product.SKU = req.SKU
+		product.Weight = req.Weight
+
+		if err := tx.Omit(clause.Associations).Save(&product).Error; err != nil {
+			return err
+		}
+
+		// Replace images and attributes
+		if err := tx.Where("\"productId\" = ?", product.ID).Delete(&db.ProductImage{}).Error; err != nil {
+			return err
+		}
+		// Exactly one image is the main one, the first by default
+		mainImage := 0
+		for i, input := range req.Images {
+			if input.IsMain {
+				mainImage = i
+				break
+			}
+		}
+		for i, input := range req.Images {
+			url := strings.TrimSpace(input.URL)
+			if url == "" {
+				return &apiError{http.StatusBadRequest, "Image URL is required"}
+			}
+			image := db.ProductImage{
+				URL:       url,
+				Alt:       input.Alt,
+				ProductID: product.ID,
+				IsMain:    i == mainImage,
+			}
+			if err := tx.Create(&image).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("\"productId\" = ?", product.ID).Delete(&db.ProductAttribute{}).Error; err != nil {
+			return err
+		}
+		for _, input := range req.Attributes {
+			name, value := strings.TrimSpace(input.Name), strings.TrimSpace(input.Value)
+			if name == "" || value == "" {
+				return &apiError{http.StatusBadRequest, "Attribute name and value are required"}
+			}
+			attribute := db.ProductAttribute{ProductID: product.ID, Name: name, Value: value}
+			if err := tx.Create(&attribute).Error; err != nil {
+				return err
+			}
+		}
+
+		return saveVariants(tx, product, req)
+	})
+	if err != nil {
+		// A concurrent save may still win the race for a slug or SKU
+		return nil, conflictOnDuplicate(err, "Slug or SKU is already in use")
+	}
+
+	if err := loadProductDetail(db.DB).First(&product, "_id = ?", product.ID).Error; err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// saveVariants updates variants sent with an ID, creates the rest and removes
+// variants left out of the request along with any cart lines holding them
+func saveVariants(tx *gorm.DB, product db.Product, req ProductRequest) error {
+	existing := make(map[string]bool, len(product.Variants))
+	for _, variant := range product.Variants {
+		existing[variant.ID] = true
+	}
+
+	kept := make(map[string]bool, len(req.Variants))
+	for _, input := range req.Variants {
+		if input.ID != nil {
+			if !existing[*input.ID] {
+				return &apiError{http.StatusBadRequest, fmt.Sprintf("Variant %s does not belong to this product", *input.ID)}
+			}
+			kept[*input.ID] = true
+		}
+	}
+
+	var removed []string
+	for id := range existing {
+		if !kept[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Where("\"variantId\" IN ?", removed).Delete(&db.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("_id IN ?", removed).Delete(&db.ProductVariant{}).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, input := range req.Variants {
+		variant := db.ProductVariant{
+			ProductID:     product.ID,
+			Name:          input.Name,
+			SKU:           input.SKU,
+			Price:         input.Price,
+			StockQuantity: input.StockQuantity,
+			Weight:        input.Weight,
+			Attributes:    input.Attributes,
+		}
+
+		if input.ID != nil {
+			variant.ID = *input.ID
+			if err := tx.Model(&variant).Omit(clause.Associations).
+				Select("name", "sku", "price", "stock_quantity", "weight", "attributes", "updated_at").
+				Updates(&variant).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		variant.ID = id.String()
+		if err := tx.Omit(clause.Associations).Create(&variant).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveProduct soft deletes a product, hiding it from the storefront while
+// past orders keep resolving it. Carts drop it straight away.
+func archiveProduct(productID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var product db.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "_id = ?", productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Product not found"}
+			}
+			return err
+		}
+
+		if err := tx.Where("\"productId\" = ?", productID).Delete(&db.CartItem{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&product).Error
+	})
+}
+
+// restoreProduct brings an archived product back into the catalog
+func restoreProduct(productID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var product db.Product
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at IS NOT NULL").First(&product, "_id = ?", productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Archived product not found"}
+			}
+			return err
+		}
+
+		var categoryCount int64
+		if err := tx.Model(&db.Category{}).Where("_id = ?", product.CategoryID).Count(&categoryCount).Error; err != nil {
+			return err
+		}
+		if categoryCount == 0 {
+			return &apiError{http.StatusConflict, "Restore the product's category first"}
+		}
+
+		return conflictOnDuplicate(tx.Unscoped().Model(&product).Update("deleted_at", nil).Error, "Slug or SKU is already in use")
+	})
+}
+
+// purgeProduct permanently removes an archived product and its children.
+// Products that have been ordered stay archived so order history stays intact.
+func purgeProduct(productID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var product db.Product
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("deleted_at IS NOT NULL").First(&product, "_id = ?", productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Archived product not found"}
+			}
+			return err
+		}
+
+		var orderCount int64
+		if err := tx.Model(&db.OrderItem{}).Where("\"productId\" = ?", productID).Count(&orderCount).Error; err != nil {
+			return err
+		}
+		if orderCount > 0 {
+			return &apiError{http.StatusConflict, "Product has been ordered and can only stay archived"}
+		}
+
+		if err := tx.Where("\"reviewId\" IN (?)", tx.Model(&db.Review{}).Select("_id").
+			Where("\"productId\" = ?", productID)).Delete(&db.ReviewVote{}).Error; err != nil {
+			return err
+		}
+
+		// Drop the product from coupon scopes
+		if err := tx.Exec("DELETE FROM coupon_products WHERE product_id = ?", productID).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&db.CartItem{},
+			&db.WishlistItem{},
+			&db.Notification{},
+			&db.Review{},
+			&db.ProductImage{},
+			&db.ProductAttribute{},
+			&db.ProductVariant{},
+		} {
+			if err := tx.Unscoped().Where("\"productId\" = ?", productID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Delete(&product).Error
+	})
+}
+
+// checkSKUsAvailable rejects SKUs already held by another product or by a
+// variant of another product. Archived rows keep their SKUs.
+func checkSKUsAvailable(tx *gorm.DB, skus []string, productID uuid.UUID) error {
+	if len(skus) == 0 {
+		return nil
+	}
+
+	var taken []string
+	if err := tx.Unscoped().Model(&db.Product{}).Where("sku IN ? AND _id <> ?", skus, productID).Pluck("sku", &taken).Error; err != nil {
+		return err
+	}
+	if len(taken) == 0 {
+		if err := tx.Unscoped().Model(&db.ProductVariant{}).Where("sku IN ? AND \"productId\" <> ?", skus, productID).Pluck("sku", &taken).Error; err != nil {
+			return err
+		}
+	}
+	if len(taken) > 0 {
+		return &apiError{http.StatusConflict, fmt.Sprintf("SKU %s is already in use", taken[0])}
+	}
+
+	return nil
+}
+
+// uniqueSlug slugifies source and appends -2, -3, ... until no other row of
+// model, archived or not, has the slug
+func uniqueSlug(tx *gorm.DB, model interface{}, source string, excludeID uuid.UUID) (string, error) {
+	base := lib.Slugify(source)
+	if base == "" {
+		return "", &apiError{http.StatusBadRequest, "Slug must contain letters or digits"}
+	}
+
+	var taken []string
+	if err := tx.Unscoped().Model(model).Where("(slug = ? OR slug LIKE ?) AND _id <> ?", base, base+"-%", excludeID).
+		Pluck("slug", &taken).Error; err != nil {
+		return "", err
+	}
+
+	used := make(map[string]bool, len(taken))
+	for _, slug := range taken {
+		used[slug] = true
+	}
+
+	slug := base
+	for n := 2; used[slug]; n++ {
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+	return slug, nil
+}
+
+// normalizeSKU trims a SKU and treats blank SKUs as unset
+func normalizeSKU(sku *string) *string {
+	if sku == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*sku)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+// loadProductDetail preloads everything the admin product form edits
+func loadProductDetail(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Category").
+		Preload("Images", func(tx *gorm.DB) *gorm.DB { return tx.Order("is_main DESC, created_at ASC") }).
+		Preload("Attributes").
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("created_at ASC") })
+}
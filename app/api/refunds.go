@@ -0,0 +1,427 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateRefundRequest represents the request to refund an order in full or per line.
+// Without items the whole remaining balance is refunded. Amount overrides the
+// computed amount, e.g. to refund a goodwill portion of a line; given without
+// items it refunds money only, so no lines are recorded and nothing is restocked.
+type CreateRefundRequest struct {
+	Items []struct {
+		OrderItemID string `json:"orderItemId"`
+		Quantity    int    `json:"quantity"`
+	} `json:"items,omitempty"`
+	Amount  *int    `json:"amount,omitempty"`
+	Reason  *string `json:"reason,omitempty"`
+	Restock bool    `json:"restock"`
+}
+
+// RefundsHandler handles admin requests to list and issue refunds for an order
+func RefundsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Get user info set by the auth middleware
+	userIDStr, _, _, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	orderID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// List refunds for the order
+		var refunds []db.Refund
+		if err := db.DB.Preload("Items").Where(&db.Refund{OrderID: orderID}).Order("created_at ASC").Find(&refunds).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch refunds")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, refunds)
+
+	case "POST":
+		// Issue a new refund
+		adminID, err := uuid.FromString(userIDStr)
+		if err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		var req CreateRefundRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		refund, err := issueRefund(r.Context(), orderID, adminID, req)
+		if err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				lib.RespondWithError(w, apiErr.Status, apiErr.Message)
+				return
+			}
+			lib.RespondWithError(w, http.StatusBadGateway, fmt.Sprintf("Failed to issue refund: %v", err))
+			return
+		}
+
+		// Refunds the provider settles later are accepted rather than created
+		status := http.StatusCreated
+		if refund.Status == db.PaymentStatusPending {
+			status = http.StatusAccepted
+		}
+		lib.RespondWithSuccess(w, status, refund)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// RefundCallbackHandler handles asynchronous refund results from providers
+func RefundCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := payments.Get(r.PathValue("provider"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusNotFound, "Unknown payment provider")
+		return
+	}
+
+	// Authenticate and parse the callback
+	result, err := provider.VerifyRefundCallback(r)
+	if err != nil {
+		if errors.Is(err, payments.ErrCallbackNotSupported) {
+			lib.RespondWithError(w, http.StatusNotFound, "Payment provider does not accept refund callbacks")
+			return
+		}
+		log.Printf("Rejected %s refund callback: %v", provider.Name(), err)
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid callback")
+		return
+	}
+
+	refundID, err := uuid.FromString(result.RefundID)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid refund ID")
+		return
+	}
+
+	if _, err := settleRefund(refundID, &payments.RefundResult{Status: result.Status, Message: result.Description}); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			lib.RespondWithError(w, http.StatusNotFound, "Refund not found")
+			return
+		}
+		log.Printf("Failed to apply %s refund callback %s: %v", provider.Name(), refundID, err)
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to process callback")
+		return
+	}
+
+	// Acknowledge in the format Daraja expects; other providers ignore the body
+	lib.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"ResultCode": 0,
+		"ResultDesc": "Accepted",
+	})
+}
+
+// issueRefund refunds an order through its payment provider. The refund is
+// saved as PENDING first, so the provider is called without holding the
+// order lock, and is settled with the provider's answer. Providers that
+// answer PENDING settle it later through their refund callback.
+func issueRefund(ctx context.Context, orderID, adminID uuid.UUID, req CreateRefundRequest) (*db.Refund, error) {
+	refund, payment, err := reserveRefund(orderID, adminID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the money back through the provider that collected it
+	provider, err := payments.Get(payment.Provider)
+	if err != nil {
+		return nil, failRefund(refund.ID, err)
+	}
+
+	transactionID := ""
+	if payment.TransactionID != nil {
+		transactionID = *payment.TransactionID
+	}
+
+	reason := ""
+	if req.Reason != nil {
+		reason = *req.Reason
+	}
+
+	result, err := provider.Refund(ctx, payments.RefundRequest{
+		RefundID:      refund.ID.String(),
+		Reference:     payment.Reference,
+		TransactionID: transactionID,
+		Amount:        refund.Amount,
+		Reason:        reason,
+	})
+	if err != nil {
+		return nil, failRefund(refund.ID, err)
+	}
+
+	return settleRefund(refund.ID, result)
+}
+
+// failRefund records that the provider refused a refund, releasing its share
+// of the balance so it can be retried, and returns the provider's error
+func failRefund(refundID uuid.UUID, err error) error {
+	if _, settleErr := settleRefund(refundID, &payments.RefundResult{Status: db.PaymentStatusFailed, Message: err.Error()}); settleErr != nil {
+		log.Printf("Failed to record failed refund %s: %v", refundID, settleErr)
+	}
+	return err
+}
+
+// reserveRefund validates a refund request against the locked order and
+// saves it as PENDING. Pending refunds count against the refundable balance
+// and quantities, so refunds issued meanwhile cannot exceed the order.
+func reserveRefund(orderID, adminID uuid.UUID, req CreateRefundRequest) (*db.Refund, *db.Payment, error) {
+	var (
+		refund  db.Refund
+		payment db.Payment
+	)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var order db.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, "_id = ?", orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Order not found"}
+			}
+			return err
+		}
+
+		if order.PaymentStatus != db.PaymentStatusPaid {
+			return &apiError{http.StatusConflict, fmt.Sprintf("Cannot refund an order with payment status %s", order.PaymentStatus)}
+		}
+
+		// Find the completed payment to refund against
+		if err := tx.Where(&db.Payment{OrderID: order.ID, Status: db.PaymentStatusPaid}).
+			Order("created_at DESC").First(&payment).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusConflict, "Order has no completed payment"}
+			}
+			return err
+		}
+
+		// Refunds still waiting on the provider hold their share of the balance
+		var pending int
+		if err := tx.Model(&db.Refund{}).Where(&db.Refund{OrderID: order.ID, Status: db.PaymentStatusPending}).
+			Select("COALESCE(SUM(amount), 0)").Scan(&pending).Error; err != nil {
+			return err
+		}
+
+		remaining := order.Total - order.RefundedAmount - pending
+		refund = db.Refund{
+			OrderID:     order.ID,
+			PaymentID:   payment.ID,
+			Reason:      req.Reason,
+			Status:      db.PaymentStatusPending,
+			Restocked:   req.Restock,
+			CreatedByID: &adminID,
+		}
+
+		// Work out the lines being refunded
+		itemsByID := make(map[uuid.UUID]db.OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			itemsByID[item.ID] = item
+		}
+
+		refundQuantities := make(map[uuid.UUID]int)
+		for _, line := range req.Items {
+			itemID, err := uuid.FromString(line.OrderItemID)
+			if err != nil {
+				return &apiError{http.StatusBadRequest, "Invalid order item ID"}
+			}
+			if _, ok := itemsByID[itemID]; !ok {
+				return &apiError{http.StatusBadRequest, fmt.Sprintf("Order item not found: %s", itemID)}
+			}
+			if line.Quantity <= 0 {
+				return &apiError{http.StatusBadRequest, "Refund quantity must be positive"}
+			}
+			refundQuantities[itemID] += line.Quantity
+		}
+
+		// Quantities of each line covered by earlier refunds that have not failed
+		var previous []struct {
+			OrderItemID uuid.UUID
+			Quantity    int
+		}
+		if err := tx.Table("refund_items ri").
+			Joins("JOIN refunds r ON r._id = ri.\"refundId\"").
+			Select("ri.\"orderItemId\" AS order_item_id, SUM(ri.quantity) AS quantity").
+			Where("ri.\"orderItemId\" IN ? AND r.status <> ?", mapKeys(itemsByID), db.PaymentStatusFailed).
+			Group("ri.\"orderItemId\"").Scan(&previous).Error; err != nil {
+			return err
+		}
+		alreadyRefunded := make(map[uuid.UUID]int, len(previous))
+		for _, p := range previous {
+			alreadyRefunded[p.OrderItemID] = p.Quantity
+		}
+
+		if len(refundQuantities) > 0 {
+			// Lines cannot be refunded beyond what was bought
+			for itemID, quantity := range refundQuantities {
+				item := itemsByID[itemID]
+				if alreadyRefunded[itemID]+quantity > item.Quantity {
+					return &apiError{http.StatusConflict, fmt.Sprintf("Cannot refund %d of %s: only %d not yet refunded", quantity, item.Name, item.Quantity-alreadyRefunded[itemID])}
+				}
+
+				amount := lineRefundAmount(item, quantity)
+				refund.Items = append(refund.Items, db.RefundItem{
+					OrderItemID: itemID,
+					Quantity:    quantity,
+					Amount:      amount,
+				})
+				refund.Amount += amount
+			}
+		} else if req.Amount == nil {
+			// Full refund of whatever has not been refunded yet
+			refund.Amount = remaining
+			for _, item := range order.Items {
+				quantity := item.Quantity - alreadyRefunded[item.ID]
+				if quantity <= 0 {
+					continue
+				}
+				refund.Items = append(refund.Items, db.RefundItem{
+					OrderItemID: item.ID,
+					Quantity:    quantity,
+					Amount:      lineRefundAmount(item, quantity),
+				})
+			}
+		}
+
+		if req.Amount != nil {
+			refund.Amount = *req.Amount
+		}
+
+		// An amount on its own returns money, not goods
+		if req.Restock && len(refund.Items) == 0 {
+			return &apiError{http.StatusBadRequest, "Restocking requires the items being returned"}
+		}
+
+		if refund.Amount <= 0 {
+			return &apiError{http.StatusBadRequest, "Refund amount must be positive"}
+		}
+		if refund.Amount > remaining {
+			return &apiError{http.StatusConflict, fmt.Sprintf("Refund amount %d exceeds refundable balance %d", refund.Amount, remaining)}
+		}
+
+		return tx.Create(&refund).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &refund, &payment, nil
+}
+
+// settleRefund applies a provider's answer to a pending refund. A completed
+// refund restocks its lines when asked to and adds to the order's refunded
+// amount; a failed one releases its share of the balance. Refunds that are
+// no longer pending are returned unchanged, so repeated answers are ignored.
+func settleRefund(refundID uuid.UUID, result *payments.RefundResult) (*db.Refund, error) {
+	var refund db.Refund
+	if err := db.DB.First(&refund, "_id = ?", refundID).Error; err != nil {
+		return nil, err
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the order before the refund, in the same order as reserveRefund
+		var order db.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&order, "_id = ?", refund.OrderID).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&refund, "_id = ?", refundID).Error; err != nil {
+			return err
+		}
+
+		if refund.Status != db.PaymentStatusPending {
+			return nil
+		}
+
+		updates := map[string]interface{}{"status": result.Status}
+		if result.Reference != "" {
+			updates["provider_reference"] = result.Reference
+		}
+		if result.Message != "" {
+			updates["description"] = result.Message
+		}
+		if err := tx.Model(&refund).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		if result.Status != db.PaymentStatusRefunded {
+			return nil
+		}
+
+		// Return refunded units to stock, unless cancelling the order
+		// already put everything back
+		if refund.Restocked && order.Status != db.OrderStatusCancelled {
+			itemsByID := make(map[uuid.UUID]db.OrderItem, len(order.Items))
+			for _, item := range order.Items {
+				itemsByID[item.ID] = item
+			}
+			for _, line := range refund.Items {
+				if err := restockItem(tx, itemsByID[line.OrderItemID], line.Quantity); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Only a refund of the full total flips the order to REFUNDED
+		refundedAmount := order.RefundedAmount + refund.Amount
+		orderUpdates := map[string]interface{}{"refunded_amount": refundedAmount}
+		if refundedAmount >= order.Total {
+			orderUpdates["payment_status"] = db.PaymentStatusRefunded
+		}
+		return tx.Model(&order).Updates(orderUpdates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &refund, nil
+}
+
+// lineRefundAmount is what was paid for quantity units of an order line,
+// after its discount and with its VAT. Orders placed before line pricing
+// only have the unit price.
+func lineRefundAmount(item db.OrderItem, quantity int) int {
+	if item.Total == 0 || item.Quantity == 0 {
+		return item.Price * quantity
+	}
+	return item.Total * quantity / item.Quantity
+}
+
+// mapKeys returns the keys of a map keyed by ID
+func mapKeys[V any](m map[uuid.UUID]V) []uuid.UUID {
+	keys := make([]uuid.UUID, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
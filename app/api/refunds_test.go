@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+)
+
+func TestRefundDeliveredCashOrder(t *testing.T) {
+	openTestDB(t)
+
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "cash@example.com")
+	category := createTestCategory(t, "Refund cash")
+	product := createTestProduct(t, category, db.Product{Name: "Rose toner", Price: 1000, StockQuantity: 5})
+
+	order := placeTestOrder(t, user, product, 2)
+	for _, status := range []db.OrderStatus{db.OrderStatusProcessing, db.OrderStatusShipped, db.OrderStatusDelivered} {
+		if err := changeOrderStatus(order.ID, admin.ID, UpdateOrderStatusRequest{Status: status}); err != nil {
+			t.Fatalf("move order to %s: %v", status, err)
+		}
+	}
+	reloadOrder(t, order)
+	if order.PaymentStatus != db.PaymentStatusPaid {
+		t.Fatalf("delivered cash order has payment status %s, want PAID", order.PaymentStatus)
+	}
+
+	item := order.Items[0]
+	lineRequest := CreateRefundRequest{Restock: true}
+	lineRequest.Items = append(lineRequest.Items, struct {
+		OrderItemID string `json:"orderItemId"`
+		Quantity    int    `json:"quantity"`
+	}{OrderItemID: item.ID.String(), Quantity: 1})
+
+	refund, err := issueRefund(context.Background(), order.ID, admin.ID, lineRequest)
+	if err != nil {
+		t.Fatalf("refund a line: %v", err)
+	}
+	if refund.Status != db.PaymentStatusRefunded || len(refund.Items) != 1 {
+		t.Errorf("line refund = %s with %d items, want REFUNDED with 1", refund.Status, len(refund.Items))
+	}
+	if err := db.DB.First(&product, "_id = ?", product.ID).Error; err != nil {
+		t.Fatalf("reload product: %v", err)
+	}
+	if product.StockQuantity != 4 {
+		t.Errorf("stock after restocking refund = %d, want 4", product.StockQuantity)
+	}
+
+	goodwill := 100
+	_, err = issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{Amount: &goodwill, Restock: true})
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("restocking an amount-only refund: err = %v, want 400", err)
+	}
+
+	refund, err = issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{Amount: &goodwill})
+	if err != nil {
+		t.Fatalf("refund an amount: %v", err)
+	}
+	if refund.Amount != goodwill || len(refund.Items) != 0 {
+		t.Errorf("amount-only refund = %d with %d items, want %d with none", refund.Amount, len(refund.Items), goodwill)
+	}
+
+	refund, err = issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{})
+	if err != nil {
+		t.Fatalf("refund the rest: %v", err)
+	}
+	if len(refund.Items) != 1 || refund.Items[0].Quantity != 1 {
+		t.Errorf("full refund covers %+v, want the 1 unit not yet refunded", refund.Items)
+	}
+
+	reloadOrder(t, order)
+	if order.PaymentStatus != db.PaymentStatusRefunded || order.RefundedAmount != order.Total {
+		t.Errorf("order = %s with %d of %d refunded, want fully REFUNDED", order.PaymentStatus, order.RefundedAmount, order.Total)
+	}
+}
+
+// deferredRefunds is a provider whose refunds are settled by a later callback
+// carrying the refund ID and the outcome in the query string
+type deferredRefunds struct {
+	*payments.CashOnDelivery
+}
+
+func (d deferredRefunds) Name() string {
+	return "deferred"
+}
+
+func (d deferredRefunds) Refund(ctx context.Context, req payments.RefundRequest) (*payments.RefundResult, error) {
+	return &payments.RefundResult{Reference: "DEFERRED-" + req.RefundID, Status: db.PaymentStatusPending}, nil
+}
+
+func (d deferredRefunds) VerifyRefundCallback(r *http.Request) (*payments.RefundCallbackResult, error) {
+	query := r.URL.Query()
+	return &payments.RefundCallbackResult{RefundID: query.Get("refund"), Status: db.PaymentStatus(query.Get("status"))}, nil
+}
+
+func TestPendingRefundSettledByCallback(t *testing.T) {
+	openTestDB(t)
+	payments.Register(deferredRefunds{})
+
+	admin := createTestUser(t, "admin@example.com")
+	user := createTestUser(t, "deferred@example.com")
+
+	order := &db.Order{UserID: &user.ID, OrderNumber: "ORD-DEFERRED", Total: 1000, PaymentMethod: "deferred", PaymentStatus: db.PaymentStatusPaid}
+	if err := db.DB.Create(order).Error; err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+	if err := db.DB.Create(&db.Payment{OrderID: order.ID, Provider: "deferred", Reference: "DEFERRED-PAY", Amount: 1000, Status: db.PaymentStatusPaid}).Error; err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+
+	amount := 600
+	refund, err := issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{Amount: &amount})
+	if err != nil {
+		t.Fatalf("issue refund: %v", err)
+	}
+	if refund.Status != db.PaymentStatusPending {
+		t.Fatalf("refund status = %s, want PENDING", refund.Status)
+	}
+
+	// The pending refund holds its share of the balance
+	_, err = issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{Amount: &amount})
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusConflict {
+		t.Errorf("refund beyond the unreserved balance: err = %v, want 409", err)
+	}
+
+	callback := func(refundID string, status db.PaymentStatus) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/payments/deferred/refunds/callback?refund="+refundID+"&status="+string(status), nil)
+		req.SetPathValue("provider", "deferred")
+		rec := httptest.NewRecorder()
+		RefundCallbackHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("callback status code = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	// A failure releases the balance so the refund can be issued again
+	callback(refund.ID.String(), db.PaymentStatusFailed)
+	refund, err = issueRefund(context.Background(), order.ID, admin.ID, CreateRefundRequest{Amount: &amount})
+	if err != nil {
+		t.Fatalf("reissue refund after failure: %v", err)
+	}
+
+	callback(refund.ID.String(), db.PaymentStatusRefunded)
+	callback(refund.ID.String(), db.PaymentStatusFailed)
+
+	if err := db.DB.First(refund, "_id = ?", refund.ID).Error; err != nil {
+		t.Fatalf("reload refund: %v", err)
+	}
+	reloadOrder(t, order)
+	if refund.Status != db.PaymentStatusRefunded || order.RefundedAmount != amount {
+		t.Errorf("refund %s with %d refunded, want REFUNDED with %d", refund.Status, order.RefundedAmount, amount)
+	}
+	if order.PaymentStatus != db.PaymentStatusPaid {
+		t.Errorf("partly refunded order has payment status %s, want PAID", order.PaymentStatus)
+	}
+}
+
+// reloadOrder refreshes an order and its items from the database
+func reloadOrder(t *testing.T, order *db.Order) {
+	t.Helper()
+
+	if err := db.DB.Preload("Items").First(order, "_id = ?", order.ID).Error; err != nil {
+		t.Fatalf("reload order: %v", err)
+	}
+}
@@ -0,0 +1,642 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits on review text, in characters
+const (
+	maxReviewTitleLength   = 120
+	maxReviewContentLength = 5000
+)
+
+// reviewSort is a review list order, resumed by cursor like productSort
+type reviewSort struct {
+	Column string
+	Desc   bool
+	Time   bool
+}
+
+// reviewSorts are the orders of the public review list
+var reviewSorts = map[string]reviewSort{
+	"newest":  {Column: "created_at", Desc: true, Time: true},
+	"helpful": {Column: "helpful_count", Desc: true},
+}
+
+// moderationQueueSort lists the moderation queue oldest first
+var moderationQueueSort = reviewSort{Column: "created_at", Time: true}
+
+// ReviewRequest represents the request to write or edit a review
+type ReviewRequest struct {
+	Rating  int     `json:"rating"`
+	Title   *string `json:"title"`
+	Content string  `json:"content"`
+}
+
+// ModerateReviewRequest represents a moderation decision on a review
+type ModerateReviewRequest struct {
+	Status db.ReviewStatus `json:"status"`
+	Note   *string         `json:"note"`
+}
+
+// ProductReviewsHandler lists the approved reviews of a product with its
+// rating summary. sort is newest (default) or helpful; rating filters to
+// one star rating.
+func ProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	product, err := findProduct(db.DB, r.PathValue("product"))
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to fetch reviews")
+		return
+	}
+
+	sortName := r.URL.Query().Get("sort")
+	if sortName == "" {
+		sortName = "newest"
+	}
+	sort, ok := reviewSorts[sortName]
+	if !ok {
+		lib.RespondWithError(w, http.StatusBadRequest, "Unknown sort "+strconv.Quote(sortName))
+		return
+	}
+
+	// Only show reviewers' names, not their accounts
+	query := db.DB.Preload("User", func(tx *gorm.DB) *gorm.DB {
+		return tx.Select("_id", "name", "image")
+	}).Where("\"productId\" = ? AND status = ?", product.ID, db.ReviewStatusApproved)
+	if raw := r.URL.Query().Get("rating"); raw != "" {
+		rating, err := strconv.Atoi(raw)
+		if err != nil || rating < 1 || rating > 5 {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid rating")
+			return
+		}
+		query = query.Where("rating = ?", rating)
+	}
+
+	reviews, pagination, err := listReviews(r, query, sortName, sort)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to fetch reviews")
+		return
+	}
+
+	summary, err := loadRatingSummary(db.DB, product.ID)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch reviews")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+		"reviews":    reviews,
+		"summary":    summary,
+		"pagination": pagination,
+	})
+}
+
+// CreateReviewHandler adds the caller's review of a product. It is held for
+// moderation and marked verified when the caller received the product.
+func CreateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var review db.Review
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		product, err := findProduct(tx, r.PathValue("product"))
+		if err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&db.Review{}).
+			Where("\"userId\" = ? AND \"productId\" = ?", userID, product.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return &apiError{http.StatusConflict, "You have already reviewed this product"}
+		}
+
+		verified, err := hasReceivedProduct(tx, userID, product.ID)
+		if err != nil {
+			return err
+		}
+
+		review = db.Review{
+			UserID:     userID,
+			ProductID:  product.ID,
+			IsVerified: verified,
+			Status:     db.ReviewStatusPending,
+		}
+		if err := req.applyTo(&review); err != nil {
+			return err
+		}
+		return conflictOnDuplicate(tx.Create(&review).Error, "You have already reviewed this product")
+	})
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to save review")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusCreated, review)
+}
+
+// MyReviewsHandler lists the caller's reviews in every moderation status
+func MyReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	reviews, pagination, err := listReviews(r, db.DB.Where("\"userId\" = ?", userID), "newest", reviewSorts["newest"])
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to fetch reviews")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+		"reviews":    reviews,
+		"pagination": pagination,
+	})
+}
+
+// ReviewHandler edits or deletes a review. Only its author may edit it, which
+// sends it back for moderation; moderators may also delete it.
+func ReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userIDStr, _, role, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+	userID, err := uuid.FromString(userIDStr)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	reviewID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		var req ReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		var review db.Review
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := lockReview(tx, reviewID, &review); err != nil {
+				return err
+			}
+			if review.UserID != userID {
+				return &apiError{http.StatusForbidden, "You can only edit your own reviews"}
+			}
+			if err := req.applyTo(&review); err != nil {
+				return err
+			}
+
+			verified, err := hasReceivedProduct(tx, userID, review.ProductID)
+			if err != nil {
+				return err
+			}
+			review.IsVerified = verified
+			review.Status = db.ReviewStatusPending
+
+			return tx.Model(&review).Select("rating", "title", "content", "is_verified", "status", "updated_at").
+				Updates(&review).Error
+		})
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save review")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, review)
+
+	case "DELETE":
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var review db.Review
+			if err := lockReview(tx, reviewID, &review); err != nil {
+				return err
+			}
+			if review.UserID != userID && !db.Role(role).HasPermission(db.PermissionReviewsModerate) {
+				return &apiError{http.StatusForbidden, "You can only delete your own reviews"}
+			}
+
+			if err := tx.Where("\"reviewId\" = ?", review.ID).Delete(&db.ReviewVote{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&review).Error
+		})
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to delete review")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Review deleted"})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ReviewHelpfulHandler records (POST) or withdraws (DELETE) the caller's
+// helpful vote on an approved review. Voting twice counts once.
+func ReviewHelpfulHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" && r.Method != "DELETE" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	reviewID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	var review db.Review
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReview(tx, reviewID, &review); err != nil {
+			return err
+		}
+		if review.Status != db.ReviewStatusApproved {
+			return &apiError{http.StatusNotFound, "Review not found"}
+		}
+		if review.UserID == userID {
+			return &apiError{http.StatusBadRequest, "You cannot vote on your own review"}
+		}
+
+		var result *gorm.DB
+		delta := 1
+		if r.Method == "POST" {
+			result = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&db.ReviewVote{ReviewID: review.ID, UserID: userID})
+		} else {
+			result = tx.Where("\"reviewId\" = ? AND \"userId\" = ?", review.ID, userID).Delete(&db.ReviewVote{})
+			delta = -1
+		}
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		review.HelpfulCount += delta
+		return tx.Model(&review).UpdateColumn("helpful_count", gorm.Expr("helpful_count + ?", delta)).Error
+	})
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to record vote")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]int{"helpfulCount": review.HelpfulCount})
+}
+
+// AdminReviewsHandler lists reviews awaiting moderation, oldest first. status
+// selects another queue (APPROVED, REJECTED or HIDDEN).
+func AdminReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	status := db.ReviewStatus(strings.ToUpper(r.URL.Query().Get("status")))
+	if status == "" {
+		status = db.ReviewStatusPending
+	}
+	if !status.IsValid() {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid review status")
+		return
+	}
+
+	query := db.DB.Preload("User").Preload("Product", func(tx *gorm.DB) *gorm.DB {
+		return tx.Unscoped()
+	}).Where("status = ?", status)
+
+	reviews, pagination, err := listReviews(r, query, "oldest", moderationQueueSort)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to fetch reviews")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+		"reviews":    reviews,
+		"pagination": pagination,
+	})
+}
+
+// AdminReviewHandler approves, rejects or hides a review
+func AdminReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PATCH, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "PATCH" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	moderatorID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	reviewID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid review ID")
+		return
+	}
+
+	var req ModerateReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !req.Status.IsValid() || req.Status == db.ReviewStatusPending {
+		lib.RespondWithError(w, http.StatusBadRequest, "Status must be APPROVED, REJECTED or HIDDEN")
+		return
+	}
+
+	var review db.Review
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReview(tx, reviewID, &review); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		review.Status = req.Status
+		review.ModeratedAt = &now
+		review.ModeratedBy = &moderatorID
+		review.ModerationNote = req.Note
+		return tx.Model(&review).Select("status", "moderated_at", "moderatedBy", "moderation_note", "updated_at").
+			Updates(&review).Error
+	})
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to moderate review")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, review)
+}
+
+// applyTo validates the request and copies it onto a review
+func (req ReviewRequest) applyTo(review *db.Review) error {
+	if req.Rating < 1 || req.Rating > 5 {
+		return &apiError{http.StatusBadRequest, "Rating must be between 1 and 5"}
+	}
+
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		return &apiError{http.StatusBadRequest, "Review content is required"}
+	}
+	if utf8.RuneCountInString(content) > maxReviewContentLength {
+		return &apiError{http.StatusBadRequest, "Review content is too long"}
+	}
+
+	var title *string
+	if req.Title != nil {
+		if trimmed := strings.TrimSpace(*req.Title); trimmed != "" {
+			if utf8.RuneCountInString(trimmed) > maxReviewTitleLength {
+				return &apiError{http.StatusBadRequest, "Review title is too long"}
+			}
+			title = &trimmed
+		}
+	}
+
+	review.Rating = req.Rating
+	review.Title = title
+	review.Content = content
+	return nil
+}
+
+// listReviews returns one cursor page of the reviews matched by query
+func listReviews(r *http.Request, query *gorm.DB, sortName string, sort reviewSort) ([]db.Review, lib.CursorPagination, error) {
+	limit, after, err := lib.ParseCursorParams(r, sortName)
+	if err != nil {
+		return nil, lib.CursorPagination{}, &apiError{http.StatusBadRequest, "Invalid cursor"}
+	}
+
+	direction := " ASC"
+	if sort.Desc {
+		direction = " DESC"
+	}
+	if condition, args := lib.KeysetCondition(sort.Column, "_id", sort.Desc, after); condition != "" {
+		query = query.Where(condition, args...)
+	}
+
+	var reviews []db.Review
+	if err := query.Order(sort.Column + direction + ", _id" + direction).Limit(limit + 1).Find(&reviews).Error; err != nil {
+		return nil, lib.CursorPagination{}, err
+	}
+
+	fetched := len(reviews)
+	var next lib.Cursor
+	if fetched > limit {
+		reviews = reviews[:limit]
+		last := reviews[limit-1]
+		next = lib.Cursor{Sort: sortName, ID: last.ID.String()}
+		if sort.Time {
+			next.Time = &last.CreatedAt
+		} else {
+			helpful := float64(last.HelpfulCount)
+			next.Number = &helpful
+		}
+	}
+
+	if reviews == nil {
+		reviews = []db.Review{}
+	}
+	pagination, err := lib.NewCursorPagination(limit, fetched, next)
+	return reviews, pagination, err
+}
+
+// findProduct finds an active product by ID or slug
+func findProduct(tx *gorm.DB, idOrSlug string) (db.Product, error) {
+	var product db.Product
+	query := tx.Where("slug = ?", idOrSlug)
+	if id, err := uuid.FromString(idOrSlug); err == nil {
+		query = tx.Where("_id = ?", id)
+	}
+	if err := query.First(&product).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return product, &apiError{http.StatusNotFound, "Product not found"}
+		}
+		return product, err
+	}
+	return product, nil
+}
+
+// lockReview loads a review for update
+func lockReview(tx *gorm.DB, reviewID uuid.UUID, review *db.Review) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(review, "_id = ?", reviewID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &apiError{http.StatusNotFound, "Review not found"}
+		}
+		return err
+	}
+	return nil
+}
+
+// hasReceivedProduct reports whether the user has a delivered order containing the product
+func hasReceivedProduct(tx *gorm.DB, userID, productID uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Model(&db.OrderItem{}).
+		Joins("JOIN orders ON orders._id = order_items.\"orderId\"").
+		Where("orders.\"userId\" = ? AND orders.status = ? AND order_items.\"productId\" = ?",
+			userID, db.OrderStatusDelivered, productID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// verifyDeliveredReviews marks the user's reviews of delivered items as
+// verified purchases
+func verifyDeliveredReviews(tx *gorm.DB, userID uuid.UUID, items []db.OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	productIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		productIDs[i] = item.ProductID
+	}
+	return tx.Model(&db.Review{}).
+		Where("\"userId\" = ? AND \"productId\" IN ? AND is_verified = ?", userID, productIDs, false).
+		Update("is_verified", true).Error
+}
+
+// loadRatingSummary aggregates the approved reviews of a product
+func loadRatingSummary(tx *gorm.DB, productID uuid.UUID) (*db.RatingSummary, error) {
+	var rows []struct {
+		Rating int
+		Count  int64
+	}
+	if err := tx.Model(&db.Review{}).Select("rating, COUNT(*) AS count").
+		Where("\"productId\" = ? AND status = ?", productID, db.ReviewStatusApproved).
+		Group("rating").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &db.RatingSummary{}
+	var sum int64
+	for _, row := range rows {
+		if row.Rating < 1 || row.Rating > 5 {
+			continue
+		}
+		summary.Histogram[row.Rating-1] = row.Count
+		summary.Count += row.Count
+		sum += int64(row.Rating) * row.Count
+	}
+	if summary.Count > 0 {
+		summary.Average = float64(sum) / float64(summary.Count)
+	}
+	return summary, nil
+}
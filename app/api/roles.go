@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RoleResponse describes a role and the permissions it grants
+type RoleResponse struct {
+	Role        db.Role         `json:"role"`
+	Permissions []db.Permission `json:"permissions"`
+}
+
+// AssignRoleRequest represents the request to change a user's role
+type AssignRoleRequest struct {
+	Role db.Role `json:"role"`
+}
+
+// RolesHandler lists the roles and their permissions
+func RolesHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	roles := make([]RoleResponse, 0, len(db.AllRoles))
+	for _, role := range db.AllRoles {
+		roles = append(roles, RoleResponse{Role: role, Permissions: role.Permissions()})
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, roles)
+}
+
+// UserRoleHandler assigns a role to a user
+func UserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "PUT" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	adminIDStr, _, _, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	userID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	// Admins cannot lock themselves out
+	if userID.String() == adminIDStr {
+		lib.RespondWithError(w, http.StatusBadRequest, "You cannot change your own role")
+		return
+	}
+
+	var req AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !req.Role.IsValid() {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	user, err := assignRole(userID, req.Role)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to assign role")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, user)
+}
+
+// assignRole changes a user's role and signs them out everywhere so tokens
+// carrying the old role stop working immediately
+func assignRole(userID uuid.UUID, role db.Role) (*db.User, error) {
+	var user db.User
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "_id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "User not found"}
+			}
+			return err
+		}
+
+		if user.Role == role {
+			return nil
+		}
+
+		// Keep at least one admin around; locking every admin row stops two
+		// admins from demoting each other at the same time
+		if user.Role == db.RoleAdmin {
+			var admins []db.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("_id").
+				Where("role = ?", db.RoleAdmin).Find(&admins).Error; err != nil {
+				return err
+			}
+			if len(admins) <= 1 {
+				return &apiError{http.StatusConflict, "Cannot remove the last admin"}
+			}
+		}
+
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
+		}
+
+		return revokeSessions(tx.Where("\"userId\" = ?", user.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
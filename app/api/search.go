@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+)
+
+// maxSearchTerms caps how many words of a query are searched for
+const maxSearchTerms = 8
+
+// searchTermPattern matches the words of a search query
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchSQL ranks matching products for one page of results. @prefix is a
+// tsquery with every term prefix-matched; it is parsed with both the english
+// configuration (stemmed names and descriptions) and the simple one (SKUs).
+// With @fuzzy set, names within trigram distance of @raw also match.
+const searchSQL = `WITH q AS (
+	SELECT to_tsquery('english', @prefix) || to_tsquery('simple', @prefix) AS query
+)
+SELECT p._id AS id,
+	ts_rank_cd(p.search_vector, q.query) + CASE WHEN @fuzzy THEN similarity(p.name, @raw) ELSE 0 END AS rank,
+	ts_headline('english', p.name, q.query, 'HighlightAll=true, StartSel=<mark>, StopSel=</mark>') AS highlight,
+	ts_headline('english', p.description, q.query, 'StartSel=<mark>, StopSel=</mark>, MinWords=10, MaxWords=30, MaxFragments=2') AS snippet,
+	COUNT(*) OVER () AS total
+FROM products p, q
+WHERE p.deleted_at IS NULL
+	AND (p.search_vector @@ q.query OR (@fuzzy AND p.name % @raw))
+ORDER BY rank DESC, p.name ASC
+LIMIT @limit OFFSET @offset`
+
+// correctTermsSQL replaces each word of @terms (space separated) with the
+// closest catalog word, keeping words with no close match. The catalog words
+// are collected once for all the terms.
+const correctTermsSQL = `WITH words AS MATERIALIZED (
+	SELECT word, nentry FROM ts_stat(
+		'SELECT to_tsvector(''simple'', name || '' '' || description) FROM products WHERE deleted_at IS NULL'
+	)
+)
+SELECT COALESCE(best.word, t.term) AS word
+FROM unnest(string_to_array(@terms, ' ')) WITH ORDINALITY AS t(term, n)
+LEFT JOIN LATERAL (
+	SELECT word FROM words
+	WHERE similarity(word, t.term) >= 0.3
+	ORDER BY similarity(word, t.term) DESC, nentry DESC
+	LIMIT 1
+) best ON true
+ORDER BY t.n`
+
+// SearchResult is a product matched by a search
+type SearchResult struct {
+	db.Product
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+	Snippet   string  `json:"snippet"`
+}
+
+// searchRow is one ranked row of searchSQL
+type searchRow struct {
+	ID        uuid.UUID
+	Rank      float64
+	Highlight string
+	Snippet   string
+	Total     int64
+}
+
+// SearchHandler handles full-text product search
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only allow GET requests
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	page, pageSize := lib.ParsePaginationParams(r)
+
+	rows, err := runSearch(terms, query, false, page, pageSize)
+	if err != nil {
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	// Nothing matched: retry with misspelt words corrected and fuzzy name matching
+	var suggestion string
+	if len(rows) == 0 && page == 1 {
+		corrected, err := correctSearchTerms(terms)
+		if err != nil {
+			http.Error(w, "Failed to search products", http.StatusInternalServerError)
+			return
+		}
+
+		rows, err = runSearch(corrected, query, true, page, pageSize)
+		if err != nil {
+			http.Error(w, "Failed to search products", http.StatusInternalServerError)
+			return
+		}
+
+		if len(rows) > 0 && strings.Join(corrected, " ") != strings.Join(terms, " ") {
+			suggestion = strings.Join(corrected, " ")
+		}
+	}
+
+	results, err := loadSearchResults(rows)
+	if err != nil {
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	var total int64
+	if len(rows) > 0 {
+		total = rows[0].Total
+	}
+
+	// Prepare response
+	response := map[string]interface{}{
+		"query":      query,
+		"suggestion": suggestion,
+		"products":   results,
+		"pagination": lib.Pagination(total, page, pageSize),
+	}
+
+	// Set content type
+	w.Header().Set("Content-Type", "application/json")
+
+	// Return results
+	json.NewEncoder(w).Encode(response)
+}
+
+// searchTerms splits a query into lowercase words
+func searchTerms(query string) []string {
+	terms := searchTermPattern.FindAllString(strings.ToLower(query), -1)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+// runSearch returns one page of ranked matches for all terms, each matched as a prefix
+func runSearch(terms []string, raw string, fuzzy bool, page, pageSize int) ([]searchRow, error) {
+	prefixed := make([]string, len(terms))
+	for i, term := range terms {
+		prefixed[i] = term + ":*"
+	}
+
+	var rows []searchRow
+	err := db.DB.Raw(searchSQL, map[string]interface{}{
+		"prefix": strings.Join(prefixed, " & "),
+		"raw":    raw,
+		"fuzzy":  fuzzy,
+		"limit":  pageSize,
+		"offset": (page - 1) * pageSize,
+	}).Scan(&rows).Error
+	return rows, err
+}
+
+// correctSearchTerms replaces each term with the closest word in the catalog,
+// keeping terms that have no close match
+func correctSearchTerms(terms []string) ([]string, error) {
+	var corrected []string
+	err := db.DB.Raw(correctTermsSQL, map[string]interface{}{"terms": strings.Join(terms, " ")}).Scan(&corrected).Error
+	return corrected, err
+}
+
+// loadSearchResults loads the products of ranked rows, keeping their order
+func loadSearchResults(rows []searchRow) ([]SearchResult, error) {
+	results := make([]SearchResult, 0, len(rows))
+	if len(rows) == 0 {
+		return results, nil
+	}
+
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+
+	var products []db.Product
+	if err := db.DB.Preload("Images").Preload("Category").Where("_id IN ?", ids).Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]db.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	index, err := loadCategoryIndex(db.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		product, ok := byID[row.ID]
+		if !ok {
+			continue
+		}
+		product.Breadcrumbs = index.Breadcrumbs(product.CategoryID)
+		results = append(results, SearchResult{
+			Product:   product,
+			Rank:      row.Rank,
+			Highlight: row.Highlight,
+			Snippet:   row.Snippet,
+		})
+	}
+	return results, nil
+}
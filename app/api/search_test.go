@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"beauty-shop/app/api/db"
+)
+
+// searchResponse is the body of a SearchHandler response
+type searchResponse struct {
+	Suggestion string         `json:"suggestion"`
+	Products   []SearchResult `json:"products"`
+}
+
+// search runs SearchHandler for query
+func search(t *testing.T, query string) searchResponse {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	SearchHandler(rec, httptest.NewRequest(http.MethodGet, "/products/search?q="+url.QueryEscape(query), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search %q: status code = %d: %s", query, rec.Code, rec.Body)
+	}
+
+	var response searchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode search %q: %v", query, err)
+	}
+	return response
+}
+
+func TestSearch(t *testing.T) {
+	openTestDB(t)
+
+	sku := "HA-SERUM-30"
+	category := createTestCategory(t, "Serums")
+	createTestProduct(t, category, db.Product{Name: "Hyaluronic Acid Serum", SKU: &sku, Description: "A lightweight serum that draws moisture into the skin", Price: 2500, StockQuantity: 10})
+	createTestProduct(t, category, db.Product{Name: "Hydrating Cleanser", Description: "A gentle cleanser with hyaluronic acid and glycerin", Price: 1200, StockQuantity: 10})
+	createTestProduct(t, category, db.Product{Name: "Vitamin C Serum", Description: "A brightening serum for dull skin", Price: 3000, StockQuantity: 10})
+
+	names := func(response searchResponse) []string {
+		names := make([]string, len(response.Products))
+		for i, product := range response.Products {
+			names[i] = product.Name
+		}
+		return names
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		want       []string
+		suggestion string
+	}{
+		{"name matches rank above descriptions", "hyaluronic", []string{"Hyaluronic Acid Serum", "Hydrating Cleanser"}, ""},
+		{"prefix type-ahead", "hyal", []string{"Hyaluronic Acid Serum", "Hydrating Cleanser"}, ""},
+		{"every word must match", "vitamin serum", []string{"Vitamin C Serum"}, ""},
+		{"SKU", "HA-SERUM-30", []string{"Hyaluronic Acid Serum"}, ""},
+		{"misspelling falls back to the corrected word", "hyaluronc", []string{"Hyaluronic Acid Serum", "Hydrating Cleanser"}, "hyaluronic"},
+		{"nothing close", "zzzz", []string{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := search(t, tt.query)
+			got := names(response)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("results = %q, want %q", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("results = %q, want %q", got, tt.want)
+					break
+				}
+			}
+			if response.Suggestion != tt.suggestion {
+				t.Errorf("suggestion = %q, want %q", response.Suggestion, tt.suggestion)
+			}
+		})
+	}
+
+	if highlight := search(t, "hyal").Products[0].Highlight; highlight != "<mark>Hyaluronic</mark> Acid Serum" {
+		t.Errorf("highlight = %q", highlight)
+	}
+}
@@ -0,0 +1,468 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"beauty-shop/app/api/auth"
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refreshTokenTTL is how long a session survives without being refreshed
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// RefreshRequest represents a request carrying a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// SessionTokens is the token pair returned by login and refresh
+type SessionTokens struct {
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	RefreshToken string    `json:"refreshToken"`
+}
+
+var (
+	// errInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+	// errRefreshTokenReused is returned when an already rotated refresh token is presented again
+	errRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// RefreshHandler exchanges a refresh token for a new token pair
+func RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		lib.RespondWithError(w, http.StatusBadRequest, "Refresh token is required")
+		return
+	}
+
+	session, refreshToken, err := rotateSession(r, req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+			lib.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+			return
+		}
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to refresh session")
+		return
+	}
+
+	// Pick up role changes made since the session started
+	var user db.User
+	if err := db.DB.First(&user, "_id = ?", session.UserID).Error; err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+
+	tokens, err := issueSessionTokens(user, session.FamilyID, refreshToken)
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, tokens)
+}
+
+// LogoutHandler ends the session of the given refresh token, or of the bearer
+// access token when no refresh token is sent
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	var familyID uuid.UUID
+	if req.RefreshToken != "" {
+		var session db.Session
+		if err := db.DB.Where(&db.Session{TokenHash: lib.HashToken(req.RefreshToken)}).First(&session).Error; err == nil {
+			familyID = session.FamilyID
+		}
+	} else if tokenStr, err := auth.TokenFromRequest(r); err == nil {
+		if claims, err := auth.ParseAccessToken(tokenStr); err == nil {
+			familyID, _ = uuid.FromString(claims.SessionID)
+		}
+	}
+
+	// Logging out an unknown session is not an error
+	if familyID != uuid.Nil {
+		if err := revokeSessions(db.DB.Where("\"familyId\" = ?", familyID)); err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to log out")
+			return
+		}
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Logged out"})
+}
+
+// LogoutAllHandler ends every session of the authenticated user
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	if err := revokeSessions(db.DB.Where("\"userId\" = ?", userID)); err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Logged out of all devices"})
+}
+
+// SessionsHandler lists the authenticated user's active sessions
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	// The live token of each family stands for one signed-in device
+	var sessions []db.Session
+	if err := db.DB.Where("\"userId\" = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, sessions)
+}
+
+// SessionHandler revokes one of the authenticated user's sessions by family ID
+func SessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "DELETE" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	familyID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	if err := revokeSessions(db.DB.Where("\"userId\" = ? AND \"familyId\" = ?", userID, familyID)); err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+// AdminSessionHandler returns the signed-in staff member and their
+// permissions, or a null session for customers
+func AdminSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Get user info set by the auth middleware
+	userID, _, role, err := lib.GetUserFromContext(r.Context())
+	if err != nil || !db.Role(role).IsStaff() {
+		lib.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"session": nil})
+		return
+	}
+
+	var user db.User
+	if err := db.DB.First(&user, "_id = ?", userID).Error; err != nil {
+		lib.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"session": nil})
+		return
+	}
+
+	lib.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"session": map[string]interface{}{
+			"user":        user,
+			"permissions": user.Role.Permissions(),
+		},
+	})
+}
+
+// startSession creates a new session family for a user and returns its ID
+// and first refresh token
+func startSession(r *http.Request, userID uuid.UUID) (uuid.UUID, string, error) {
+	familyID, err := uuid.NewV4()
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	plain, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	now := time.Now()
+	session := db.Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		TokenHash:  lib.HashToken(plain),
+		Device:     r.UserAgent(),
+		IPAddress:  clientIP(r),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+	if err := db.DB.Create(&session).Error; err != nil {
+		return uuid.Nil, "", err
+	}
+
+	return familyID, plain, nil
+}
+
+// rotateSession swaps a refresh token for a new one in the same family.
+// Presenting a token that was already rotated means it leaked, so the whole
+// family is revoked.
+func rotateSession(r *http.Request, plain string) (*db.Session, string, error) {
+	var next db.Session
+	var reusedFamily uuid.UUID
+
+	newPlain, err := lib.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var current db.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(&db.Session{TokenHash: lib.HashToken(plain)}).
+			First(&current).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidRefreshToken
+			}
+			return err
+		}
+
+		if current.RevokedAt != nil {
+			return errInvalidRefreshToken
+		}
+		if current.RotatedAt != nil {
+			reusedFamily = current.FamilyID
+			return errRefreshTokenReused
+		}
+
+		now := time.Now()
+		if now.After(current.ExpiresAt) {
+			return errInvalidRefreshToken
+		}
+
+		if err := tx.Model(&current).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+
+		next = db.Session{
+			UserID:     current.UserID,
+			FamilyID:   current.FamilyID,
+			TokenHash:  lib.HashToken(newPlain),
+			Device:     r.UserAgent(),
+			IPAddress:  clientIP(r),
+			LastUsedAt: now,
+			ExpiresAt:  now.Add(refreshTokenTTL),
+		}
+		return tx.Create(&next).Error
+	})
+
+	// Revoke outside the failed transaction so the revocation sticks
+	if errors.Is(err, errRefreshTokenReused) {
+		log.Printf("Refresh token reuse detected, revoking session %s", reusedFamily)
+		if revokeErr := revokeSessions(db.DB.Where("\"familyId\" = ?", reusedFamily)); revokeErr != nil {
+			return nil, "", revokeErr
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &next, newPlain, nil
+}
+
+// revokeSessions marks every not yet revoked session matched by scope as revoked
+func revokeSessions(scope *gorm.DB) error {
+	return scope.Model(&db.Session{}).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+}
+
+// issueSessionTokens signs an access token for the session and pairs it with its refresh token
+func issueSessionTokens(user db.User, familyID uuid.UUID, refreshToken string) (*SessionTokens, error) {
+	token, expiresAt, err := auth.IssueAccessToken(user.ID.String(), user.Email, string(user.Role), familyID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// sessionUserID returns the authenticated user's ID from the request context
+func sessionUserID(r *http.Request) (uuid.UUID, error) {
+	userIDStr, _, _, err := lib.GetUserFromContext(r.Context())
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.FromString(userIDStr)
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only believed
+// when the connection comes from a proxy listed in TRUSTED_PROXIES, and then
+// the client is the nearest hop that is not itself a trusted proxy.
+func clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	proxies := trustedProxies()
+	if !isTrustedProxy(proxies, remote) {
+		return remote
+	}
+
+	// Each proxy appends the address it was called from, so read right to left
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		if !isTrustedProxy(proxies, hop) {
+			return hop
+		}
+	}
+	return remote
+}
+
+// trustedProxies parses TRUSTED_PROXIES, a comma-separated list of IP
+// addresses and CIDR ranges, skipping entries that are neither
+func trustedProxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", entry)
+		}
+	}
+	return proxies
+}
+
+// isTrustedProxy reports whether ip is inside one of the trusted proxy ranges
+func isTrustedProxy(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
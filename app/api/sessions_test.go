@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"beauty-shop/app/api/auth"
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/middleware"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+)
+
+// useTestAuth signs and verifies access tokens with a test secret
+func useTestAuth(t *testing.T) {
+	t.Helper()
+
+	keys, err := auth.NewKeySet("test", auth.NewHMACKey("test", []byte("a session test secret")))
+	if err != nil {
+		t.Fatalf("key set: %v", err)
+	}
+	auth.SetDefault(auth.New(keys, auth.ConfigFromEnv()))
+	t.Cleanup(func() { auth.SetDefault(nil) })
+}
+
+// loginTestUser logs user in with password and returns the session tokens
+func loginTestUser(t *testing.T, user db.User, password string) SessionTokens {
+	t.Helper()
+
+	rec := postJSON(t, AuthHandler, LoginRequest{Email: user.Email, Password: password})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status code = %d: %s", rec.Code, rec.Body)
+	}
+
+	var response LoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode login: %v", err)
+	}
+	return response.SessionTokens
+}
+
+// refreshSession exchanges a refresh token and returns the status code and new tokens
+func refreshSession(t *testing.T, refreshToken string) (int, SessionTokens) {
+	t.Helper()
+
+	rec := postJSON(t, RefreshHandler, RefreshRequest{RefreshToken: refreshToken})
+
+	var response struct {
+		Data SessionTokens `json:"data"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("decode refresh: %v", err)
+		}
+	}
+	return rec.Code, response.Data
+}
+
+// authenticated calls handler behind AuthMiddleware with an access token
+func authenticated(handler http.HandlerFunc, method, accessToken string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rec := httptest.NewRecorder()
+	middleware.AuthMiddleware(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+// accessStatus is the status a protected endpoint answers an access token with
+func accessStatus(accessToken string) int {
+	return authenticated(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, http.MethodGet, accessToken, nil).Code
+}
+
+// createTestUserWithPassword creates a customer who can log in with password
+func createTestUserWithPassword(t *testing.T, email, password string) db.User {
+	t.Helper()
+
+	hash, err := lib.HashPassword(password)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := createTestUser(t, email)
+	if err := db.DB.Model(&user).Update("password", hash).Error; err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	return user
+}
+
+// liveSessions counts the sessions of a family that are not revoked
+func liveSessions(t *testing.T, familyID string) int64 {
+	t.Helper()
+
+	var live int64
+	if err := db.DB.Model(&db.Session{}).Where("\"familyId\" = ? AND revoked_at IS NULL", familyID).Count(&live).Error; err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	return live
+}
+
+// sessionFamily returns the session family an access token belongs to
+func sessionFamily(t *testing.T, accessToken string) string {
+	t.Helper()
+
+	claims, err := auth.ParseAccessToken(accessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	return claims.SessionID
+}
+
+func TestRefreshRotation(t *testing.T) {
+	openTestDB(t)
+	useTestAuth(t)
+
+	user := createTestUserWithPassword(t, "rotate@example.com", "rotate-password")
+	first := loginTestUser(t, user, "rotate-password")
+	other := loginTestUser(t, user, "rotate-password")
+
+	code, second := refreshSession(t, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: status code = %d", code)
+	}
+	if second.RefreshToken == first.RefreshToken || second.Token == "" {
+		t.Fatal("refresh did not rotate the token pair")
+	}
+	if family := sessionFamily(t, second.Token); family != sessionFamily(t, first.Token) {
+		t.Errorf("refreshed token is in session %s, want %s", family, sessionFamily(t, first.Token))
+	}
+	if code := accessStatus(second.Token); code != http.StatusOK {
+		t.Fatalf("refreshed access token: status code = %d, want 200", code)
+	}
+
+	code, third := refreshSession(t, second.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh: status code = %d", code)
+	}
+
+	// Presenting a rotated token again revokes the whole family
+	if code, _ := refreshSession(t, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: status code = %d, want 401", code)
+	}
+	if live := liveSessions(t, sessionFamily(t, first.Token)); live != 0 {
+		t.Errorf("%d sessions of the family are still live after reuse", live)
+	}
+	if code, _ := refreshSession(t, third.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("latest refresh token after reuse: status code = %d, want 401", code)
+	}
+	for name, token := range map[string]string{"first": first.Token, "second": second.Token, "third": third.Token} {
+		if code := accessStatus(token); code != http.StatusUnauthorized {
+			t.Errorf("%s access token of a revoked family: status code = %d, want 401", name, code)
+		}
+	}
+
+	// Other sign-ins of the same user are untouched
+	if code := accessStatus(other.Token); code != http.StatusOK {
+		t.Errorf("another session: status code = %d, want 200", code)
+	}
+	if code, _ := refreshSession(t, other.RefreshToken); code != http.StatusOK {
+		t.Errorf("another session's refresh: status code = %d, want 200", code)
+	}
+
+	if code, _ := refreshSession(t, "not-a-refresh-token"); code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: status code = %d, want 401", code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	openTestDB(t)
+	useTestAuth(t)
+
+	user := createTestUserWithPassword(t, "logout@example.com", "logout-password")
+	phone := loginTestUser(t, user, "logout-password")
+	laptop := loginTestUser(t, user, "logout-password")
+
+	// Logging out with a refresh token ends only that session
+	if rec := postJSON(t, LogoutHandler, RefreshRequest{RefreshToken: phone.RefreshToken}); rec.Code != http.StatusOK {
+		t.Fatalf("logout: status code = %d: %s", rec.Code, rec.Body)
+	}
+	if code := accessStatus(phone.Token); code != http.StatusUnauthorized {
+		t.Errorf("logged out access token: status code = %d, want 401", code)
+	}
+	if code, _ := refreshSession(t, phone.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("logged out refresh token: status code = %d, want 401", code)
+	}
+	if code := accessStatus(laptop.Token); code != http.StatusOK {
+		t.Errorf("other session after logout: status code = %d, want 200", code)
+	}
+
+	// Without a refresh token the bearer token's session ends
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+laptop.Token)
+	rec := httptest.NewRecorder()
+	LogoutHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout with a bearer token: status code = %d: %s", rec.Code, rec.Body)
+	}
+	if code := accessStatus(laptop.Token); code != http.StatusUnauthorized {
+		t.Errorf("access token after bearer logout: status code = %d, want 401", code)
+	}
+
+	// Logging out everywhere ends every session of the user
+	tablet := loginTestUser(t, user, "logout-password")
+	desktop := loginTestUser(t, user, "logout-password")
+	if rec := authenticated(LogoutAllHandler, http.MethodPost, tablet.Token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("logout-all: status code = %d: %s", rec.Code, rec.Body)
+	}
+	for name, tokens := range map[string]SessionTokens{"tablet": tablet, "desktop": desktop} {
+		if code := accessStatus(tokens.Token); code != http.StatusUnauthorized {
+			t.Errorf("%s access token after logout-all: status code = %d, want 401", name, code)
+		}
+		if code, _ := refreshSession(t, tokens.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("%s refresh token after logout-all: status code = %d, want 401", name, code)
+		}
+	}
+
+	// Logging out an unknown session is not an error
+	if rec := postJSON(t, LogoutHandler, RefreshRequest{RefreshToken: "not-a-refresh-token"}); rec.Code != http.StatusOK {
+		t.Errorf("logout of an unknown session: status code = %d, want 200", rec.Code)
+	}
+}
+
+func TestAuthMiddlewareRejectsUnknownSessions(t *testing.T) {
+	openTestDB(t)
+	useTestAuth(t)
+
+	user := createTestUser(t, "no-session@example.com")
+	token, _, err := auth.IssueAccessToken(user.ID.String(), user.Email, string(user.Role), uuid.Must(uuid.NewV4()).String())
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if code := accessStatus(token); code != http.StatusUnauthorized {
+		t.Errorf("token of a session that never existed: status code = %d, want 401", code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy", "", "198.51.100.4:52311", nil, "198.51.100.4"},
+		{"spoofed header without a trusted proxy", "", "198.51.100.4:52311", []string{"203.0.113.9"}, "198.51.100.4"},
+		{"header from an untrusted address", "10.0.0.0/8", "198.51.100.4:52311", []string{"203.0.113.9"}, "198.51.100.4"},
+		{"trusted proxy", "10.0.0.0/8", "10.1.2.3:443", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"client-supplied hops before the proxy's", "10.0.0.0/8", "10.1.2.3:443", []string{"192.0.2.1, 203.0.113.9"}, "203.0.113.9"},
+		{"chain of trusted proxies", "10.0.0.0/8, 172.16.0.7", "10.1.2.3:443", []string{"203.0.113.9, 172.16.0.7", "10.9.9.9"}, "203.0.113.9"},
+		{"single trusted address", "10.1.2.3", "10.1.2.3:443", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"trusted proxy without the header", "10.0.0.0/8", "10.1.2.3:443", nil, "10.1.2.3"},
+		{"only trusted hops", "10.0.0.0/8", "10.1.2.3:443", []string{"10.4.4.4"}, "10.1.2.3"},
+		{"garbage hop", "10.0.0.0/8", "10.1.2.3:443", []string{"203.0.113.9, <script>"}, "10.1.2.3"},
+		{"IPv6", "2001:db8::/32", "[2001:db8::1]:443", []string{"2a00:1450::200e, 2001:db8:ffff::1"}, "2a00:1450::200e"},
+		{"invalid entries are ignored", "proxy.internal, 10.0.0.0/8", "10.1.2.3:443", []string{"203.0.113.9"}, "203.0.113.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trusted)
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			req.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
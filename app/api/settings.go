@@ -1,21 +1,111 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"beauty-shop/api/db"
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/pricing"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// Handler handles HTTP requests for the root endpoint
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection
-	db.Initialize()
+// storeSettingsKey is the db.Settings row holding the store settings
+const storeSettingsKey = "store"
 
+// storeSettingsTTL bounds how stale the cache can get when another server
+// instance changes the settings
+const storeSettingsTTL = time.Minute
+
+// StoreSettings are the typed contents of the store settings row
+type StoreSettings struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Currency    string           `json:"currency"`
+	Address     string           `json:"address"`
+	Email       string           `json:"email"`
+	Phone       string           `json:"phone"`
+	Social      SocialLinks      `json:"social"`
+	Shipping    ShippingSettings `json:"shipping"`
+	Tax         TaxSettings      `json:"tax"`
+
+	// UpdatedAt versions the settings for optimistic concurrency
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SocialLinks are the store's social media pages
+type SocialLinks struct {
+	Facebook  string `json:"facebook,omitempty"`
+	Instagram string `json:"instagram,omitempty"`
+	Twitter   string `json:"twitter,omitempty"`
+}
+
+// ShippingSettings hold the flat shipping rate used without shipping zones
+type ShippingSettings struct {
+	FreeShippingThreshold int `json:"freeShippingThreshold"`
+	StandardShippingRate  int `json:"standardShippingRate"`
+}
+
+// TaxSettings hold the VAT rules
+type TaxSettings struct {
+	Rate     float64          `json:"rate"`
+	Mode     pricing.TaxMode  `json:"mode"`
+	Rounding pricing.Rounding `json:"rounding"`
+}
+
+// PublicStoreSettings is the part of the settings shown to shoppers
+type PublicStoreSettings struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Currency    string      `json:"currency"`
+	Address     string      `json:"address"`
+	Email       string      `json:"email"`
+	Phone       string      `json:"phone"`
+	Social      SocialLinks `json:"social"`
+}
+
+// defaultStoreSettings fill in anything the settings row leaves out
+var defaultStoreSettings = StoreSettings{
+	Name:     "Beauty Shop",
+	Currency: "KES",
+	Shipping: ShippingSettings{
+		FreeShippingThreshold: pricing.Defaults.FreeShippingThreshold,
+		StandardShippingRate:  pricing.Defaults.ShippingRate,
+	},
+	Tax: TaxSettings{
+		Rate:     pricing.Defaults.TaxRate,
+		Mode:     pricing.Defaults.TaxMode,
+		Rounding: pricing.Defaults.Rounding,
+	},
+}
+
+// storeSettingsCache keeps the settings between requests; writes through
+// saveStoreSettings replace it. saves counts those writes, so a load that
+// read the row before a save committed cannot put the old settings back.
+var storeSettingsCache struct {
+	sync.RWMutex
+	settings *StoreSettings
+	loadedAt time.Time
+	saves    uint64
+}
+
+// SettingsHandler serves the public store settings
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 	// Handle preflight requests
 	if r.Method == "OPTIONS" {
@@ -25,48 +115,311 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	// Only allow GET requests
 	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	settings, err := loadStoreSettings()
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch store settings")
+		return
+	}
+
+	etag := settings.ETag()
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	// Set content type
-	w.Header().Set("Content-Type", "text/html")
-
-	// Return a simple HTML response
-	fmt.Fprintf(w, `
-		<html>
-			<head>
-				<title>Beauty Shop API</title>
-				<style>
-					body {
-						font-family: Arial, sans-serif;
-						max-width: 800px;
-						margin: 0 auto;
-						padding: 20px;
-					}
-					h1 {
-						color: #d23669;
-					}
-					ul {
-						list-style-type: none;
-						padding: 0;
-					}
-					li {
-						margin-bottom: 10px;
-						padding: 10px;
-						background-color: #f5f5f5;
-						border-radius: 5px;
-					}
-					code {
-						background-color: #eee;
-						padding: 2px 5px;
-						border-radius: 3px;
-					}
-				</style>
-			</head>
-			<body>
-				<h1>Beauty Shop API</h1>
-				<p>Welcome to the Beauty Shop API. Here are the available endpoints:</p>
-				<ul>
-					<li><code>GET /api</code> - This documentation</li>
+	lib.RespondWithSuccess(w, http.StatusOK, settings.Public())
+}
 
+// AdminSettingsHandler reads (GET) or replaces (PUT) the store settings. PUT
+// must send the ETag from GET in If-Match, or the updatedAt it read, and fails
+// with 412 when the settings changed in the meantime.
+func AdminSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		settings, err := loadStoreSettings()
+		if err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch store settings")
+			return
+		}
+
+		w.Header().Set("ETag", settings.ETag())
+		lib.RespondWithSuccess(w, http.StatusOK, settings)
+
+	case "PUT":
+		var req StoreSettings
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" && req.UpdatedAt.IsZero() {
+			lib.RespondWithError(w, http.StatusPreconditionRequired, "Send the settings version in If-Match or updatedAt")
+			return
+		}
+
+		settings, err := saveStoreSettings(req, ifMatch)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save store settings")
+			return
+		}
+
+		w.Header().Set("ETag", settings.ETag())
+		lib.RespondWithSuccess(w, http.StatusOK, settings)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ETag identifies this version of the settings
+func (s StoreSettings) ETag() string {
+	return `"` + strconv.FormatInt(s.UpdatedAt.UnixNano(), 36) + `"`
+}
+
+// Public returns the settings shown to shoppers
+func (s StoreSettings) Public() PublicStoreSettings {
+	return PublicStoreSettings{
+		Name:        s.Name,
+		Description: s.Description,
+		Currency:    s.Currency,
+		Address:     s.Address,
+		Email:       s.Email,
+		Phone:       s.Phone,
+		Social:      s.Social,
+	}
+}
+
+// Pricing returns the tax and shipping rules for the pricing package
+func (s StoreSettings) Pricing() pricing.Settings {
+	return pricing.Settings{
+		TaxRate:               s.Tax.Rate,
+		TaxMode:               s.Tax.Mode,
+		Rounding:              s.Tax.Rounding,
+		ShippingRate:          s.Shipping.StandardShippingRate,
+		FreeShippingThreshold: s.Shipping.FreeShippingThreshold,
+	}
+}
+
+// normalize trims the text fields and upper-cases the codes
+func (s *StoreSettings) normalize() {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Description = strings.TrimSpace(s.Description)
+	s.Currency = strings.ToUpper(strings.TrimSpace(s.Currency))
+	s.Address = strings.TrimSpace(s.Address)
+	s.Email = strings.TrimSpace(s.Email)
+	s.Phone = strings.TrimSpace(s.Phone)
+	s.Social.Facebook = strings.TrimSpace(s.Social.Facebook)
+	s.Social.Instagram = strings.TrimSpace(s.Social.Instagram)
+	s.Social.Twitter = strings.TrimSpace(s.Social.Twitter)
+	s.Tax.Mode = pricing.TaxMode(strings.ToUpper(string(s.Tax.Mode)))
+	s.Tax.Rounding = pricing.Rounding(strings.ToUpper(string(s.Tax.Rounding)))
+}
+
+// validate checks the settings an admin submits
+func (s StoreSettings) validate() error {
+	switch {
+	case s.Name == "":
+		return &apiError{http.StatusBadRequest, "Store name is required"}
+	case len(s.Currency) != 3 || strings.Trim(s.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "":
+		return &apiError{http.StatusBadRequest, "Currency must be a three-letter code such as KES"}
+	}
+
+	if s.Email != "" {
+		if _, err := mail.ParseAddress(s.Email); err != nil {
+			return &apiError{http.StatusBadRequest, "Invalid store email"}
+		}
+	}
+
+	for name, link := range map[string]string{
+		"Facebook":  s.Social.Facebook,
+		"Instagram": s.Social.Instagram,
+		"Twitter":   s.Social.Twitter,
+	} {
+		if link == "" {
+			continue
+		}
+		if u, err := url.Parse(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("%s link must be an http or https URL", name)}
+		}
+	}
+
+	if err := s.Pricing().Validate(); err != nil {
+		return &apiError{http.StatusBadRequest, "Invalid settings: " + err.Error()}
+	}
+	return nil
+}
+
+// loadStoreSettings returns the store settings, from the cache while it is fresh
+func loadStoreSettings() (StoreSettings, error) {
+	storeSettingsCache.RLock()
+	cached, loadedAt, saves := storeSettingsCache.settings, storeSettingsCache.loadedAt, storeSettingsCache.saves
+	storeSettingsCache.RUnlock()
+	if cached != nil && time.Since(loadedAt) < storeSettingsTTL {
+		return *cached, nil
+	}
+
+	var row db.Settings
+	err := db.DB.Where("key = ?", storeSettingsKey).First(&row).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultStoreSettings, err
+	}
+
+	settings, err := decodeStoreSettings(row)
+	if err != nil {
+		return defaultStoreSettings, err
+	}
+
+	cacheLoadedStoreSettings(settings, saves)
+	return settings, nil
+}
+
+// decodeStoreSettings reads a settings row over the defaults, ignoring values
+// that are out of range
+func decodeStoreSettings(row db.Settings) (StoreSettings, error) {
+	settings := defaultStoreSettings
+	if row.Value != nil {
+		raw, err := json.Marshal(row.Value)
+		if err != nil {
+			return settings, err
+		}
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return settings, err
+		}
+	}
+	settings.normalize()
+	settings.UpdatedAt = row.UpdatedAt
+
+	if !settings.Tax.Mode.IsValid() {
+		settings.Tax.Mode = defaultStoreSettings.Tax.Mode
+	}
+	if !settings.Tax.Rounding.IsValid() {
+		settings.Tax.Rounding = defaultStoreSettings.Tax.Rounding
+	}
+	if settings.Tax.Rate < 0 || settings.Tax.Rate > 100 {
+		settings.Tax.Rate = defaultStoreSettings.Tax.Rate
+	}
+	if settings.Shipping.StandardShippingRate < 0 {
+		settings.Shipping.StandardShippingRate = defaultStoreSettings.Shipping.StandardShippingRate
+	}
+	if settings.Shipping.FreeShippingThreshold < 0 {
+		settings.Shipping.FreeShippingThreshold = defaultStoreSettings.Shipping.FreeShippingThreshold
+	}
+	return settings, nil
+}
+
+// saveStoreSettings replaces the store settings if they are still at the
+// version the caller read. Keys the typed settings do not know are kept.
+func saveStoreSettings(req StoreSettings, ifMatch string) (StoreSettings, error) {
+	req.normalize()
+	if err := req.validate(); err != nil {
+		return req, err
+	}
+
+	var saved StoreSettings
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var row db.Settings
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", storeSettingsKey).First(&row).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found := err == nil
+
+		current, err := decodeStoreSettings(row)
+		if err != nil {
+			return err
+		}
+		if (ifMatch != "" && ifMatch != "*" && ifMatch != current.ETag()) ||
+			(ifMatch == "" && !req.UpdatedAt.Equal(current.UpdatedAt)) {
+			return &apiError{http.StatusPreconditionFailed, "The settings were changed by someone else; reload and try again"}
+		}
+
+		raw, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		var value db.JSON
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		delete(value, "updatedAt")
+		for key, existing := range row.Value {
+			if _, ok := value[key]; !ok {
+				value[key] = existing
+			}
+		}
+
+		// Postgres keeps timestamps to the microsecond, so the ETag must too
+		now := time.Now().Truncate(time.Microsecond)
+		if !found {
+			id, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+			row = db.Settings{ID: id.String(), Key: storeSettingsKey, Value: value, CreatedAt: now, UpdatedAt: now}
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Model(&db.Settings{}).Where("key = ?", storeSettingsKey).
+				Updates(map[string]interface{}{"value": value, "updated_at": now}).Error; err != nil {
+				return err
+			}
+			row.Value, row.UpdatedAt = value, now
+		}
+
+		saved, err = decodeStoreSettings(row)
+		return err
+	})
+	if err != nil {
+		return req, err
+	}
+
+	cacheSavedStoreSettings(saved)
+	return saved, nil
+}
+
+// cacheLoadedStoreSettings caches settings read from the database, unless a
+// save has replaced the cache since the read began
+func cacheLoadedStoreSettings(settings StoreSettings, saves uint64) {
+	storeSettingsCache.Lock()
+	defer storeSettingsCache.Unlock()
+
+	if storeSettingsCache.saves != saves {
+		return
+	}
+	storeSettingsCache.settings = &settings
+	storeSettingsCache.loadedAt = time.Now()
+}
+
+// cacheSavedStoreSettings caches settings that were just saved, unless a
+// later save has already cached a newer version
+func cacheSavedStoreSettings(settings StoreSettings) {
+	storeSettingsCache.Lock()
+	defer storeSettingsCache.Unlock()
+
+	storeSettingsCache.saves++
+	if cached := storeSettingsCache.settings; cached != nil && settings.UpdatedAt.Before(cached.UpdatedAt) {
+		return
+	}
+	storeSettingsCache.settings = &settings
+	storeSettingsCache.loadedAt = time.Now()
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"beauty-shop/app/api/db"
+)
+
+// forgetStoreSettings empties the settings cache, which outlives the
+// database of any one test
+func forgetStoreSettings() {
+	storeSettingsCache.Lock()
+	storeSettingsCache.settings = nil
+	storeSettingsCache.loadedAt = time.Time{}
+	storeSettingsCache.Unlock()
+}
+
+// putSettings sends settings to AdminSettingsHandler with an optional If-Match
+func putSettings(t *testing.T, ifMatch string, settings StoreSettings) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatalf("encode settings: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/admin/settings", bytes.NewReader(payload))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	AdminSettingsHandler(rec, req)
+	return rec
+}
+
+// getSettings reads the settings through AdminSettingsHandler
+func getSettings(t *testing.T) (StoreSettings, string) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	AdminSettingsHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/settings", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get settings: status code = %d: %s", rec.Code, rec.Body)
+	}
+
+	var response struct {
+		Data StoreSettings `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decode settings: %v", err)
+	}
+	return response.Data, rec.Header().Get("ETag")
+}
+
+func TestAdminSettingsPreconditions(t *testing.T) {
+	openTestDB(t)
+
+	settings, etag := getSettings(t)
+	if settings.Name != defaultStoreSettings.Name || etag == "" {
+		t.Fatalf("initial settings = %q with ETag %q, want the defaults", settings.Name, etag)
+	}
+
+	// Neither If-Match nor updatedAt
+	settings.Name = "Glow Kenya"
+	unversioned := settings
+	unversioned.UpdatedAt = time.Time{}
+	if rec := putSettings(t, "", unversioned); rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("put without a version: status code = %d, want 428", rec.Code)
+	}
+
+	rec := putSettings(t, etag, settings)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put with the current ETag: status code = %d: %s", rec.Code, rec.Body)
+	}
+	newETag := rec.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("ETag after a save = %q, want a new one", newETag)
+	}
+	if saved, current := getSettings(t); saved.Name != "Glow Kenya" || current != newETag {
+		t.Errorf("settings after a save = %q with ETag %q, want Glow Kenya with %q", saved.Name, current, newETag)
+	}
+
+	// Both the ETag and the updatedAt read before the save are now stale
+	if rec := putSettings(t, etag, settings); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("put with a stale ETag: status code = %d, want 412", rec.Code)
+	}
+	if rec := putSettings(t, "", settings); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("put with a stale updatedAt: status code = %d, want 412", rec.Code)
+	}
+
+	current, _ := getSettings(t)
+	current.Phone = "+254712345678"
+	if rec := putSettings(t, "", current); rec.Code != http.StatusOK {
+		t.Errorf("put with the current updatedAt: status code = %d: %s", rec.Code, rec.Body)
+	}
+	current.Phone = "+254700000000"
+	if rec := putSettings(t, "*", current); rec.Code != http.StatusOK {
+		t.Errorf("put with If-Match *: status code = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdminSettingsValidation(t *testing.T) {
+	openTestDB(t)
+
+	valid, _ := getSettings(t)
+	tests := []struct {
+		name   string
+		change func(*StoreSettings)
+	}{
+		{"no name", func(s *StoreSettings) { s.Name = "  " }},
+		{"two-letter currency", func(s *StoreSettings) { s.Currency = "KE" }},
+		{"currency with digits", func(s *StoreSettings) { s.Currency = "K3S" }},
+		{"invalid email", func(s *StoreSettings) { s.Email = "not an email" }},
+		{"link without a scheme", func(s *StoreSettings) { s.Social.Instagram = "instagram.com/glow" }},
+		{"javascript link", func(s *StoreSettings) { s.Social.Twitter = "javascript:alert(1)" }},
+		{"tax rate above 100", func(s *StoreSettings) { s.Tax.Rate = 160 }},
+		{"unknown tax mode", func(s *StoreSettings) { s.Tax.Mode = "SOMETIMES" }},
+		{"unknown rounding", func(s *StoreSettings) { s.Tax.Rounding = "SIDEWAYS" }},
+		{"negative shipping rate", func(s *StoreSettings) { s.Shipping.StandardShippingRate = -1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := valid
+			tt.change(&settings)
+			if rec := putSettings(t, "*", settings); rec.Code != http.StatusBadRequest {
+				t.Errorf("status code = %d, want 400", rec.Code)
+			}
+		})
+	}
+
+	var saved int64
+	if err := db.DB.Model(&db.Settings{}).Where("key = ?", storeSettingsKey).Count(&saved).Error; err != nil {
+		t.Fatalf("count settings: %v", err)
+	}
+	if saved != 0 {
+		t.Error("invalid settings were saved")
+	}
+
+	valid.Currency = " usd "
+	valid.Tax.Mode = "inclusive"
+	rec := putSettings(t, "*", valid)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put normalised settings: status code = %d: %s", rec.Code, rec.Body)
+	}
+	if settings, _ := getSettings(t); settings.Currency != "USD" || settings.Tax.Mode != "INCLUSIVE" {
+		t.Errorf("saved currency %q and tax mode %q, want USD and INCLUSIVE", settings.Currency, settings.Tax.Mode)
+	}
+}
+
+func TestStoreSettingsCacheKeepsTheNewestVersion(t *testing.T) {
+	forgetStoreSettings()
+	t.Cleanup(forgetStoreSettings)
+
+	cached := func() StoreSettings {
+		storeSettingsCache.RLock()
+		defer storeSettingsCache.RUnlock()
+		return *storeSettingsCache.settings
+	}
+
+	older := defaultStoreSettings
+	older.Name, older.UpdatedAt = "Before", time.Now().Add(-time.Second)
+	newer := defaultStoreSettings
+	newer.Name, newer.UpdatedAt = "After", time.Now()
+
+	// A load reads the row, a save commits and caches, then the load caches
+	storeSettingsCache.RLock()
+	saves := storeSettingsCache.saves
+	storeSettingsCache.RUnlock()
+	cacheSavedStoreSettings(newer)
+	cacheLoadedStoreSettings(older, saves)
+	if got := cached(); got.Name != "After" {
+		t.Errorf("after a racing load the cache holds %q, want After", got.Name)
+	}
+
+	// Two saves cache in the opposite order to their commits
+	cacheSavedStoreSettings(older)
+	if got := cached(); got.Name != "After" {
+		t.Errorf("after an older save the cache holds %q, want After", got.Name)
+	}
+
+	// A load with no save in between is cached
+	storeSettingsCache.RLock()
+	saves = storeSettingsCache.saves
+	storeSettingsCache.RUnlock()
+	reloaded := newer
+	reloaded.Name = "Reloaded"
+	cacheLoadedStoreSettings(reloaded, saves)
+	if got := cached(); got.Name != "Reloaded" {
+		t.Errorf("after a load the cache holds %q, want Reloaded", got.Name)
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/app/api/pricing"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testCursorSecret signs cursors in tests, standing in for the CURSOR_SECRET
+// the server requires at start-up
+const testCursorSecret = "a cursor secret used only by the tests"
+
+func TestMain(m *testing.M) {
+	if os.Getenv("CURSOR_SECRET") == "" {
+		os.Setenv("CURSOR_SECRET", testCursorSecret)
+	}
+	if err := lib.LoadCursorKey(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// openTestDB points db.DB at a fresh schema in the Postgres database named
+// by DATABASE_URL, and drops it when the test ends. Tests that need Postgres
+// are skipped when DATABASE_URL is not set.
+func openTestDB(t *testing.T) {
+	t.Helper()
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	config := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent), TranslateError: true}
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	schema := "test_" + strings.ReplaceAll(uuid.Must(uuid.NewV4()).String(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	// Extensions are shared by the database, so keep pg_trgm out of the
+	// schema that gets dropped
+	if err := admin.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		t.Fatalf("create extension: %v", err)
+	}
+
+	conn, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema+",public")), config)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", schema, err)
+	}
+	if err := db.Migrate(conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	previous := db.DB
+	db.DB = conn
+	forgetStoreSettings()
+	t.Cleanup(func() {
+		db.DB = previous
+		forgetStoreSettings()
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// withSearchPath adds a search_path to a URL or keyword/value connection string
+func withSearchPath(dsn, path string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + path
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + "search_path=" + url.QueryEscape(path)
+}
+
+// createTestUser creates a customer account
+func createTestUser(t *testing.T, email string) db.User {
+	t.Helper()
+
+	user := db.User{Email: email, Role: db.RoleUser}
+	if err := db.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+// createTestCategory creates a category with a slug made from its name
+func createTestCategory(t *testing.T, name string) db.Category {
+	t.Helper()
+
+	category := db.Category{Name: name, Slug: strings.ToLower(strings.ReplaceAll(name, " ", "-"))}
+	if err := db.DB.Create(&category).Error; err != nil {
+		t.Fatalf("create category: %v", err)
+	}
+	return category
+}
+
+// createTestProduct creates a product in category with a slug made from its name
+func createTestProduct(t *testing.T, category db.Category, product db.Product) db.Product {
+	t.Helper()
+
+	product.CategoryID = category.ID
+	if product.Slug == "" {
+		product.Slug = strings.ToLower(strings.ReplaceAll(product.Name, " ", "-"))
+	}
+	product.InStock = product.StockQuantity > 0
+	if err := db.DB.Create(&product).Error; err != nil {
+		t.Fatalf("create product: %v", err)
+	}
+	return product
+}
+
+// placeTestOrder places a cash on delivery order for quantity units of product
+func placeTestOrder(t *testing.T, user db.User, product db.Product, quantity int) *db.Order {
+	t.Helper()
+
+	var req CreateOrderRequest
+	req.Items = append(req.Items, struct {
+		ProductID string `json:"productId"`
+		Quantity  int    `json:"quantity"`
+		VariantID string `json:"variantId,omitempty"`
+	}{ProductID: product.ID.String(), Quantity: quantity})
+	req.PaymentMethod = payments.ProviderCashOnDelivery
+
+	order, err := placeOrder(user.ID, req, orderAddresses{Shipping: testShippingAddress}, pricing.Defaults)
+	if err != nil {
+		t.Fatalf("place order: %v", err)
+	}
+	return order
+}
+
+// testShippingAddress is a valid Kenyan delivery address
+var testShippingAddress = db.JSON{
+	"name":    "Wanjiru Kamau",
+	"street":  "Moi Avenue",
+	"city":    "Nairobi",
+	"county":  "Nairobi",
+	"country": "Kenya",
+	"phone":   "+254712345678",
+}
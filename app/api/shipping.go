@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/pricing"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// shippingDestination is where an order is delivered
+type shippingDestination struct {
+	Country  string `json:"country"` // ISO code
+	County   string `json:"county"`
+	Postcode string `json:"postcode,omitempty"`
+}
+
+// shippingParcel is what a shipping rate is worked out from
+type shippingParcel struct {
+	Value  int // KES, after discounts
+	Weight int // Grams
+}
+
+// ShippingOption is a way of delivering to a destination, with its rate.
+// MethodID is nil for the flat rate of stores without shipping zones.
+type ShippingOption struct {
+	MethodID *uuid.UUID            `json:"methodId"`
+	Name     string                `json:"name"`
+	Type     db.ShippingMethodType `json:"type"`
+	Rate     int                   `json:"rate"`
+	MinDays  *int                  `json:"minDays"`
+	MaxDays  *int                  `json:"maxDays"`
+	Zone     string                `json:"zone,omitempty"`
+}
+
+// ShippingQuote lists the shipping options for a destination
+type ShippingQuote struct {
+	Destination shippingDestination `json:"destination"`
+	Options     []ShippingOption    `json:"options"`
+}
+
+// ShippingQuoteHandler lists the shipping options and rates for a destination
+// (country, county, postcode) and, when cartId is given, for that cart's
+// value and weight
+func ShippingQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	destination, err := newShippingDestination(query.Get("country"), query.Get("county"), query.Get("postcode"))
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to quote shipping")
+		return
+	}
+
+	// Price the cart the way checkout would, coupon included
+	var parcel shippingParcel
+	if cartID := query.Get("cartId"); cartID != "" {
+		var cart db.Cart
+		if err := loadCart(db.DB, &cart, "session_id = ?", cartID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lib.RespondWithError(w, http.StatusNotFound, "Cart not found")
+				return
+			}
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch cart")
+			return
+		}
+
+		lines := cartDiscountLines(cart)
+		var discount discountResult
+		if cart.CouponCode != nil {
+			if coupon, err := loadCoupon(db.DB, *cart.CouponCode, false); err == nil {
+				// A coupon that stopped applying just gives no discount
+				discount, _ = applyCoupon(db.DB, coupon, lines, time.Now())
+			}
+		}
+		parcel = newShippingParcel(lines, discount)
+	}
+
+	settings, err := loadPricing()
+	if err != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch store settings")
+		return
+	}
+
+	options, err := shippingOptions(db.DB, destination, parcel, settings)
+	if err != nil {
+		respondWithAPIError(w, err, "Failed to quote shipping")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, ShippingQuote{Destination: destination, Options: options})
+}
+
+// newShippingDestination validates a destination given by country (name or
+// ISO code, Kenya by default), county and postcode
+func newShippingDestination(country, county, postcode string) (shippingDestination, error) {
+	code, _, ok := normalizeCountry(country)
+	if !ok {
+		return shippingDestination{}, &apiError{http.StatusUnprocessableEntity, "We do not deliver to " + country}
+	}
+
+	destination := shippingDestination{
+		Country:  code,
+		County:   strings.TrimSpace(county),
+		Postcode: strings.TrimSpace(postcode),
+	}
+	if canonical, ok := normalizeCounty(destination.County); ok && code == "KE" {
+		destination.County = canonical
+	}
+	return destination, nil
+}
+
+// destinationFromAddress reads the destination of an order's address snapshot
+func destinationFromAddress(address db.JSON) (shippingDestination, error) {
+	country, _ := address["country"].(string)
+	county, _ := address["county"].(string)
+	if county == "" {
+		county, _ = address["state"].(string)
+	}
+	zip, _ := address["zip"].(string)
+	return newShippingDestination(country, county, zip)
+}
+
+// newShippingParcel sums the value, after the coupon, and weight of order lines
+func newShippingParcel(lines []discountLine, discount discountResult) shippingParcel {
+	parcel := shippingParcel{Value: -discount.Discount}
+	for _, line := range lines {
+		parcel.Value += line.UnitPrice * line.Quantity
+		parcel.Weight += line.Weight * line.Quantity
+	}
+	if parcel.Value < 0 {
+		parcel.Value = 0
+	}
+	return parcel
+}
+
+// unitWeight is the shipping weight of one unit, the variant's when it has one
+func unitWeight(product db.Product, variant *db.ProductVariant) int {
+	if variant != nil && variant.Weight != nil {
+		return *variant.Weight
+	}
+	if product.Weight != nil {
+		return *product.Weight
+	}
+	return 0
+}
+
+// shippingOptions lists the methods that deliver the parcel to the
+// destination, in the zone's order. Stores without shipping zones offer the
+// flat rate from the store settings.
+func shippingOptions(tx *gorm.DB, destination shippingDestination, parcel shippingParcel, settings pricing.Settings) ([]ShippingOption, error) {
+	var zones []db.ShippingZone
+	if err := tx.Preload("Regions").
+		Preload("Methods", func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("active = ?", true).Order("position ASC, created_at ASC")
+		}).
+		Preload("Methods.Tiers", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("min ASC")
+		}).
+		Where("active = ?", true).Order("position ASC, created_at ASC").Find(&zones).Error; err != nil {
+		return nil, err
+	}
+
+	if len(zones) == 0 {
+		rate := settings.ShippingRate
+		if parcel.Value >= settings.FreeShippingThreshold {
+			rate = 0
+		}
+		return []ShippingOption{{Name: "Standard delivery", Type: db.ShippingMethodStandard, Rate: rate}}, nil
+	}
+
+	zone := matchShippingZone(zones, destination)
+	if zone == nil {
+		return nil, &apiError{http.StatusUnprocessableEntity, "We do not deliver to this address yet"}
+	}
+
+	var options []ShippingOption
+	for _, method := range zone.Methods {
+		rate, ok := shippingRate(method, parcel)
+		if !ok {
+			continue
+		}
+		methodID := method.ID
+		options = append(options, ShippingOption{
+			MethodID: &methodID,
+			Name:     method.Name,
+			Type:     method.Type,
+			Rate:     rate,
+			MinDays:  method.MinDays,
+			MaxDays:  method.MaxDays,
+			Zone:     zone.Name,
+		})
+	}
+	if len(options) == 0 {
+		return nil, &apiError{http.StatusUnprocessableEntity, "No shipping method can deliver this order to this address"}
+	}
+
+	return options, nil
+}
+
+// matchShippingZone returns the first zone with a region covering the destination
+func matchShippingZone(zones []db.ShippingZone, destination shippingDestination) *db.ShippingZone {
+	for i := range zones {
+		for _, region := range zones[i].Regions {
+			if regionCovers(region, destination) {
+				return &zones[i]
+			}
+		}
+	}
+	return nil
+}
+
+// regionCovers reports whether a zone region includes the destination
+func regionCovers(region db.ShippingZoneRegion, destination shippingDestination) bool {
+	if !strings.EqualFold(region.Country, destination.Country) {
+		return false
+	}
+	if region.County != nil && countyKey(*region.County) != countyKey(destination.County) {
+		return false
+	}
+	if region.PostcodePrefix != nil &&
+		!strings.HasPrefix(strings.ToUpper(destination.Postcode), strings.ToUpper(*region.PostcodePrefix)) {
+		return false
+	}
+	return true
+}
+
+// shippingRate returns the method's rate for the parcel, or false when no
+// tier covers its value or weight
+func shippingRate(method db.ShippingMethod, parcel shippingParcel) (int, bool) {
+	measure := parcel.Value
+	if method.Basis == db.ShippingBasisWeight {
+		measure = parcel.Weight
+	}
+	for _, tier := range method.Tiers {
+		if measure >= tier.Min && (tier.Max == nil || measure < *tier.Max) {
+			return tier.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// chooseShippingOption picks the requested shipping method, or the cheapest
+// option when none is requested
+func chooseShippingOption(options []ShippingOption, methodID string) (ShippingOption, error) {
+	if methodID == "" {
+		cheapest := options[0]
+		for _, option := range options[1:] {
+			if option.Rate < cheapest.Rate {
+				cheapest = option
+			}
+		}
+		return cheapest, nil
+	}
+
+	for _, option := range options {
+		if option.MethodID != nil && option.MethodID.String() == methodID {
+			return option, nil
+		}
+	}
+	return ShippingOption{}, &apiError{http.StatusBadRequest, "The chosen shipping method is not available for this order"}
+}
+
+// details copies the option onto an order
+func (o ShippingOption) details() db.JSON {
+	details := db.JSON{
+		"name": o.Name,
+		"type": string(o.Type),
+		"rate": o.Rate,
+	}
+	if o.Zone != "" {
+		details["zone"] = o.Zone
+	}
+	if o.MinDays != nil {
+		details["minDays"] = *o.MinDays
+	}
+	if o.MaxDays != nil {
+		details["maxDays"] = *o.MaxDays
+	}
+	return details
+}
@@ -0,0 +1,377 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ShippingZoneRequest represents the admin request to create or replace a
+// shipping zone. Regions are replaced wholesale; methods are matched by ID so
+// they keep their identity, and take their position from the request order.
+type ShippingZoneRequest struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Active   *bool  `json:"active"`
+	Regions  []struct {
+		Country        string  `json:"country"`
+		County         *string `json:"county,omitempty"`
+		PostcodePrefix *string `json:"postcodePrefix,omitempty"`
+	} `json:"regions"`
+	Methods []struct {
+		ID      *string               `json:"id,omitempty"`
+		Name    string                `json:"name"`
+		Type    db.ShippingMethodType `json:"type"`
+		Basis   db.ShippingRateBasis  `json:"basis"`
+		MinDays *int                  `json:"minDays,omitempty"`
+		MaxDays *int                  `json:"maxDays,omitempty"`
+		Active  *bool                 `json:"active"`
+		Tiers   []struct {
+			Min  int  `json:"min"`
+			Max  *int `json:"max,omitempty"`
+			Rate int  `json:"rate"`
+		} `json:"tiers"`
+	} `json:"methods"`
+}
+
+// AdminShippingZonesHandler lists shipping zones (GET) or creates one (POST)
+func AdminShippingZonesHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		zones := []db.ShippingZone{}
+		if err := loadShippingZoneDetail(db.DB).Order("position ASC, created_at ASC").Find(&zones).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch shipping zones")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, zones)
+
+	case "POST":
+		var req ShippingZoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		zone, err := saveShippingZone(nil, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save shipping zone")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusCreated, zone)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// AdminShippingZoneHandler reads, replaces or deletes a shipping zone. Orders
+// keep their own copy of the shipping method they were placed with.
+func AdminShippingZoneHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	zoneID, err := uuid.FromString(r.PathValue("id"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid shipping zone ID")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var zone db.ShippingZone
+		if err := loadShippingZoneDetail(db.DB).First(&zone, "_id = ?", zoneID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lib.RespondWithError(w, http.StatusNotFound, "Shipping zone not found")
+				return
+			}
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch shipping zone")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, zone)
+
+	case "PUT":
+		var req ShippingZoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		zone, err := saveShippingZone(&zoneID, req)
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to save shipping zone")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, zone)
+
+	case "DELETE":
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			var zone db.ShippingZone
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&zone, "_id = ?", zoneID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Shipping zone not found"}
+				}
+				return err
+			}
+
+			if err := tx.Where("\"methodId\" IN (?)", tx.Model(&db.ShippingMethod{}).Select("_id").
+				Where("\"zoneId\" = ?", zoneID)).Delete(&db.ShippingRateTier{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("\"zoneId\" = ?", zoneID).Delete(&db.ShippingMethod{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("\"zoneId\" = ?", zoneID).Delete(&db.ShippingZoneRegion{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&zone).Error
+		})
+		if err != nil {
+			respondWithAPIError(w, err, "Failed to delete shipping zone")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Shipping zone deleted"})
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// saveShippingZone validates the request and creates a zone, or replaces the
+// one with zoneID, with its regions, methods and rate tiers
+func saveShippingZone(zoneID *uuid.UUID, req ShippingZoneRequest) (*db.ShippingZone, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return nil, &apiError{http.StatusBadRequest, "Name is required"}
+	}
+	if len(req.Regions) == 0 {
+		return nil, &apiError{http.StatusBadRequest, "A zone needs at least one region"}
+	}
+
+	regions := make([]db.ShippingZoneRegion, 0, len(req.Regions))
+	for _, input := range req.Regions {
+		code, _, ok := normalizeCountry(input.Country)
+		if !ok || strings.TrimSpace(input.Country) == "" {
+			return nil, &apiError{http.StatusBadRequest, "Unsupported country: " + input.Country}
+		}
+		region := db.ShippingZoneRegion{Country: code}
+
+		if input.County != nil && strings.TrimSpace(*input.County) != "" {
+			county := strings.TrimSpace(*input.County)
+			if code == "KE" {
+				if county, ok = normalizeCounty(county); !ok {
+					return nil, &apiError{http.StatusBadRequest, "Unknown county: " + *input.County}
+				}
+			}
+			region.County = &county
+		}
+		if input.PostcodePrefix != nil && strings.TrimSpace(*input.PostcodePrefix) != "" {
+			prefix := strings.ToUpper(strings.TrimSpace(*input.PostcodePrefix))
+			region.PostcodePrefix = &prefix
+		}
+		regions = append(regions, region)
+	}
+
+	methods := make([]db.ShippingMethod, 0, len(req.Methods))
+	for i, input := range req.Methods {
+		method := db.ShippingMethod{
+			Name:     strings.TrimSpace(input.Name),
+			Type:     input.Type,
+			Basis:    input.Basis,
+			MinDays:  input.MinDays,
+			MaxDays:  input.MaxDays,
+			Position: i,
+			Active:   input.Active == nil || *input.Active,
+		}
+		if method.Basis == "" {
+			method.Basis = db.ShippingBasisPrice
+		}
+
+		switch {
+		case method.Name == "":
+			return nil, &apiError{http.StatusBadRequest, "Method name is required"}
+		case !method.Type.IsValid():
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: type must be STANDARD, EXPRESS or PICKUP", method.Name)}
+		case !method.Basis.IsValid():
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: basis must be PRICE or WEIGHT", method.Name)}
+		case method.MinDays != nil && *method.MinDays < 0, method.MaxDays != nil && *method.MaxDays < 0:
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: delivery days cannot be negative", method.Name)}
+		case method.MinDays != nil && method.MaxDays != nil && *method.MinDays > *method.MaxDays:
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: minimum days cannot exceed maximum days", method.Name)}
+		case len(input.Tiers) == 0:
+			return nil, &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s needs at least one rate tier", method.Name)}
+		}
+
+		for _, tier := range input.Tiers {
+			method.Tiers = append(method.Tiers, db.ShippingRateTier{Min: tier.Min, Max: tier.Max, Rate: tier.Rate})
+		}
+		if err := checkShippingTiers(method); err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+
+	var zone db.ShippingZone
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if zoneID != nil {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Methods").
+				First(&zone, "_id = ?", *zoneID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return &apiError{http.StatusNotFound, "Shipping zone not found"}
+				}
+				return err
+			}
+		}
+
+		zone.Name = req.Name
+		zone.Position = req.Position
+		zone.Active = req.Active == nil || *req.Active
+		if err := tx.Omit(clause.Associations).Save(&zone).Error; err != nil {
+			return err
+		}
+
+		// Replace regions
+		if err := tx.Where("\"zoneId\" = ?", zone.ID).Delete(&db.ShippingZoneRegion{}).Error; err != nil {
+			return err
+		}
+		for _, region := range regions {
+			region.ZoneID = zone.ID
+			if err := tx.Create(&region).Error; err != nil {
+				return err
+			}
+		}
+
+		return saveShippingMethods(tx, zone, req, methods)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadShippingZoneDetail(db.DB).First(&zone, "_id = ?", zone.ID).Error; err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
+
+// saveShippingMethods updates methods sent with an ID, creates the rest and
+// removes methods left out of the request. Rate tiers are replaced wholesale.
+func saveShippingMethods(tx *gorm.DB, zone db.ShippingZone, req ShippingZoneRequest, methods []db.ShippingMethod) error {
+	existing := make(map[uuid.UUID]bool, len(zone.Methods))
+	for _, method := range zone.Methods {
+		existing[method.ID] = true
+	}
+
+	kept := make(map[uuid.UUID]bool, len(methods))
+	for i, input := range req.Methods {
+		if input.ID == nil {
+			continue
+		}
+		id, err := uuid.FromString(*input.ID)
+		if err != nil || !existing[id] {
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("Shipping method %s does not belong to this zone", *input.ID)}
+		}
+		methods[i].ID = id
+		kept[id] = true
+	}
+
+	var removed []uuid.UUID
+	for id := range existing {
+		if !kept[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Where("\"methodId\" IN ?", removed).Delete(&db.ShippingRateTier{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("_id IN ?", removed).Delete(&db.ShippingMethod{}).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, method := range methods {
+		tiers := method.Tiers
+		method.ZoneID = zone.ID
+		method.Tiers = nil
+
+		if method.ID != uuid.Nil {
+			if err := tx.Model(&method).Omit(clause.Associations).
+				Select("name", "type", "basis", "min_days", "max_days", "position", "active", "updated_at").
+				Updates(&method).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("\"methodId\" = ?", method.ID).Delete(&db.ShippingRateTier{}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Omit(clause.Associations).Create(&method).Error; err != nil {
+			return err
+		}
+
+		for _, tier := range tiers {
+			tier.MethodID = method.ID
+			if err := tx.Create(&tier).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkShippingTiers sorts a method's tiers and rejects negative or
+// overlapping ones. Only the highest tier may be open-ended.
+func checkShippingTiers(method db.ShippingMethod) error {
+	tiers := method.Tiers
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Min < tiers[j].Min })
+
+	for i, tier := range tiers {
+		switch {
+		case tier.Min < 0 || tier.Rate < 0:
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: tier bounds and rates cannot be negative", method.Name)}
+		case tier.Max != nil && *tier.Max <= tier.Min:
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: a tier's max must be above its min", method.Name)}
+		case i+1 < len(tiers) && (tier.Max == nil || *tier.Max > tiers[i+1].Min):
+			return &apiError{http.StatusBadRequest, fmt.Sprintf("Method %s: rate tiers overlap", method.Name)}
+		}
+	}
+	return nil
+}
+
+// loadShippingZoneDetail preloads a zone's regions and its methods with their tiers, in order
+func loadShippingZoneDetail(tx *gorm.DB) *gorm.DB {
+	return tx.Preload("Regions").
+		Preload("Methods", func(tx *gorm.DB) *gorm.DB { return tx.Order("position ASC, created_at ASC") }).
+		Preload("Methods.Tiers", func(tx *gorm.DB) *gorm.DB { return tx.Order("min ASC") })
+}
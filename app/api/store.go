@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"beauty-shop/app/api/pricing"
+)
+
+// loadPricing reads the tax and shipping rules from the store settings
+func loadPricing() (pricing.Settings, error) {
+	settings, err := loadStoreSettings()
+	if err != nil {
+		return pricing.Defaults, err
+	}
+	return settings.Pricing(), nil
+}
+
+// quoteLines turns priced order lines into pricing lines, splitting the
+// coupon's discount across them
+func quoteLines(lines []discountLine, discount discountResult) []pricing.Line {
+	quoted := make([]pricing.Line, len(lines))
+	for i, line := range lines {
+		quoted[i] = pricing.Line{UnitPrice: line.UnitPrice, Quantity: line.Quantity}
+		if i < len(discount.Lines) {
+			quoted[i].Discount = discount.Lines[i]
+		}
+	}
+	return quoted
+}
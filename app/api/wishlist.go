@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"beauty-shop/app/api/db"
+	"beauty-shop/lib"
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AddToWishlistRequest represents the request to save a product to the wishlist
+type AddToWishlistRequest struct {
+	ProductID string `json:"productId"`
+}
+
+// MoveToCartRequest represents the request to move a wishlisted product to the cart
+type MoveToCartRequest struct {
+	Quantity  int    `json:"quantity"`
+	VariantID string `json:"variantId,omitempty"`
+}
+
+// WishlistHandler lists the caller's wishlist (GET) or adds a product to it (POST)
+func WishlistHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		limit, after, err := lib.ParseCursorParams(r, "newest")
+		if err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+
+		// Archived products drop off the wishlist until they are restored
+		query := db.DB.Preload("Product").Preload("Product.Images").
+			Where("\"userId\" = ? AND \"productId\" IN (?)", userID,
+				db.DB.Model(&db.Product{}).Select("_id"))
+		if condition, args := lib.KeysetCondition("created_at", "_id", true, after); condition != "" {
+			query = query.Where(condition, args...)
+		}
+
+		var items []db.WishlistItem
+		if err := query.Order("created_at DESC, _id DESC").Limit(limit + 1).Find(&items).Error; err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch wishlist")
+			return
+		}
+
+		fetched := len(items)
+		var next lib.Cursor
+		if fetched > limit {
+			items = items[:limit]
+			last := items[limit-1]
+			next = lib.Cursor{Sort: "newest", Time: &last.CreatedAt, ID: last.ID.String()}
+		}
+		if items == nil {
+			items = []db.WishlistItem{}
+		}
+
+		pagination, err := lib.NewCursorPagination(limit, fetched, next)
+		if err != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to fetch wishlist")
+			return
+		}
+
+		lib.RespondWithSuccess(w, http.StatusOK, map[string]interface{}{
+			"items":      items,
+			"pagination": pagination,
+		})
+
+	case "POST":
+		var req AddToWishlistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		productID, err := uuid.FromString(req.ProductID)
+		if err != nil {
+			lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+			return
+		}
+
+		var product db.Product
+		if err := db.DB.First(&product, "_id = ?", productID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				lib.RespondWithError(w, http.StatusNotFound, "Product not found")
+				return
+			}
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to add to wishlist")
+			return
+		}
+
+		// Saving a product twice keeps the original entry. The alert job
+		// compares later changes against the state at the time of saving.
+		item := db.WishlistItem{
+			UserID:      userID,
+			ProductID:   productID,
+			SeenPrice:   &product.Price,
+			SeenInStock: &product.InStock,
+		}
+		result := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&item)
+		if result.Error != nil {
+			lib.RespondWithError(w, http.StatusInternalServerError, "Failed to add to wishlist")
+			return
+		}
+
+		status := http.StatusCreated
+		if result.RowsAffected == 0 {
+			status = http.StatusOK
+			if err := db.DB.Where("\"userId\" = ? AND \"productId\" = ?", userID, productID).First(&item).Error; err != nil {
+				lib.RespondWithError(w, http.StatusInternalServerError, "Failed to add to wishlist")
+				return
+			}
+		}
+		item.Product = product
+
+		lib.RespondWithSuccess(w, status, item)
+
+	default:
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// WishlistItemHandler removes a product from the caller's wishlist
+func WishlistItemHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "DELETE" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	productID, err := uuid.FromString(r.PathValue("productId"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	result := db.DB.Where("\"userId\" = ? AND \"productId\" = ?", userID, productID).Delete(&db.WishlistItem{})
+	if result.Error != nil {
+		lib.RespondWithError(w, http.StatusInternalServerError, "Failed to remove from wishlist")
+		return
+	}
+	if result.RowsAffected == 0 {
+		lib.RespondWithError(w, http.StatusNotFound, "Product is not on your wishlist")
+		return
+	}
+
+	lib.RespondWithSuccess(w, http.StatusOK, map[string]string{"message": "Removed from wishlist"})
+}
+
+// MoveToCartHandler adds a wishlisted product to the caller's cart and takes
+// it off the wishlist
+func MoveToCartHandler(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	// Handle preflight requests
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		lib.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := sessionUserID(r)
+	if err != nil {
+		lib.RespondWithError(w, http.StatusUnauthorized, "Authorization required")
+		return
+	}
+
+	productID, err := uuid.FromString(r.PathValue("productId"))
+	if err != nil {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	// The body is optional; one unit is moved by default
+	var req MoveToCartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		lib.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+	if req.Quantity < 0 {
+		lib.RespondWithError(w, http.StatusBadRequest, "Quantity must be positive")
+		return
+	}
+
+	var cart db.Cart
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		var wishlisted db.WishlistItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("\"userId\" = ? AND \"productId\" = ?", userID, productID).First(&wishlisted).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &apiError{http.StatusNotFound, "Product is not on your wishlist"}
+			}
+			return err
+		}
+
+		// Use the user's cart, starting one if they have none yet
+		if err := tx.Where(&db.Cart{UserID: &userID}).First(&cart).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			sessionID, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+			cart = db.Cart{SessionID: sessionID.String(), UserID: &userID}
+			if err := tx.Create(&cart).Error; err != nil {
+				return err
+			}
+		}
+
+		// Merge with an existing line for the same product and variant
+		var item db.CartItem
+		query := tx.Where(&db.CartItem{CartID: cart.ID, ProductID: productID})
+		if req.VariantID != "" {
+			query = query.Where("\"variantId\" = ?", req.VariantID)
+		} else {
+			query = query.Where("\"variantId\" IS NULL")
+		}
+		err := query.First(&item).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			item = db.CartItem{CartID: cart.ID, ProductID: productID}
+			if req.VariantID != "" {
+				item.VariantID = &req.VariantID
+			}
+		}
+
+		quantity := item.Quantity + req.Quantity
+		if err := checkCartStock(productID, item.VariantID, quantity); err != nil {
+			return err
+		}
+		item.Quantity = quantity
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&wishlisted).Error; err != nil {
+			return err
+		}
+		return loadCart(tx, &cart, "_id = ?", cart.ID)
+	})
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			lib.RespondWithError(w, apiErr.Status, apiErr.Message)
+			return
+		}
+		respondWithStockError(w, err)
+		return
+	}
+
+	respondWithCart(w, http.StatusOK, cart)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	handler "beauty-shop/app/api"
+	"beauty-shop/app/api/auth"
+	"beauty-shop/app/api/db"
+	"beauty-shop/app/api/middleware"
+	"beauty-shop/app/api/notify"
+	"beauty-shop/app/api/payments"
+	"beauty-shop/lib"
+)
+
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
+// chain wraps a handler with the given middleware, outermost first
+func chain(h http.HandlerFunc, mws ...func(http.Handler) http.Handler) http.Handler {
+	var wrapped http.Handler = h
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = mws[i](wrapped)
+	}
+	return wrapped
+}
+
+// newRouter mounts all API handlers on a single mux
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Public routes only need a database connection
+	public := func(h http.HandlerFunc) http.Handler {
+		return chain(h, middleware.InitDB)
+	}
+
+	// Protected routes also require a valid JWT
+	protected := func(h http.HandlerFunc) http.Handler {
+		return chain(h, middleware.InitDB, middleware.AuthMiddleware)
+	}
+
+	// Admin routes require a permission granted by the caller's role
+	permitted := func(permission db.Permission, h http.HandlerFunc) http.Handler {
+		return chain(h, middleware.InitDB, middleware.RequirePermission(permission))
+	}
+
+	// Catalog
+	mux.Handle("/products", public(handler.ProductsHandler))
+	mux.Handle("/products/{slug}", public(handler.ProductHandler))
+	mux.Handle("/products/search", public(handler.SearchHandler))
+	mux.Handle("/categories", public(handler.CategoriesHandler))
+	mux.Handle("/settings", public(handler.SettingsHandler))
+
+	// Reviews
+	mux.Handle("/products/{product}/reviews", public(handler.ProductReviewsHandler))
+	mux.Handle("POST /products/{product}/reviews", protected(handler.CreateReviewHandler))
+	mux.Handle("/reviews", protected(handler.MyReviewsHandler))
+	mux.Handle("/reviews/{id}", protected(handler.ReviewHandler))
+	mux.Handle("/reviews/{id}/helpful", protected(handler.ReviewHelpfulHandler))
+
+	// Cart
+	mux.Handle("/cart", public(handler.CartHandler))
+	mux.Handle("/cart/coupon", public(handler.CartCouponHandler))
+	mux.Handle("/cart/{id}", public(handler.CartItemHandler))
+
+	// Shipping
+	mux.Handle("/shipping/quote", public(handler.ShippingQuoteHandler))
+
+	// Authentication
+	mux.Handle("/auth/login", public(handler.AuthHandler))
+	mux.Handle("/admin/login", public(handler.AuthHandler))
+	mux.Handle("/auth/register", public(handler.RegisterHandler))
+	mux.Handle("/auth/verify-email", public(handler.VerifyEmailHandler))
+	mux.Handle("/auth/resend-verification", public(handler.ResendVerificationHandler))
+	mux.Handle("/auth/forgot-password", public(handler.ForgotPasswordHandler))
+	mux.Handle("/auth/reset-password", public(handler.ResetPasswordHandler))
+	mux.Handle("/auth/refresh", public(handler.RefreshHandler))
+	mux.Handle("/auth/logout", public(handler.LogoutHandler))
+	mux.Handle("/admin/logout", public(handler.LogoutHandler))
+	mux.Handle("/auth/logout-all", protected(handler.LogoutAllHandler))
+	mux.Handle("/auth/sessions", protected(handler.SessionsHandler))
+	mux.Handle("/auth/sessions/{id}", protected(handler.SessionHandler))
+	mux.Handle("/admin/session", protected(handler.AdminSessionHandler))
+
+	// Address book
+	mux.Handle("/addresses", protected(handler.AddressesHandler))
+	mux.Handle("/addresses/{id}", protected(handler.AddressHandler))
+
+	// Wishlist
+	mux.Handle("/wishlist", protected(handler.WishlistHandler))
+	mux.Handle("/wishlist/{productId}", protected(handler.WishlistItemHandler))
+	mux.Handle("/wishlist/{productId}/move-to-cart", protected(handler.MoveToCartHandler))
+
+	// Orders
+	mux.Handle("/orders", protected(handler.OrdersHandler))
+	mux.Handle("/orders/preview", protected(handler.OrderPreviewHandler))
+	mux.Handle("/orders/{id}", protected(handler.OrderHandler))
+
+	// Payments
+	mux.Handle("POST /payments/{provider}/callback", public(handler.PaymentCallbackHandler))
+	mux.Handle("POST /payments/{provider}/refunds/callback", public(handler.RefundCallbackHandler))
+
+	// Admin
+	mux.Handle("/admin/dashboard", permitted(db.PermissionDashboardRead, handler.DashboardHandler))
+	mux.Handle("/admin/analytics", permitted(db.PermissionDashboardRead, handler.AnalyticsHandler))
+	mux.Handle("PATCH /admin/orders/{id}/status", permitted(db.PermissionOrdersWrite, handler.OrderStatusHandler))
+	mux.Handle("/admin/orders/{id}/refunds", permitted(db.PermissionOrdersRefund, handler.RefundsHandler))
+	mux.Handle("/admin/products", permitted(db.PermissionProductsWrite, handler.AdminProductsHandler))
+	mux.Handle("/admin/products/{id}", permitted(db.PermissionProductsWrite, handler.AdminProductHandler))
+	mux.Handle("/admin/products/archived", permitted(db.PermissionProductsWrite, handler.ArchivedProductsHandler))
+	mux.Handle("POST /admin/products/{id}/restore", permitted(db.PermissionProductsWrite, handler.RestoreProductHandler))
+	mux.Handle("DELETE /admin/products/{id}/purge", permitted(db.PermissionProductsWrite, handler.PurgeProductHandler))
+	mux.Handle("/admin/categories", permitted(db.PermissionProductsWrite, handler.AdminCategoriesHandler))
+	mux.Handle("/admin/categories/{id}", permitted(db.PermissionProductsWrite, handler.AdminCategoryHandler))
+	mux.Handle("/admin/categories/archived", permitted(db.PermissionProductsWrite, handler.ArchivedCategoriesHandler))
+	mux.Handle("POST /admin/categories/{id}/restore", permitted(db.PermissionProductsWrite, handler.RestoreCategoryHandler))
+	mux.Handle("DELETE /admin/categories/{id}/purge", permitted(db.PermissionProductsWrite, handler.PurgeCategoryHandler))
+	mux.Handle("/admin/customers", permitted(db.PermissionCustomersRead, handler.AdminCustomersHandler))
+	mux.Handle("/admin/reviews", permitted(db.PermissionReviewsModerate, handler.AdminReviewsHandler))
+	mux.Handle("/admin/reviews/{id}", permitted(db.PermissionReviewsModerate, handler.AdminReviewHandler))
+	mux.Handle("/admin/coupons", permitted(db.PermissionPromotionsWrite, handler.AdminCouponsHandler))
+	mux.Handle("/admin/coupons/{id}", permitted(db.PermissionPromotionsWrite, handler.AdminCouponHandler))
+	mux.Handle("/admin/settings", permitted(db.PermissionSettingsWrite, handler.AdminSettingsHandler))
+	mux.Handle("/admin/shipping/zones", permitted(db.PermissionSettingsWrite, handler.AdminShippingZonesHandler))
+	mux.Handle("/admin/shipping/zones/{id}", permitted(db.PermissionSettingsWrite, handler.AdminShippingZoneHandler))
+	mux.Handle("/admin/roles", permitted(db.PermissionUsersManage, handler.RolesHandler))
+	mux.Handle("/admin/users/{id}/role", permitted(db.PermissionUsersManage, handler.UserRoleHandler))
+
+	return mux
+}
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Fail fast if no JWT keys are configured
+	if _, err := auth.Default(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Fail fast if pagination cursors cannot be signed
+	if err := lib.LoadCursorKey(); err != nil {
+		log.Fatalf("Failed to load cursor key: %v", err)
+	}
+
+	// Register the configured payment providers
+	if err := payments.Initialize(); err != nil {
+		log.Fatalf("Failed to set up payments: %v", err)
+	}
+
+	// Alert customers about wishlisted products in the background
+	jobs, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	if interval := wishlistAlertInterval(); interval > 0 {
+		middleware.EnsureDB()
+		go notify.NewWishlistAlerts(db.DB).Run(jobs, interval)
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           newRouter(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Start the server in the background so we can wait for signals
+	go func() {
+		log.Printf("Beauty Shop API listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Wait for an interrupt or termination signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down server...")
+	stopJobs()
+
+	// Give in-flight requests time to complete
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Graceful shutdown failed: %v", err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// wishlistAlertInterval reads WISHLIST_ALERT_INTERVAL (a duration such as
+// "10m"); zero or a negative value disables the wishlist alert job
+func wishlistAlertInterval() time.Duration {
+	raw := os.Getenv("WISHLIST_ALERT_INTERVAL")
+	if raw == "" {
+		return notify.DefaultInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Fatalf("Invalid WISHLIST_ALERT_INTERVAL: %v", err)
+	}
+	return interval
+}
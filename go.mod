@@ -1,3 +1,3 @@
 module beauty-shop
 
-go 1.20
+go 1.22
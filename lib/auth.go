@@ -2,94 +2,14 @@ package lib
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"net/http"
-	"os"
-	"strings"
-	"time"
 
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/gofrs/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// JWT claims struct
-type Claims struct {
-	UserID string `json:"userId"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
-	jwt.RegisteredClaims
-}
-
-// GetJWTSecret returns the JWT secret key from environment or default
-func GetJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// In production, always use an environment variable
-		secret = "your-secret-key-change-in-production"
-	}
-	return []byte(secret)
-}
-
-// GenerateJWT creates a new JWT token for a user
-func GenerateJWT(userID uuid.UUID, email, role string) (string, error) {
-	// Set expiration time
-	expirationTime := time.Now().Add(24 * time.Hour)
-	
-	// Create claims
-	claims := &Claims{
-		UserID: userID.String(),
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
-	
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	
-	// Sign token with secret key
-	return token.SignedString(GetJWTSecret())
-}
-
-// ValidateJWT validates a JWT token and returns the claims
-func ValidateJWT(tokenString string) (*Claims, error) {
-	// Parse token
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return GetJWTSecret(), nil
-	})
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	if !token.Valid {
-		return nil, errors.New("invalid token")
-	}
-	
-	return claims, nil
-}
-
-// ExtractTokenFromRequest extracts the JWT token from the Authorization header
-func ExtractTokenFromRequest(r *http.Request) (string, error) {
-	// Get Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", errors.New("authorization header is required")
-	}
-	
-	// Check format
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return "", errors.New("authorization header format must be Bearer {token}")
-	}
-	
-	return parts[1], nil
-}
-
 // GetUserFromContext extracts user information from the request context
 func GetUserFromContext(ctx context.Context) (userID string, email string, role string, err error) {
 	// Get user ID
@@ -125,3 +45,17 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// GenerateSecureToken creates a random hex token from n bytes of crypto/rand
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hex digest of a token for storage
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,8 +1,19 @@
 package lib
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // PaginationResult represents pagination metadata
@@ -53,3 +64,171 @@ func NewPaginatedResponse(data interface{}, pagination PaginationResult) Paginat
 }
 
 
+
+// MaxCursorLimit caps the page size of cursor-paginated lists
+const MaxCursorLimit = 100
+
+// ErrInvalidCursor is returned for cursors that are malformed, tampered
+// with or issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the keyset position after the last row of a page: the value of
+// the sort column and the row ID that breaks ties. Exactly one of Time and
+// Number is set.
+type Cursor struct {
+	Sort   string     `json:"s"`
+	Time   *time.Time `json:"t,omitempty"`
+	Number *float64   `json:"n,omitempty"`
+	ID     string     `json:"id"`
+}
+
+// CursorPagination represents keyset pagination metadata
+type CursorPagination struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasNext    bool   `json:"hasNext"`
+}
+
+// minCursorSecretLength is the shortest CURSOR_SECRET accepted, in bytes
+const minCursorSecretLength = 32
+
+var (
+	cursorKey     []byte
+	cursorKeyErr  error
+	cursorKeyOnce sync.Once
+)
+
+// LoadCursorKey reads the key cursors are signed with from CURSOR_SECRET,
+// which must be set and at least 32 bytes long. The server calls it at
+// start-up so a missing secret stops it before any cursor is issued.
+func LoadCursorKey() error {
+	cursorKeyOnce.Do(func() {
+		secret := os.Getenv("CURSOR_SECRET")
+		switch {
+		case secret == "":
+			cursorKeyErr = errors.New("lib: CURSOR_SECRET must be set")
+		case len(secret) < minCursorSecretLength:
+			cursorKeyErr = fmt.Errorf("lib: CURSOR_SECRET must be at least %d bytes", minCursorSecretLength)
+		default:
+			cursorKey = []byte(secret)
+		}
+	})
+	return cursorKeyErr
+}
+
+// signCursor returns the HMAC of an encoded cursor payload, or the error of
+// LoadCursorKey when there is no key to sign with
+func signCursor(payload string) ([]byte, error) {
+	if err := LoadCursorKey(); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil), nil
+}
+
+// EncodeCursor turns a cursor into an opaque, signed token. It fails only
+// when CURSOR_SECRET is missing or too short.
+func EncodeCursor(c Cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature, err := signCursor(payload)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// DecodeCursor verifies a token from EncodeCursor and returns its cursor.
+// Without a CURSOR_SECRET no token can be verified, so every one is invalid.
+func DecodeCursor(token string) (*Cursor, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	expected, err := signCursor(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, expected) {
+		return nil, ErrInvalidCursor
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" || (c.Time == nil) == (c.Number == nil) {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ParseCursorParams reads the limit and cursor parameters of a keyset
+// paginated request. The cursor must have been issued for the same sort.
+func ParseCursorParams(r *http.Request, sort string) (limit int, after *Cursor, err error) {
+	limit = 10
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+	if limit > MaxCursorLimit {
+		limit = MaxCursorLimit
+	}
+
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		after, err = DecodeCursor(token)
+		if err != nil {
+			return limit, nil, err
+		}
+		if after.Sort != sort {
+			return limit, nil, ErrInvalidCursor
+		}
+	}
+
+	return limit, after, nil
+}
+
+// KeysetCondition returns a WHERE clause selecting the rows after the cursor
+// for a list ordered by column and then idColumn, both descending or both
+// ascending. It returns an empty clause when there is no cursor.
+func KeysetCondition(column, idColumn string, desc bool, after *Cursor) (string, []interface{}) {
+	if after == nil {
+		return "", nil
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+
+	var value interface{}
+	if after.Time != nil {
+		value = *after.Time
+	} else {
+		value = *after.Number
+	}
+
+	return "(" + column + ", " + idColumn + ") " + op + " (?, ?)", []interface{}{value, after.ID}
+}
+
+// NewCursorPagination builds pagination metadata for a page fetched with
+// limit+1 rows; next is the cursor of the page's last row when more follow
+func NewCursorPagination(limit int, fetched int, next Cursor) (CursorPagination, error) {
+	pagination := CursorPagination{Limit: limit, HasNext: fetched > limit}
+	if pagination.HasNext {
+		var err error
+		if pagination.NextCursor, err = EncodeCursor(next); err != nil {
+			return pagination, err
+		}
+	}
+	return pagination, nil
+}